@@ -2,12 +2,14 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt" // Using log for deprecation warnings, similar to Python's warnings
 	"reflect"
 
 	"github.com/robnmrz/onigiri/memory"
 	"github.com/robnmrz/onigiri/prompt"
+	"github.com/robnmrz/onigiri/utils"
 )
 
 var (
@@ -19,11 +21,14 @@ var (
 
 // LLMClient defines the interface for interacting with a Language Model.
 type LLMClient interface {
-	CreateCompletion(messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (CompletionResponse, error)
+	CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (CompletionResponse, error)
 }
 
 type CompletionResponse struct {
 	Prompt string
+	// Backend names the backend that served the completion, if the client
+	// routes between several ones
+	Backend string `json:",omitempty"`
 }
 
 // AgentConfig holds the configuration for BaseAgent, applied via options.
@@ -36,23 +41,39 @@ type AgentConfig struct {
 	systemPromptGenerator *prompt.SystemPromptGenerator
 	systemRole            string
 	modelApiParameters    map[string]any
+	promptRenderer        prompt.PromptRenderer
+	promptName            string
+	promptVersion         string
+	semanticCache         SemanticCache
+	onSemanticCacheError  func(err error)
 }
 
 // AgentOption defines the functional option type.
 type AgentOption func(*AgentConfig) error
 
 // BaseAgent is the core implementation for chat agents.
+// It is safe for concurrent use, concurrent calls to Run are serialized.
 type BaseAgent struct {
+	// runLock is a one slot semaphore held for the duration of a Run,
+	// a channel is used so that waiting can be cancelled via context
+	runLock chan struct{}
+
 	client                LLMClient
 	model                 string
 	memory                *memory.AgentMemory
-	initialMemory         *memory.AgentMemory
+	initialMemory         memory.MemorySnapshot
 	systemPromptGenerator *prompt.SystemPromptGenerator
 	systemRole            string
 	modelApiParameters    map[string]any
-	// inputSchema           reflect.Type
-	outputSchema     reflect.Type
-	currentUserInput any
+	promptRenderer        prompt.PromptRenderer
+	// promptName and promptVersion identify the registered prompt in use
+	promptName           string
+	promptVersion        string
+	semanticCache        SemanticCache
+	onSemanticCacheError func(err error)
+	inputSchema          reflect.Type
+	outputSchema         reflect.Type
+	currentUserInput     any
 }
 
 // WithInputSchema sets the expected input type for the agent.
@@ -121,6 +142,36 @@ func WithMemory(memory *memory.AgentMemory) AgentOption {
 func WithSystemPromptGenerator(spg *prompt.SystemPromptGenerator) AgentOption {
 	return func(cfg *AgentConfig) error {
 		cfg.systemPromptGenerator = spg
+		cfg.promptName = ""
+		cfg.promptVersion = ""
+		return nil
+	}
+}
+
+// WithRegisteredPrompt uses the system prompt generator registered under the
+// name and version, an empty version uses the latest one. The prompt's name,
+// version and hash are recorded with every assistant message.
+func WithRegisteredPrompt(registry *prompt.PromptRegistry, name string, version string) AgentOption {
+	return func(cfg *AgentConfig) error {
+		if registry == nil {
+			return errors.New("prompt registry cannot be nil")
+		}
+		registered, err := registry.Get(name, version)
+		if err != nil {
+			return err
+		}
+		cfg.systemPromptGenerator = registered.Generator
+		cfg.promptName = registered.Name
+		cfg.promptVersion = registered.Version
+		return nil
+	}
+}
+
+// WithPromptRenderer sets the renderer used for this agent's system prompt,
+// overriding the renderer of the system prompt generator.
+func WithPromptRenderer(renderer prompt.PromptRenderer) AgentOption {
+	return func(cfg *AgentConfig) error {
+		cfg.promptRenderer = renderer
 		return nil
 	}
 }
@@ -168,57 +219,207 @@ func NewBaseAgent(opts ...AgentOption) (*BaseAgent, error) {
 		}
 	}
 
+	// Fall back to an empty memory and prompt generator
+	if cfg.memory == nil {
+		cfg.memory = memory.NewAgentMemory()
+	}
+	if cfg.systemPromptGenerator == nil {
+		cfg.systemPromptGenerator = prompt.NewSystemPromptGenerator()
+	}
+
+	// Examples have to match the schemas, otherwise they'd mislead the model
+	for i, example := range cfg.systemPromptGenerator.Examples() {
+		if err := validateExampleValue(example.Input, cfg.inputSchema); err != nil {
+			return nil, fmt.Errorf("invalid input of example %d: %w", i, err)
+		}
+		if err := validateExampleValue(example.Output, cfg.outputSchema); err != nil {
+			return nil, fmt.Errorf("invalid output of example %d: %w", i, err)
+		}
+	}
+
 	// Create the agent
 	agent := &BaseAgent{
+		runLock:               make(chan struct{}, 1),
 		client:                cfg.client,
 		model:                 cfg.model,
 		memory:                cfg.memory,
 		systemPromptGenerator: cfg.systemPromptGenerator,
 		systemRole:            cfg.systemRole,
 		modelApiParameters:    cfg.modelApiParameters,
+		promptRenderer:        cfg.promptRenderer,
+		promptName:            cfg.promptName,
+		promptVersion:         cfg.promptVersion,
+		semanticCache:         cfg.semanticCache,
+		onSemanticCacheError:  cfg.onSemanticCacheError,
+		inputSchema:           cfg.inputSchema,
+		outputSchema:          cfg.outputSchema,
 	}
 
 	// Store the initial memory state for resets
-	agent.initialMemory = agent.memory.Copy()
+	agent.initialMemory = agent.memory.Snapshot()
 
 	return agent, nil
 }
 
+// validateExampleValue checks that an example value matches the schema
+// and can be encoded to json.
+func validateExampleValue(value any, schema reflect.Type) error {
+	valueType := reflect.TypeOf(value)
+	if valueType == nil {
+		return errors.New("value cannot be nil")
+	}
+	if valueType.Kind() == reflect.Pointer {
+		valueType = valueType.Elem()
+	}
+	if !valueType.AssignableTo(schema) {
+		return fmt.Errorf("type %v does not match schema %v", valueType, schema)
+	}
+	if _, err := json.Marshal(value); err != nil {
+		return fmt.Errorf("value cannot be encoded to json: %w", err)
+	}
+	return nil
+}
+
+// acquireRun waits until no other Run is in progress or the context is done.
+func (a *BaseAgent) acquireRun(ctx context.Context) error {
+	select {
+	case a.runLock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for in-flight run: %w", ctx.Err())
+	}
+}
+
+// releaseRun frees the run slot taken by acquireRun.
+func (a *BaseAgent) releaseRun() {
+	<-a.runLock
+}
+
 // ResetMemory resets the agent's memory to its initial state.
+// It waits for an in-flight Run to finish first.
 func (a *BaseAgent) ResetMemory() {
-	a.memory = a.initialMemory.Copy()
+	_ = a.acquireRun(context.Background())
+	defer a.releaseRun()
+
+	// The initial snapshot is never empty, so restoring can't fail
+	_ = a.memory.Restore(a.initialMemory)
+	a.currentUserInput = nil
+}
+
+// GetMemory returns the agent's memory.
+func (a *BaseAgent) GetMemory() *memory.AgentMemory {
+	return a.memory
+}
+
+// Checkpoint takes a snapshot of the agent's current memory,
+// which can be restored with Rollback.
+// It waits for an in-flight Run to finish first, so a checkpoint never
+// holds a user message without the assistant's reply.
+func (a *BaseAgent) Checkpoint() memory.MemorySnapshot {
+	_ = a.acquireRun(context.Background())
+	defer a.releaseRun()
+
+	return a.memory.Snapshot()
 }
 
-func (a *BaseAgent) GetResponse() (CompletionResponse, error) {
+// Rollback restores the agent's memory to a previously taken checkpoint.
+// It waits for an in-flight Run to finish first.
+func (a *BaseAgent) Rollback(snapshot memory.MemorySnapshot) error {
+	_ = a.acquireRun(context.Background())
+	defer a.releaseRun()
+
+	if err := a.memory.Restore(snapshot); err != nil {
+		return err
+	}
+	// The input of the last run may not be part of the restored history
+	a.currentUserInput = nil
+	return nil
+}
+
+// GetResponse requests a completion for the system prompt and the memory's history.
+func (a *BaseAgent) GetResponse(ctx context.Context) (CompletionResponse, error) {
+	response, _, err := a.getResponse(ctx)
+	return response, err
+}
+
+// getResponse requests a completion like GetResponse and also returns the
+// metadata of the system prompt that was sent, nil if there was none.
+func (a *BaseAgent) getResponse(ctx context.Context) (CompletionResponse, map[string]string, error) {
+	messages, promptMetadata, err := a.buildMessages(ctx)
+	if err != nil {
+		return CompletionResponse{}, nil, err
+	}
+
+	response, err := a.client.CreateCompletion(ctx, messages, a.outputSchema, a.model, a.modelApiParameters)
+	if err != nil {
+		return CompletionResponse{}, nil, err
+	}
+	return response, promptMetadata, nil
+}
+
+// buildMessages returns the messages of a completion request, the system
+// prompt, examples and history, and the metadata of the system prompt.
+func (a *BaseAgent) buildMessages(ctx context.Context) ([]memory.Message, map[string]string, error) {
 	var messages []memory.Message
-	responseModel := a.outputSchema
+	var promptMetadata map[string]string
 
 	// Omit system prompt if role is empty
 	if a.systemRole == "" {
 		messages = []memory.Message{}
 	} else {
+		// Let context providers tailor their info to the current input
+		if a.currentUserInput != nil {
+			ctx = prompt.ContextWithUserInput(ctx, a.currentUserInput)
+		}
+		systemPrompt, err := prompt.PromptVersion{
+			Name:      a.promptName,
+			Version:   a.promptVersion,
+			Generator: a.systemPromptGenerator,
+		}.Render(ctx, a.promptRenderer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate system prompt: %w", err)
+		}
+		promptMetadata = systemPrompt.Metadata()
 		messages = []memory.Message{
 			{
 				Role: a.systemRole,
 				Content: memory.MessageContent{
 					TypeName: "string",
-					Content:  a.systemPromptGenerator.GeneratePrompt(),
+					Content:  systemPrompt.Prompt,
 				},
 			},
 		}
 	}
 
-	// Add messages from memory
-	messages = append(messages, a.memory.History...)
-
-	response, err := a.client.CreateCompletion(messages, responseModel, a.model, a.modelApiParameters)
-	if err != nil {
-		return CompletionResponse{}, err
+	// Add examples as synthetic conversation ahead of the real one
+	if a.systemPromptGenerator.ExampleMode() == prompt.ExamplesAsMessages {
+		for _, example := range a.systemPromptGenerator.Examples() {
+			messages = append(messages,
+				memory.Message{
+					Role:    "user",
+					Content: memory.MessageContent{TypeName: utils.GetTypeName(example.Input), Content: example.Input},
+				},
+				memory.Message{
+					Role:    "assistant",
+					Content: memory.MessageContent{TypeName: utils.GetTypeName(example.Output), Content: example.Output},
+				},
+			)
+		}
 	}
-	return response, nil
+
+	// Add messages from memory
+	messages = append(messages, a.memory.GetHistory()...)
+	return messages, promptMetadata, nil
 }
 
+// Run adds the user input to memory, requests a completion and stores the
+// assistant response. Concurrent calls on the same agent are serialized,
+// a call waiting for its turn returns early if the context is done.
 func (a *BaseAgent) Run(ctx context.Context, userInput any) (CompletionResponse, error) {
+	if err := a.acquireRun(ctx); err != nil {
+		return CompletionResponse{}, err
+	}
+	defer a.releaseRun()
 
 	// Init a new turn when user gives input
 	if userInput != nil {
@@ -227,23 +428,83 @@ func (a *BaseAgent) Run(ctx context.Context, userInput any) (CompletionResponse,
 		a.currentUserInput = userInput
 	}
 
-	response, err := a.GetResponse()
+	if a.semanticCache == nil || userInput == nil {
+		response, promptMetadata, err := a.getResponse(ctx)
+		if err != nil {
+			return CompletionResponse{}, fmt.Errorf("LLM completion failed: %w", err) // Error already includes context from GetResponse
+		}
+
+		// Add assistant response to memory, recording the prompt it was generated with
+		a.memory.AddMessageWithMetadata("assistant", response, promptMetadata)
+		return response, nil
+	}
+	return a.runCached(ctx, userInput)
+}
+
+// runCached answers the user input from the semantic cache if a similar
+// input was answered with the same system prompt before, otherwise it
+// requests a completion and caches it. The cache only saves requests,
+// when it fails the model is asked and the error is reported to the hook.
+func (a *BaseAgent) runCached(ctx context.Context, userInput any) (CompletionResponse, error) {
+	messages, promptMetadata, err := a.buildMessages(ctx)
+	if err != nil {
+		return CompletionResponse{}, fmt.Errorf("LLM completion failed: %w", err)
+	}
+	promptHash := promptMetadata[prompt.MetadataPromptHash]
+
+	input, err := cacheInput(userInput)
+	cacheable := err == nil
+	if !cacheable {
+		a.semanticCacheError(err)
+	} else if hit, ok, err := a.semanticCache.Lookup(ctx, input, promptHash); err != nil {
+		a.semanticCacheError(fmt.Errorf("semantic cache lookup failed: %w", err))
+	} else if ok {
+		a.memory.AddMessageWithMetadata("assistant", hit.Response, hit.metadata(promptMetadata))
+		return hit.Response, nil
+	}
+
+	response, err := a.client.CreateCompletion(ctx, messages, a.outputSchema, a.model, a.modelApiParameters)
 	if err != nil {
-		return CompletionResponse{}, fmt.Errorf("LLM completion failed: %w", err) // Error already includes context from GetResponse
+		return CompletionResponse{}, fmt.Errorf("LLM completion failed: %w", err)
 	}
+	a.memory.AddMessageWithMetadata("assistant", response, promptMetadata)
 
-	// Add assistant response to memory
-	a.memory.AddMessage("assistant", response)
+	if cacheable {
+		if err := a.semanticCache.Store(ctx, input, promptHash, response); err != nil {
+			a.semanticCacheError(fmt.Errorf("failed to store response in semantic cache: %w", err))
+		}
+	}
 	return response, nil
 }
 
+// semanticCacheError reports a failure of the semantic cache to the hook
+func (a *BaseAgent) semanticCacheError(err error) {
+	if a.onSemanticCacheError != nil {
+		a.onSemanticCacheError(err)
+	}
+}
+
 // GetContextProvider retrieves a context provider by name from the SystemPromptGenerator.
 func (a *BaseAgent) GetContextProvider(providerName string) (prompt.SystemPromptContextProviderBase, error) {
 	if a.systemPromptGenerator == nil {
 		return nil, errors.New("system prompt generator is not configured")
 	}
 
-	provider, ok := a.systemPromptGenerator.ContextProviders[providerName]
+	provider, ok := a.systemPromptGenerator.GetContextProvider(providerName)
+	if !ok {
+		return nil, fmt.Errorf("context provider '%s' not found", providerName)
+	}
+	return provider, nil
+}
+
+// GetFallibleContextProvider retrieves a context provider by name from the SystemPromptGenerator,
+// including providers registered with RegisterFallibleContextProvider.
+func (a *BaseAgent) GetFallibleContextProvider(providerName string) (prompt.ContextProvider, error) {
+	if a.systemPromptGenerator == nil {
+		return nil, errors.New("system prompt generator is not configured")
+	}
+
+	provider, ok := a.systemPromptGenerator.GetFallibleContextProvider(providerName)
 	if !ok {
 		return nil, fmt.Errorf("context provider '%s' not found", providerName)
 	}
@@ -251,7 +512,20 @@ func (a *BaseAgent) GetContextProvider(providerName string) (prompt.SystemPrompt
 }
 
 // RegisterContextProvider registers a new context provider with the SystemPromptGenerator.
-func (a *BaseAgent) RegisterContextProvider(providerName string, provider prompt.SystemPromptContextProviderBase) error {
+func (a *BaseAgent) RegisterContextProvider(providerName string, provider prompt.SystemPromptContextProviderBase, opts ...prompt.ContextProviderOption) error {
+	if a.systemPromptGenerator == nil {
+		return errors.New("system prompt generator is not configured")
+	}
+
+	if providerName == "" {
+		return errors.New("provider name cannot be empty")
+	}
+	a.systemPromptGenerator.RegisterContextProvider(providerName, provider, opts...)
+	return nil
+}
+
+// RegisterFallibleContextProvider registers a new fallible context provider with the SystemPromptGenerator.
+func (a *BaseAgent) RegisterFallibleContextProvider(providerName string, provider prompt.ContextProvider, opts ...prompt.ContextProviderOption) error {
 	if a.systemPromptGenerator == nil {
 		return errors.New("system prompt generator is not configured")
 	}
@@ -259,7 +533,7 @@ func (a *BaseAgent) RegisterContextProvider(providerName string, provider prompt
 	if providerName == "" {
 		return errors.New("provider name cannot be empty")
 	}
-	a.systemPromptGenerator.ContextProviders[providerName] = provider
+	a.systemPromptGenerator.RegisterFallibleContextProvider(providerName, provider, opts...)
 	return nil
 }
 
@@ -272,6 +546,6 @@ func (a *BaseAgent) UnregisterContextProvider(providerName string) error {
 		return errors.New("provider name cannot be empty")
 	}
 
-	delete(a.systemPromptGenerator.ContextProviders, providerName)
+	a.systemPromptGenerator.UnregisterContextProvider(providerName)
 	return nil
 }
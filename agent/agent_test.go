@@ -0,0 +1,479 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/robnmrz/onigiri/prompt"
+	"github.com/robnmrz/onigiri/providers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Mock LLM client
+type MockLLMClient struct {
+	mock.Mock
+}
+
+func (m *MockLLMClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (CompletionResponse, error) {
+	args := m.Called(messages, responseSchema, model, modelApiParameters)
+	return args.Get(0).(CompletionResponse), args.Error(1)
+}
+
+// Mock context provider
+type MockContextProvider struct {
+	mock.Mock
+}
+
+func (m *MockContextProvider) GetInfo() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockContextProvider) GetTitle() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func newTestAgent(t *testing.T, client LLMClient, opts ...AgentOption) *BaseAgent {
+	t.Helper()
+	opts = append([]AgentOption{WithClient(client), WithModel("test-model")}, opts...)
+	agent, err := NewBaseAgent(opts...)
+	assert.NoError(t, err)
+	return agent
+}
+
+func TestNewBaseAgent_Defaults(t *testing.T) {
+	agent := newTestAgent(t, new(MockLLMClient))
+
+	assert.NotNil(t, agent.memory)
+	assert.NotNil(t, agent.systemPromptGenerator)
+	assert.Equal(t, "system", agent.systemRole)
+}
+
+func TestNewBaseAgent_InvalidOption(t *testing.T) {
+	_, err := NewBaseAgent(WithModel(""))
+	assert.Error(t, err)
+}
+
+func TestRun_AddsMessagesToMemory(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, "test-model", mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi there"}, nil)
+	agent := newTestAgent(t, client)
+
+	response, err := agent.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi there", response.Prompt)
+
+	history := agent.memory.GetHistory()
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, "user", history[0].Role)
+	assert.Equal(t, "assistant", history[1].Role)
+
+	// The system prompt is sent ahead of the history
+	messages := client.Calls[0].Arguments.Get(0).([]memory.Message)
+	assert.Equal(t, "system", messages[0].Role)
+	assert.Equal(t, "Hello", messages[1].Content.Content)
+}
+
+func TestRun_PromptRenderer(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+	spg := prompt.NewSystemPromptGenerator(prompt.WithBackground([]string{"Be helpful."}))
+	agent := newTestAgent(t, client, WithSystemPromptGenerator(spg), WithPromptRenderer(prompt.XMLRenderer{}))
+
+	_, err := agent.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+
+	messages := client.Calls[0].Arguments.Get(0).([]memory.Message)
+	assert.Equal(t, "<background>\n- Be helpful.\n</background>", messages[0].Content.Content)
+}
+
+func TestRun_RecordsRegisteredPrompt(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+	registry := prompt.NewPromptRegistry()
+	assert.NoError(t, registry.Register("support", "v1", prompt.NewSystemPromptGenerator(prompt.WithBackground([]string{"Old."}))))
+	assert.NoError(t, registry.Register("support", "v2", prompt.NewSystemPromptGenerator(prompt.WithBackground([]string{"New."}))))
+
+	agent := newTestAgent(t, client, WithRegisteredPrompt(registry, "support", "v1"))
+	_, err := agent.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+
+	systemPrompt := client.Calls[0].Arguments.Get(0).([]memory.Message)[0].Content.Content.(string)
+	history := agent.memory.GetHistory()
+	assert.Nil(t, history[0].Metadata)
+	assert.Equal(t, map[string]string{
+		prompt.MetadataPromptName:    "support",
+		prompt.MetadataPromptVersion: "v1",
+		prompt.MetadataPromptHash:    prompt.HashPrompt(systemPrompt),
+	}, history[1].Metadata)
+
+	// An empty version uses the latest one
+	latest := newTestAgent(t, client, WithRegisteredPrompt(registry, "support", ""))
+	assert.Equal(t, "v2", latest.promptVersion)
+
+	_, err = NewBaseAgent(WithClient(client), WithModel("test-model"), WithRegisteredPrompt(registry, "missing", ""))
+	assert.ErrorIs(t, err, prompt.ErrPromptNotFound)
+}
+
+func TestRun_RecordsPromptHash(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+
+	// Unregistered prompts are only identified by their hash
+	agent := newTestAgent(t, client)
+	_, err := agent.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{prompt.MetadataPromptHash: prompt.HashPrompt("")}, agent.memory.GetHistory()[1].Metadata)
+
+	// Without a system prompt there is nothing to record
+	agent = newTestAgent(t, client, WithSystemRole(""))
+	_, err = agent.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	assert.Nil(t, agent.memory.GetHistory()[1].Metadata)
+}
+
+// Typed example output
+type Answer struct {
+	Value int `json:"value"`
+}
+
+func TestNewBaseAgent_ExamplesMustMatchSchemas(t *testing.T) {
+	spg := prompt.NewSystemPromptGenerator(prompt.WithExamples(prompt.Example{Input: "What is 2+2?", Output: Answer{Value: 4}}))
+
+	// The default output schema is string
+	_, err := NewBaseAgent(WithClient(new(MockLLMClient)), WithModel("test-model"), WithSystemPromptGenerator(spg))
+	assert.ErrorContains(t, err, "invalid output of example 0")
+
+	_, err = NewBaseAgent(WithClient(new(MockLLMClient)), WithModel("test-model"), WithSystemPromptGenerator(spg),
+		WithOutputSchema(reflect.TypeOf(Answer{})))
+	assert.NoError(t, err)
+}
+
+func TestRun_ExamplesAsMessages(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, reflect.TypeOf(Answer{}), mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+	spg := prompt.NewSystemPromptGenerator(
+		prompt.WithBackground([]string{"Answer math questions."}),
+		prompt.WithExamples(prompt.Example{Input: "What is 2+2?", Output: &Answer{Value: 4}}),
+		prompt.WithExampleMode(prompt.ExamplesAsMessages),
+	)
+	agent := newTestAgent(t, client, WithSystemPromptGenerator(spg), WithOutputSchema(reflect.TypeOf(Answer{})))
+
+	_, err := agent.Run(context.Background(), "What is 3+3?")
+	assert.NoError(t, err)
+
+	messages := client.Calls[0].Arguments.Get(0).([]memory.Message)
+	assert.Equal(t, 4, len(messages))
+	assert.Equal(t, "system", messages[0].Role)
+	assert.Equal(t, "user", messages[1].Role)
+	assert.Equal(t, "What is 2+2?", messages[1].Content.Content)
+	assert.Equal(t, "assistant", messages[2].Role)
+	assert.Equal(t, "Answer", messages[2].Content.TypeName)
+	assert.Equal(t, "What is 3+3?", messages[3].Content.Content)
+
+	// Examples are not stored in memory
+	assert.Equal(t, 2, agent.memory.GetMessageCount())
+}
+
+func TestRun_ClientError(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{}, fmt.Errorf("boom"))
+	agent := newTestAgent(t, client)
+
+	_, err := agent.Run(context.Background(), "Hello")
+	assert.ErrorContains(t, err, "boom")
+}
+
+func TestRun_ContextDoneWhileWaiting(t *testing.T) {
+	agent := newTestAgent(t, new(MockLLMClient))
+
+	// Simulate an in-flight run
+	assert.NoError(t, agent.acquireRun(context.Background()))
+	defer agent.releaseRun()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := agent.Run(ctx, "Hello")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestResetMemory(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+	initial := memory.NewAgentMemory()
+	initial.AddMessage("user", "initial message")
+	agent := newTestAgent(t, client, WithMemory(initial))
+
+	_, err := agent.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, agent.memory.GetMessageCount())
+
+	agent.ResetMemory()
+	assert.Equal(t, 1, agent.memory.GetMessageCount())
+
+	// Resetting twice still yields the initial state
+	_, err = agent.Run(context.Background(), "Hello again")
+	assert.NoError(t, err)
+	agent.ResetMemory()
+	assert.Equal(t, 1, agent.memory.GetMessageCount())
+}
+
+func TestCheckpointAndRollback(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+	agent := newTestAgent(t, client)
+
+	_, err := agent.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	checkpoint := agent.Checkpoint()
+
+	_, err = agent.Run(context.Background(), "Risky")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, agent.memory.GetMessageCount())
+
+	err = agent.Rollback(checkpoint)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, agent.memory.GetMessageCount())
+}
+
+// Fake LLM client signalling when it is called and blocking until released
+type BlockingLLMClient struct {
+	called  chan struct{}
+	release chan struct{}
+}
+
+func (c *BlockingLLMClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (CompletionResponse, error) {
+	c.called <- struct{}{}
+	<-c.release
+	return CompletionResponse{Prompt: "Hi"}, nil
+}
+
+func TestCheckpoint_WaitsForRun(t *testing.T) {
+	client := &BlockingLLMClient{called: make(chan struct{}, 1), release: make(chan struct{})}
+	agent := newTestAgent(t, client)
+
+	go func() {
+		_, err := agent.Run(context.Background(), "Hello")
+		assert.NoError(t, err)
+	}()
+	<-client.called
+
+	// The checkpoint is only taken once the turn is complete
+	checkpoints := make(chan memory.MemorySnapshot)
+	go func() { checkpoints <- agent.Checkpoint() }()
+	select {
+	case <-checkpoints:
+		t.Fatal("checkpoint taken during a run")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(client.release)
+	checkpoint := <-checkpoints
+
+	agent.ResetMemory()
+	assert.NoError(t, agent.Rollback(checkpoint))
+	assert.Equal(t, 2, agent.memory.GetMessageCount())
+}
+
+func TestContextProviderRegistration(t *testing.T) {
+	agent := newTestAgent(t, new(MockLLMClient))
+	provider := new(MockContextProvider)
+
+	err := agent.RegisterContextProvider("mock", provider)
+	assert.NoError(t, err)
+
+	registered, err := agent.GetContextProvider("mock")
+	assert.NoError(t, err)
+	assert.Equal(t, provider, registered)
+
+	err = agent.UnregisterContextProvider("mock")
+	assert.NoError(t, err)
+	_, err = agent.GetContextProvider("mock")
+	assert.Error(t, err)
+}
+
+func TestFallibleContextProviderRegistration(t *testing.T) {
+	agent := newTestAgent(t, new(MockLLMClient))
+	assert.NoError(t, agent.RegisterFallibleContextProvider("failing", FailingContextProvider{}))
+
+	registered, err := agent.GetFallibleContextProvider("failing")
+	assert.NoError(t, err)
+	assert.Equal(t, FailingContextProvider{}, registered)
+	_, err = agent.GetContextProvider("failing")
+	assert.Error(t, err)
+}
+
+func TestRegisterFallibleContextProvider_Providers(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+	agent := newTestAgent(t, client, WithSystemPromptGenerator(prompt.NewSystemPromptGenerator()))
+
+	// The ready-made providers are registered as fallible providers
+	facts := providers.NewFactsProvider("Customer", map[string]string{"plan": "enterprise"})
+	assert.NoError(t, agent.RegisterFallibleContextProvider("customer", facts))
+
+	_, err := agent.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	messages := client.Calls[0].Arguments.Get(0).([]memory.Message)
+	assert.Contains(t, messages[0].Content.Content, "# Customer\n- plan: enterprise")
+}
+
+func TestRegisterContextProvider_EmptyName(t *testing.T) {
+	agent := newTestAgent(t, new(MockLLMClient))
+
+	err := agent.RegisterContextProvider("", new(MockContextProvider))
+	assert.Error(t, err)
+}
+
+func TestConcurrentRunResetAndRegistration(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+	provider := new(MockContextProvider)
+	provider.On("GetTitle").Return("Info")
+	provider.On("GetInfo").Return("Some info")
+	agent := newTestAgent(t, client, WithSystemPromptGenerator(prompt.NewSystemPromptGenerator()))
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			_, err := agent.Run(context.Background(), fmt.Sprintf("message %d", i))
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			agent.ResetMemory()
+			_ = agent.Checkpoint()
+		}()
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("provider-%d", i%3)
+			assert.NoError(t, agent.RegisterContextProvider(name, provider))
+			_, _ = agent.GetContextProvider(name)
+			assert.NoError(t, agent.UnregisterContextProvider(name))
+		}()
+	}
+	wg.Wait()
+
+	// Each run adds a complete user/assistant pair
+	assert.Equal(t, 0, agent.memory.GetMessageCount()%2)
+}
+
+func TestConcurrentRuns_AreSerialized(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+	agent := newTestAgent(t, client)
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := agent.Run(context.Background(), fmt.Sprintf("message %d", i))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// User and assistant messages of a turn are never interleaved
+	history := agent.memory.GetHistory()
+	assert.Equal(t, 100, len(history))
+	for i := 0; i < len(history); i += 2 {
+		assert.Equal(t, "user", history[i].Role)
+		assert.Equal(t, "assistant", history[i+1].Role)
+		assert.Equal(t, history[i].TurnId, history[i+1].TurnId)
+	}
+}
+
+// Context provider that always fails
+type FailingContextProvider struct{}
+
+func (p FailingContextProvider) GetInfo(ctx context.Context) (string, error) {
+	return "", errors.New("service unavailable")
+}
+
+func (p FailingContextProvider) GetTitle() string {
+	return "Failing"
+}
+
+// Context provider echoing the user input it finds in the context
+type InputContextProvider struct{}
+
+func (p InputContextProvider) GetInfo(ctx context.Context) (string, error) {
+	input, _ := prompt.UserInputFromContext(ctx)
+	return fmt.Sprintf("input was %v", input), nil
+}
+
+func (p InputContextProvider) GetTitle() string {
+	return "Input"
+}
+
+func TestRun_PassesUserInputToProviders(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+	agent := newTestAgent(t, client)
+	assert.NoError(t, agent.RegisterFallibleContextProvider("input", InputContextProvider{}))
+
+	_, err := agent.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+
+	messages := client.Calls[0].Arguments.Get(0).([]memory.Message)
+	assert.Contains(t, messages[0].Content.Content, "input was Hello")
+}
+
+func TestResetAndRollback_ClearUserInput(t *testing.T) {
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(CompletionResponse{Prompt: "Hi"}, nil)
+	agent := newTestAgent(t, client)
+	assert.NoError(t, agent.RegisterFallibleContextProvider("input", InputContextProvider{}))
+	checkpoint := agent.Checkpoint()
+
+	// A continuation after a reset doesn't see the input of the earlier run
+	_, err := agent.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	agent.ResetMemory()
+	_, err = agent.Run(context.Background(), nil)
+	assert.NoError(t, err)
+	messages := client.Calls[1].Arguments.Get(0).([]memory.Message)
+	assert.Contains(t, messages[0].Content.Content, "input was <nil>")
+
+	// Neither does one after a rollback
+	_, err = agent.Run(context.Background(), "Hello again")
+	assert.NoError(t, err)
+	assert.NoError(t, agent.Rollback(checkpoint))
+	_, err = agent.Run(context.Background(), nil)
+	assert.NoError(t, err)
+	messages = client.Calls[3].Arguments.Get(0).([]memory.Message)
+	assert.Contains(t, messages[0].Content.Content, "input was <nil>")
+}
+
+func TestRun_FailingContextProvider(t *testing.T) {
+	client := new(MockLLMClient)
+	agent := newTestAgent(t, client)
+	assert.NoError(t, agent.RegisterFallibleContextProvider("failing", FailingContextProvider{}))
+
+	_, err := agent.Run(context.Background(), "Hello")
+	assert.ErrorContains(t, err, "service unavailable")
+	client.AssertNotCalled(t, "CreateCompletion", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+)
+
+// Metadata keys under which agents record that an assistant message
+// was answered from the semantic cache
+const (
+	MetadataCacheHit        = "cache_hit"
+	MetadataCacheSimilarity = "cache_similarity"
+	MetadataCachedInput     = "cache_input"
+)
+
+// SemanticCache answers user inputs that are similar to earlier ones.
+// Answers are only shared between requests with the same system prompt hash,
+// which is empty for agents without a system prompt
+type SemanticCache interface {
+	// Lookup returns the cached answer to the most similar earlier input,
+	// ok is false if no input is similar enough
+	Lookup(ctx context.Context, input string, promptHash string) (hit SemanticCacheHit, ok bool, err error)
+	// Store caches the response to the input
+	Store(ctx context.Context, input string, promptHash string, response CompletionResponse) error
+}
+
+// SemanticCacheHit is a cached answer to an input similar to the current one
+type SemanticCacheHit struct {
+	Response CompletionResponse
+	// Input is the earlier input the response answered
+	Input      string
+	Similarity float64
+}
+
+// metadata returns the message metadata recording the hit
+// along with the metadata of the current system prompt
+func (h SemanticCacheHit) metadata(promptMetadata map[string]string) map[string]string {
+	metadata := maps.Clone(promptMetadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[MetadataCacheHit] = "semantic"
+	metadata[MetadataCacheSimilarity] = strconv.FormatFloat(h.Similarity, 'f', 4, 64)
+	metadata[MetadataCachedInput] = h.Input
+	return metadata
+}
+
+// WithSemanticCache answers user inputs similar to earlier ones from the
+// cache instead of the model. The cached answer is added to memory like a
+// model answer, its metadata records the hit. Only the user input and the
+// system prompt are compared, the earlier conversation is not, so the cache
+// suits agents answering standalone questions.
+func WithSemanticCache(cache SemanticCache) AgentOption {
+	return func(cfg *AgentConfig) error {
+		if cache == nil {
+			return errors.New("semantic cache cannot be nil")
+		}
+		cfg.semanticCache = cache
+		return nil
+	}
+}
+
+// WithSemanticCacheErrorHook sets a function notified when the semantic cache
+// fails, e.g. for logging. Runs don't fail with the cache, they ask the model.
+func WithSemanticCacheErrorHook(hook func(err error)) AgentOption {
+	return func(cfg *AgentConfig) error {
+		cfg.onSemanticCacheError = hook
+		return nil
+	}
+}
+
+// cacheInput returns the text the semantic cache compares for a user input.
+// Structured inputs are compared by their json encoding.
+func cacheInput(userInput any) (string, error) {
+	if text, ok := userInput.(string); ok {
+		return text, nil
+	}
+	encoded, err := json.Marshal(userInput)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode user input for the semantic cache: %w", err)
+	}
+	return string(encoded), nil
+}
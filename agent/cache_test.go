@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/robnmrz/onigiri/prompt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Fake semantic cache matching inputs exactly
+type FakeSemanticCache struct {
+	answers   map[string]CompletionResponse
+	lookupErr error
+	storeErr  error
+	hashes    []string
+}
+
+func NewFakeSemanticCache() *FakeSemanticCache {
+	return &FakeSemanticCache{answers: map[string]CompletionResponse{}}
+}
+
+func (c *FakeSemanticCache) Lookup(ctx context.Context, input string, promptHash string) (SemanticCacheHit, bool, error) {
+	c.hashes = append(c.hashes, promptHash)
+	if c.lookupErr != nil {
+		return SemanticCacheHit{}, false, c.lookupErr
+	}
+	response, ok := c.answers[promptHash+"|"+input]
+	return SemanticCacheHit{Response: response, Input: input, Similarity: 1}, ok, nil
+}
+
+func (c *FakeSemanticCache) Store(ctx context.Context, input string, promptHash string, response CompletionResponse) error {
+	if c.storeErr != nil {
+		return c.storeErr
+	}
+	c.answers[promptHash+"|"+input] = response
+	return nil
+}
+
+func newCachedAgent(t *testing.T, client LLMClient, cache SemanticCache, background string, opts ...AgentOption) *BaseAgent {
+	t.Helper()
+	spg := prompt.NewSystemPromptGenerator(prompt.WithBackground([]string{background}))
+	opts = append([]AgentOption{WithSemanticCache(cache), WithSystemPromptGenerator(spg)}, opts...)
+	return newTestAgent(t, client, opts...)
+}
+
+func TestWithSemanticCache_Nil(t *testing.T) {
+	_, err := NewBaseAgent(WithClient(new(MockLLMClient)), WithModel("test-model"), WithSemanticCache(nil))
+	assert.ErrorContains(t, err, "semantic cache cannot be nil")
+}
+
+func TestRun_SemanticCache(t *testing.T) {
+	cache := NewFakeSemanticCache()
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, "test-model", mock.Anything).
+		Return(CompletionResponse{Prompt: "Opening hours are 9 to 5."}, nil).Once()
+
+	// The first agent asks the model and caches the answer
+	first := newCachedAgent(t, client, cache, "You answer FAQs.")
+	response, err := first.Run(context.Background(), "When are you open?")
+	assert.NoError(t, err)
+	assert.Equal(t, "Opening hours are 9 to 5.", response.Prompt)
+	history := first.GetMemory().GetHistory()
+	assert.NotContains(t, history[1].Metadata, MetadataCacheHit)
+
+	// The second agent with the same prompt is answered from the cache
+	second := newCachedAgent(t, client, cache, "You answer FAQs.")
+	response, err = second.Run(context.Background(), "When are you open?")
+	assert.NoError(t, err)
+	assert.Equal(t, "Opening hours are 9 to 5.", response.Prompt)
+	client.AssertNumberOfCalls(t, "CreateCompletion", 1)
+
+	// The cached answer is part of the conversation and records the hit
+	history = second.GetMemory().GetHistory()
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, "assistant", history[1].Role)
+	assert.Equal(t, response, history[1].Content.Content)
+	assert.Equal(t, "semantic", history[1].Metadata[MetadataCacheHit])
+	assert.Equal(t, "1.0000", history[1].Metadata[MetadataCacheSimilarity])
+	assert.Equal(t, "When are you open?", history[1].Metadata[MetadataCachedInput])
+	assert.Equal(t, cache.hashes[0], history[1].Metadata[prompt.MetadataPromptHash])
+	assert.Equal(t, 64, len(cache.hashes[0]))
+}
+
+func TestRun_SemanticCacheRequiresSamePrompt(t *testing.T) {
+	cache := NewFakeSemanticCache()
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, "test-model", mock.Anything).
+		Return(CompletionResponse{Prompt: "answer"}, nil)
+
+	_, err := newCachedAgent(t, client, cache, "You answer FAQs.").Run(context.Background(), "When are you open?")
+	assert.NoError(t, err)
+	_, err = newCachedAgent(t, client, cache, "You answer FAQs politely.").Run(context.Background(), "When are you open?")
+	assert.NoError(t, err)
+
+	client.AssertNumberOfCalls(t, "CreateCompletion", 2)
+	assert.NotEqual(t, cache.hashes[0], cache.hashes[1])
+}
+
+func TestRun_SemanticCacheSkipsContinuations(t *testing.T) {
+	cache := NewFakeSemanticCache()
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, "test-model", mock.Anything).
+		Return(CompletionResponse{Prompt: "answer"}, nil)
+	agent := newCachedAgent(t, client, cache, "You answer FAQs.")
+
+	_, err := agent.Run(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, cache.hashes)
+	assert.Empty(t, cache.answers)
+}
+
+func TestRun_SemanticCacheStoreError(t *testing.T) {
+	cache := NewFakeSemanticCache()
+	cache.storeErr = errors.New("cache is full")
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, "test-model", mock.Anything).
+		Return(CompletionResponse{Prompt: "answer"}, nil)
+	errs := []error{}
+	agent := newCachedAgent(t, client, cache, "You answer FAQs.",
+		WithSemanticCacheErrorHook(func(err error) { errs = append(errs, err) }))
+
+	// The answer is returned and kept in memory, the failure goes to the hook
+	response, err := agent.Run(context.Background(), "When are you open?")
+	assert.NoError(t, err)
+	assert.Equal(t, "answer", response.Prompt)
+	assert.Equal(t, 2, len(agent.GetMemory().GetHistory()))
+	assert.Equal(t, 1, len(errs))
+	assert.ErrorContains(t, errs[0], "failed to store response in semantic cache: cache is full")
+}
+
+func TestRun_SemanticCacheLookupError(t *testing.T) {
+	cache := NewFakeSemanticCache()
+	cache.lookupErr = errors.New("embedder unavailable")
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, mock.Anything, "test-model", mock.Anything).
+		Return(CompletionResponse{Prompt: "answer"}, nil)
+	errs := []error{}
+	agent := newCachedAgent(t, client, cache, "You answer FAQs.",
+		WithSemanticCacheErrorHook(func(err error) { errs = append(errs, err) }))
+
+	// The model answers when the cache can't be searched
+	response, err := agent.Run(context.Background(), "When are you open?")
+	assert.NoError(t, err)
+	assert.Equal(t, "answer", response.Prompt)
+	client.AssertNumberOfCalls(t, "CreateCompletion", 1)
+	assert.Equal(t, 1, len(errs))
+	assert.ErrorContains(t, errs[0], "semantic cache lookup failed: embedder unavailable")
+
+	// Without a hook the failure is dropped
+	_, err = newCachedAgent(t, client, cache, "You answer FAQs.").Run(context.Background(), "When are you open?")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/robnmrz/onigiri/prompt"
+	"gopkg.in/yaml.v3"
+)
+
+// AgentDefinition describes an agent and its system prompt declaratively.
+// A nil SystemRole keeps the agent's default role, an empty one disables
+// the system prompt message
+type AgentDefinition struct {
+	Model           string           `yaml:"model"`
+	SystemRole      *string          `yaml:"system_role"`
+	ModelParameters map[string]any   `yaml:"model_parameters"`
+	Memory          MemoryDefinition `yaml:"memory"`
+	Prompt          PromptDefinition `yaml:"prompt"`
+}
+
+// MemoryDefinition describes the limits of the agent's memory
+type MemoryDefinition struct {
+	MaxMessages *int `yaml:"max_messages"`
+}
+
+// PromptDefinition describes the system prompt
+type PromptDefinition struct {
+	Background         []string            `yaml:"background"`
+	Steps              []string            `yaml:"steps"`
+	OutputInstructions []string            `yaml:"output_instructions"`
+	Sections           []SectionDefinition `yaml:"sections"`
+	SectionOrder       []string            `yaml:"section_order"`
+	Renderer           string              `yaml:"renderer"`
+}
+
+// SectionDefinition describes a custom prompt section. Sections named like
+// a built-in section change its title and style, their content is taken
+// from background, steps and output instructions
+type SectionDefinition struct {
+	Name     string   `yaml:"name"`
+	Title    string   `yaml:"title"`
+	Style    string   `yaml:"style"`
+	Language string   `yaml:"language"`
+	Content  []string `yaml:"content"`
+}
+
+// ValidationError points to the location in the definition file
+// that is invalid. Line and Column are 0 if unknown
+type ValidationError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	case e.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	default:
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+	}
+}
+
+// sectionStyles maps the style names of definition files to section styles
+var sectionStyles = map[string]prompt.SectionStyle{
+	"":          prompt.SectionStyleBullets,
+	"bullets":   prompt.SectionStyleBullets,
+	"numbered":  prompt.SectionStyleNumbered,
+	"paragraph": prompt.SectionStyleParagraph,
+	"code":      prompt.SectionStyleCodeBlock,
+}
+
+// renderers maps the renderer names of definition files to renderers
+var renderers = map[string]prompt.PromptRenderer{
+	"":         nil,
+	"markdown": prompt.MarkdownRenderer{},
+	"xml":      prompt.XMLRenderer{},
+	"json":     prompt.JSONRenderer{},
+}
+
+// builtinSections are the sections whose content can't be set in a section definition
+var builtinSections = []string{
+	prompt.SectionBackground,
+	prompt.SectionSteps,
+	prompt.SectionOutputInstructions,
+	prompt.SectionExamples,
+}
+
+// LoadFile reads and validates an agent definition from a YAML or JSON file
+func LoadFile(path string) (*AgentDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent definition: %w", err)
+	}
+	return Parse(path, data)
+}
+
+// Parse reads and validates an agent definition from YAML or JSON data.
+// The file name is only used in error messages
+func Parse(file string, data []byte) (*AgentDefinition, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, yamlErrors(file, err)
+	}
+	if len(document.Content) == 0 {
+		return nil, &ValidationError{File: file, Message: "definition is empty"}
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, &ValidationError{File: file, Line: root.Line, Column: root.Column, Message: "definition must be a mapping"}
+	}
+
+	// Report unknown fields with their location before decoding,
+	// the decoder itself only ignores them
+	var errs []error
+	checkFields(file, root, reflect.TypeOf(AgentDefinition{}), &errs)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	def := &AgentDefinition{}
+	if err := root.Decode(def); err != nil {
+		return nil, yamlErrors(file, err)
+	}
+
+	if err := def.validate(file, root); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// checkFields reports mapping keys that don't belong to the type's fields
+func checkFields(file string, node *yaml.Node, t reflect.Type, errs *[]error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := map[string]reflect.Type{}
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name != "" && name != "-" {
+				fields[name] = field.Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				*errs = append(*errs, &ValidationError{
+					File:    file,
+					Line:    key.Line,
+					Column:  key.Column,
+					Message: fmt.Sprintf("unknown field %q", key.Value),
+				})
+				continue
+			}
+			checkFields(file, value, fieldType, errs)
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range node.Content {
+			checkFields(file, item, t.Elem(), errs)
+		}
+	}
+}
+
+// yamlLinePattern matches the line prefix of yaml errors
+var yamlLinePattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlErrors turns yaml parse and type errors into validation errors
+func yamlErrors(file string, err error) error {
+	messages := []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	var errs []error
+	for _, message := range messages {
+		validationErr := &ValidationError{File: file, Message: message}
+		if match := yamlLinePattern.FindStringSubmatch(message); match != nil {
+			validationErr.Line, _ = strconv.Atoi(match[1])
+			validationErr.Message = match[2]
+		}
+		errs = append(errs, validationErr)
+	}
+	return errors.Join(errs...)
+}
+
+// findNode returns the node at the path of mapping keys and sequence
+// indexes, or the deepest node on the path that exists
+func findNode(node *yaml.Node, path ...string) *yaml.Node {
+	for _, element := range path {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == element {
+					next = node.Content[i+1]
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(element); err == nil && i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
+
+// validate checks the decoded definition, pointing errors to their location
+func (d *AgentDefinition) validate(file string, root *yaml.Node) error {
+	var errs []error
+	fail := func(message string, path ...string) {
+		node := findNode(root, path...)
+		errs = append(errs, &ValidationError{File: file, Line: node.Line, Column: node.Column, Message: message})
+	}
+
+	if strings.TrimSpace(d.Model) == "" {
+		fail("model is required", "model")
+	}
+	for key := range d.ModelParameters {
+		if key == "" {
+			fail("model parameter key cannot be empty", "model_parameters")
+		}
+	}
+	if d.Memory.MaxMessages != nil && *d.Memory.MaxMessages != -1 && *d.Memory.MaxMessages < 1 {
+		fail("max_messages must be positive or -1 for no limit", "memory", "max_messages")
+	}
+	if _, ok := renderers[d.Prompt.Renderer]; !ok {
+		fail(fmt.Sprintf("unknown renderer %q, expected markdown, xml or json", d.Prompt.Renderer), "prompt", "renderer")
+	}
+
+	names := slices.Clone(builtinSections)
+	for i, section := range d.Prompt.Sections {
+		index := strconv.Itoa(i)
+		switch {
+		case section.Name == "":
+			fail("section name is required", "prompt", "sections", index)
+		case slices.Contains(builtinSections, section.Name) && len(section.Content) > 0:
+			fail(fmt.Sprintf("content of built-in section %q cannot be set", section.Name), "prompt", "sections", index, "content")
+		case slices.Contains(names, section.Name) && !slices.Contains(builtinSections, section.Name):
+			fail(fmt.Sprintf("duplicate section %q", section.Name), "prompt", "sections", index, "name")
+		}
+		names = append(names, section.Name)
+		if _, ok := sectionStyles[section.Style]; !ok {
+			fail(fmt.Sprintf("unknown section style %q, expected bullets, numbered, paragraph or code", section.Style), "prompt", "sections", index, "style")
+		}
+	}
+	for i, name := range d.Prompt.SectionOrder {
+		if !slices.Contains(names, name) {
+			fail(fmt.Sprintf("unknown section %q", name), "prompt", "section_order", strconv.Itoa(i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewSystemPromptGenerator creates the system prompt generator described by
+// the definition. Additional options are applied after the definition's ones
+func (d *AgentDefinition) NewSystemPromptGenerator(opts ...prompt.SytemPromptGeneratorOption) *prompt.SystemPromptGenerator {
+	options := []prompt.SytemPromptGeneratorOption{
+		prompt.WithBackground(slices.Clone(d.Prompt.Background)),
+		prompt.WithSteps(slices.Clone(d.Prompt.Steps)),
+		prompt.WithOutputInstructions(slices.Clone(d.Prompt.OutputInstructions)),
+	}
+	for _, section := range d.Prompt.Sections {
+		options = append(options, prompt.WithSection(prompt.PromptSection{
+			Name:     section.Name,
+			Title:    section.Title,
+			Content:  slices.Clone(section.Content),
+			Style:    sectionStyles[section.Style],
+			Language: section.Language,
+		}))
+	}
+	if len(d.Prompt.SectionOrder) > 0 {
+		options = append(options, prompt.WithSectionOrder(d.Prompt.SectionOrder...))
+	}
+	if renderer := renderers[d.Prompt.Renderer]; renderer != nil {
+		options = append(options, prompt.WithRenderer(renderer))
+	}
+	return prompt.NewSystemPromptGenerator(append(options, opts...)...)
+}
+
+// NewMemory creates an empty memory with the definition's limits
+func (d *AgentDefinition) NewMemory() *memory.AgentMemory {
+	if d.Memory.MaxMessages != nil {
+		return memory.NewAgentMemory(memory.WithMaxMessages(*d.Memory.MaxMessages))
+	}
+	return memory.NewAgentMemory()
+}
+
+// AgentOptions returns the agent options described by the definition,
+// including a new system prompt generator and memory
+func (d *AgentDefinition) AgentOptions() []agent.AgentOption {
+	opts := []agent.AgentOption{
+		agent.WithModel(d.Model),
+		agent.WithSystemPromptGenerator(d.NewSystemPromptGenerator()),
+		agent.WithMemory(d.NewMemory()),
+	}
+	if d.SystemRole != nil {
+		opts = append(opts, agent.WithSystemRole(*d.SystemRole))
+	}
+	for key, value := range d.ModelParameters {
+		opts = append(opts, agent.WithModelParameter(key, value))
+	}
+	return opts
+}
+
+// NewBaseAgent creates the agent described by the definition using the given
+// client. Additional options are applied after the definition's ones
+func (d *AgentDefinition) NewBaseAgent(client agent.LLMClient, opts ...agent.AgentOption) (*agent.BaseAgent, error) {
+	options := append(d.AgentOptions(), agent.WithClient(client))
+	return agent.NewBaseAgent(append(options, opts...)...)
+}
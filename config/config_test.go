@@ -0,0 +1,170 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake LLM client returning the system prompt it received
+type FakeLLMClient struct{}
+
+func (c FakeLLMClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	return agent.CompletionResponse{Prompt: messages[0].Content.Content.(string)}, nil
+}
+
+const validYaml = `
+model: gemini-2.0-flash-lite
+system_role: developer
+model_parameters:
+  temperature: 0.2
+memory:
+  max_messages: 10
+prompt:
+  background:
+    - I am a support assistant.
+  steps:
+    - Understand the question
+    - Answer it
+  output_instructions:
+    - Be concise.
+  sections:
+    - name: steps
+      title: STEPS
+      style: numbered
+    - name: constraints
+      title: CONSTRAINTS
+      content:
+        - Never share internal data
+  section_order: [constraints]
+`
+
+func TestParse_Yaml(t *testing.T) {
+	def, err := Parse("agent.yaml", []byte(validYaml))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "gemini-2.0-flash-lite", def.Model)
+	assert.Equal(t, "developer", *def.SystemRole)
+	assert.Equal(t, 0.2, def.ModelParameters["temperature"])
+	assert.Equal(t, 10, *def.Memory.MaxMessages)
+	assert.Equal(t, 2, len(def.Prompt.Sections))
+}
+
+func TestParse_Json(t *testing.T) {
+	def, err := Parse("agent.json", []byte(`{
+  "model": "gemini-2.0-flash-lite",
+  "prompt": {"background": ["I am a support assistant."], "renderer": "xml"}
+}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "xml", def.Prompt.Renderer)
+	assert.Nil(t, def.SystemRole)
+}
+
+func TestParse_UnknownField(t *testing.T) {
+	_, err := Parse("agent.yaml", []byte(`
+model: gemini
+prompt:
+  backgrund:
+    - typo
+`))
+	assert.EqualError(t, err, `agent.yaml:4:3: unknown field "backgrund"`)
+}
+
+func TestParse_TypeError(t *testing.T) {
+	_, err := Parse("agent.yaml", []byte(`
+model: gemini
+memory:
+  max_messages: many
+`))
+	assert.ErrorContains(t, err, "agent.yaml:4: cannot unmarshal")
+}
+
+func TestParse_SyntaxError(t *testing.T) {
+	_, err := Parse("agent.yaml", []byte("model: [gemini\n"))
+	assert.ErrorContains(t, err, "agent.yaml:")
+}
+
+func TestParse_ValidationErrors(t *testing.T) {
+	_, err := Parse("agent.yaml", []byte(`
+memory:
+  max_messages: 0
+prompt:
+  renderer: html
+  sections:
+    - name: background
+      content: [not allowed]
+    - name: examples2
+      style: fancy
+    - title: NO NAME
+  section_order: [missing]
+`))
+
+	assert.ErrorContains(t, err, "agent.yaml:2:1: model is required")
+	assert.ErrorContains(t, err, "agent.yaml:3:17: max_messages must be positive")
+	assert.ErrorContains(t, err, `agent.yaml:5:13: unknown renderer "html"`)
+	assert.ErrorContains(t, err, `agent.yaml:8:16: content of built-in section "background" cannot be set`)
+	assert.ErrorContains(t, err, `agent.yaml:10:14: unknown section style "fancy"`)
+	assert.ErrorContains(t, err, "agent.yaml:11:7: section name is required")
+	assert.ErrorContains(t, err, `agent.yaml:12:19: unknown section "missing"`)
+}
+
+func TestParse_DuplicateSection(t *testing.T) {
+	_, err := Parse("agent.yaml", []byte(`
+model: gemini
+prompt:
+  sections:
+    - name: rules
+    - name: rules
+`))
+	assert.EqualError(t, err, `agent.yaml:6:13: duplicate section "rules"`)
+}
+
+func TestParse_Empty(t *testing.T) {
+	_, err := Parse("agent.yaml", []byte(""))
+	assert.EqualError(t, err, "agent.yaml: definition is empty")
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(validYaml), 0o644))
+
+	def, err := LoadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "gemini-2.0-flash-lite", def.Model)
+
+	_, err = LoadFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestNewSystemPromptGenerator(t *testing.T) {
+	def, err := Parse("agent.yaml", []byte(validYaml))
+	assert.NoError(t, err)
+
+	prompt, err := def.NewSystemPromptGenerator().GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+
+	expected := "# CONSTRAINTS\n- Never share internal data\n\n" +
+		"# IDENTITY and PURPOSE\n- I am a support assistant.\n\n" +
+		"# STEPS\n1. Understand the question\n2. Answer it\n\n" +
+		"# OUTPUT INSTRUCTIONS\n- Be concise."
+	assert.Equal(t, expected, prompt)
+}
+
+func TestNewBaseAgent(t *testing.T) {
+	def, err := Parse("agent.yaml", []byte(validYaml))
+	assert.NoError(t, err)
+
+	a, err := def.NewBaseAgent(FakeLLMClient{})
+	assert.NoError(t, err)
+
+	response, err := a.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	assert.Contains(t, response.Prompt, "# CONSTRAINTS")
+	assert.Equal(t, 10, a.GetMemory().MaxMessages)
+}
@@ -0,0 +1,349 @@
+package llm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/robnmrz/onigiri/utils"
+)
+
+// Option type for CacheClient
+type CacheOption func(*CacheClient)
+
+// ResponseCache stores completions under their CacheKey
+type ResponseCache interface {
+	// Get returns the response stored under the key, ok is false
+	// if there is none or it has expired
+	Get(ctx context.Context, key string) (response agent.CompletionResponse, ok bool, err error)
+	// Set stores the response under the key, a ttl of 0 never expires
+	Set(ctx context.Context, key string, response agent.CompletionResponse, ttl time.Duration) error
+}
+
+// CacheStats are the counters of a CacheClient
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	// Bypassed is the number of requests that weren't cacheable
+	Bypassed int64
+	// Errors is the number of cache keys that could not be computed
+	// and of failed cache reads and writes
+	Errors int64
+}
+
+// CacheClient is an LLMClient decorator answering repeated requests from a
+// ResponseCache. Only deterministic requests are cached, requests with a
+// temperature above zero go straight to the client unless caching is forced.
+// Failed completions are never cached. The cache never fails a request,
+// a failed read is treated as a miss and a failed write is only reported
+type CacheClient struct {
+	client  agent.LLMClient
+	cache   ResponseCache
+	ttl     time.Duration
+	force   bool
+	onError func(key string, err error)
+
+	mu    sync.Mutex
+	stats CacheStats
+}
+
+// Constructor for a new CacheClient wrapping the client
+func NewCacheClient(client agent.LLMClient, cache ResponseCache, opts ...CacheOption) *CacheClient {
+	c := &CacheClient{client: client, cache: cache}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Functional option to set how long responses are cached, 0 keeps them forever
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(c *CacheClient) {
+		c.ttl = ttl
+	}
+}
+
+// Functional option to cache requests regardless of their temperature,
+// e.g. to replay a test suite without calling the model
+func WithForceCache() CacheOption {
+	return func(c *CacheClient) {
+		c.force = true
+	}
+}
+
+// Functional option to get notified when computing the key, reading or writing
+// the cache fails, e.g. for logging. The request itself goes on without the cache
+func WithCacheErrorHook(hook func(key string, err error)) CacheOption {
+	return func(c *CacheClient) {
+		c.onError = hook
+	}
+}
+
+// CreateCompletion returns the cached response for the request if there is
+// one, otherwise it requests a completion and caches it
+func (c *CacheClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	if !c.force && !isDeterministic(modelApiParameters) {
+		c.count(&c.stats.Bypassed)
+		return c.client.CreateCompletion(ctx, messages, responseSchema, model, modelApiParameters)
+	}
+
+	key, err := CacheKey(messages, responseSchema, model, modelApiParameters)
+	if err != nil {
+		// A request without a key can't be cached, but it can still be sent
+		c.cacheError(key, err)
+		return c.client.CreateCompletion(ctx, messages, responseSchema, model, modelApiParameters)
+	}
+	cached, ok, err := c.cache.Get(ctx, key)
+	if err != nil {
+		c.cacheError(key, fmt.Errorf("failed to read response cache: %w", err))
+	} else if ok {
+		c.count(&c.stats.Hits)
+		return cached, nil
+	}
+
+	c.count(&c.stats.Misses)
+	response, err := c.client.CreateCompletion(ctx, messages, responseSchema, model, modelApiParameters)
+	if err != nil {
+		return agent.CompletionResponse{}, err
+	}
+	if err := c.cache.Set(ctx, key, response, c.ttl); err != nil {
+		c.cacheError(key, fmt.Errorf("failed to write response cache: %w", err))
+	}
+	return response, nil
+}
+
+// cacheError counts a failed cache key, read or write and reports it to the hook
+func (c *CacheClient) cacheError(key string, err error) {
+	c.count(&c.stats.Errors)
+	if c.onError != nil {
+		c.onError(key, err)
+	}
+}
+
+// Stats returns a snapshot of the counters
+func (c *CacheClient) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// count increments one of the counters
+func (c *CacheClient) count(counter *int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	*counter++
+}
+
+// isDeterministic reports whether the request's temperature is unset or zero
+func isDeterministic(modelApiParameters map[string]any) bool {
+	switch temperature := modelApiParameters["temperature"].(type) {
+	case float64:
+		return temperature <= 0
+	case float32:
+		return temperature <= 0
+	case int:
+		return temperature <= 0
+	}
+	return true
+}
+
+// cacheKeyMessage is the part of a message that affects the completion,
+// turn ids and metadata differ between otherwise identical requests
+type cacheKeyMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+// CacheKey returns the canonical hash of a request. Map keys are sorted by
+// the json encoding, so parameters in a different order give the same key.
+// Message turn ids and metadata are left out
+func CacheKey(messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (string, error) {
+	keyMessages := make([]cacheKeyMessage, len(messages))
+	for i, msg := range messages {
+		keyMessages[i] = cacheKeyMessage{Role: msg.Role, Content: msg.Content.Content}
+	}
+	schema := ""
+	if responseSchema != nil {
+		schema = responseSchema.PkgPath() + " " + responseSchema.String()
+	}
+
+	data, err := json.Marshal(struct {
+		Messages   []cacheKeyMessage `json:"messages"`
+		Schema     string            `json:"schema"`
+		Model      string            `json:"model"`
+		Parameters map[string]any    `json:"parameters"`
+	}{keyMessages, schema, model, modelApiParameters})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cache key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lruEntry is a cached response in the LRUResponseCache
+type lruEntry struct {
+	key      string
+	response agent.CompletionResponse
+	expires  time.Time
+}
+
+// LRUResponseCache keeps responses in memory, evicting the least recently
+// used ones beyond its capacity. It is safe for concurrent use
+type LRUResponseCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List
+	now      func() time.Time
+}
+
+// Constructor for a new LRUResponseCache holding up to capacity responses
+func NewLRUResponseCache(capacity int) *LRUResponseCache {
+	return &LRUResponseCache{
+		capacity: max(capacity, 1),
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// Get returns the cached response and marks it as recently used
+func (c *LRUResponseCache) Get(ctx context.Context, key string) (agent.CompletionResponse, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return agent.CompletionResponse{}, false, nil
+	}
+	entry := elem.Value.(*lruEntry)
+	if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return agent.CompletionResponse{}, false, nil
+	}
+	c.lru.MoveToFront(elem)
+	return entry.response, true, nil
+}
+
+// Set caches the response, evicting the least recently used one if full
+func (c *LRUResponseCache) Set(ctx context.Context, key string, response agent.CompletionResponse, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{key: key, response: response}
+	if ttl > 0 {
+		entry.expires = c.now().Add(ttl)
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return nil
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+// Len returns the number of cached responses, including expired ones
+// that haven't been requested since
+func (c *LRUResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// fileCacheEntry is the json file of a response in the FileResponseCache
+type fileCacheEntry struct {
+	Response agent.CompletionResponse `json:"response"`
+	Expires  time.Time                `json:"expires"`
+}
+
+// FileResponseCache saves each response as a json file in a directory,
+// so the cache survives restarts and can be shared between processes
+type FileResponseCache struct {
+	dir string
+	now func() time.Time
+}
+
+// Constructor for a new FileResponseCache, creating the directory if needed
+func NewFileResponseCache(dir string) (*FileResponseCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &FileResponseCache{dir: dir, now: time.Now}, nil
+}
+
+// path returns the file path for a key
+func (c *FileResponseCache) path(key string) (string, error) {
+	if _, err := hex.DecodeString(key); err != nil || key == "" {
+		return "", fmt.Errorf("invalid cache key %q", key)
+	}
+	return filepath.Join(c.dir, key+".json"), nil
+}
+
+// Get reads the response file of the key. Expired and unreadable
+// entries are removed and reported as missing
+func (c *FileResponseCache) Get(ctx context.Context, key string) (agent.CompletionResponse, bool, error) {
+	path, err := c.path(key)
+	if err != nil {
+		return agent.CompletionResponse{}, false, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return agent.CompletionResponse{}, false, nil
+	}
+	if err != nil {
+		return agent.CompletionResponse{}, false, fmt.Errorf("failed to read cached response %s: %w", key, err)
+	}
+
+	var entry fileCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || (!entry.Expires.IsZero() && !c.now().Before(entry.Expires)) {
+		os.Remove(path)
+		return agent.CompletionResponse{}, false, nil
+	}
+	return entry.Response, true, nil
+}
+
+// Set writes the response file of the key. The file is replaced
+// atomically so concurrent readers never see a partial response
+func (c *FileResponseCache) Set(ctx context.Context, key string, response agent.CompletionResponse, ttl time.Duration) error {
+	path, err := c.path(key)
+	if err != nil {
+		return err
+	}
+
+	entry := fileCacheEntry{Response: response}
+	if ttl > 0 {
+		entry.Expires = c.now().Add(ttl)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode cached response %s: %w", key, err)
+	}
+
+	if err := utils.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to cache response %s: %w", key, err)
+	}
+	return nil
+}
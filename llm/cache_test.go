@@ -0,0 +1,259 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// Response cache failing every read and write
+type BrokenResponseCache struct{}
+
+func (c BrokenResponseCache) Get(ctx context.Context, key string) (agent.CompletionResponse, bool, error) {
+	return agent.CompletionResponse{}, false, errors.New("disk unavailable")
+}
+
+func (c BrokenResponseCache) Set(ctx context.Context, key string, response agent.CompletionResponse, ttl time.Duration) error {
+	return errors.New("disk full")
+}
+
+type cachedAnswer struct {
+	Answer string `json:"answer"`
+}
+
+func TestCacheKey(t *testing.T) {
+	key := func(messages []memory.Message, schema reflect.Type, model string, parameters map[string]any) string {
+		key, err := CacheKey(messages, schema, model, parameters)
+		assert.NoError(t, err)
+		return key
+	}
+	base := key(userMessages("Hi"), reflect.TypeOf(""), "model", map[string]any{"temperature": 0, "seed": 1})
+	assert.Len(t, base, 64)
+
+	// Turn ids and metadata don't change the key
+	messages := userMessages("Hi")
+	messages[0].TurnId = "turn-1"
+	messages[0].Metadata = map[string]string{"prompt_hash": "abc"}
+	assert.Equal(t, base, key(messages, reflect.TypeOf(""), "model", map[string]any{"seed": 1, "temperature": 0}))
+
+	assert.NotEqual(t, base, key(userMessages("Hello"), reflect.TypeOf(""), "model", map[string]any{"temperature": 0, "seed": 1}))
+	assert.NotEqual(t, base, key(userMessages("Hi"), reflect.TypeOf(cachedAnswer{}), "model", map[string]any{"temperature": 0, "seed": 1}))
+	assert.NotEqual(t, base, key(userMessages("Hi"), reflect.TypeOf(""), "other-model", map[string]any{"temperature": 0, "seed": 1}))
+	assert.NotEqual(t, base, key(userMessages("Hi"), reflect.TypeOf(""), "model", map[string]any{"temperature": 0, "seed": 2}))
+}
+
+func TestLRUResponseCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUResponseCache(2)
+	assert.NoError(t, cache.Set(ctx, "a", agent.CompletionResponse{Prompt: "A"}, 0))
+	assert.NoError(t, cache.Set(ctx, "b", agent.CompletionResponse{Prompt: "B"}, 0))
+
+	// Reading a marks it as recently used, so b is evicted
+	response, ok, err := cache.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "A", response.Prompt)
+	assert.NoError(t, cache.Set(ctx, "c", agent.CompletionResponse{Prompt: "C"}, 0))
+
+	_, ok, _ = cache.Get(ctx, "b")
+	assert.False(t, ok)
+	_, ok, _ = cache.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, cache.Len())
+}
+
+func TestLRUResponseCache_TTL(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUResponseCache(10)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	assert.NoError(t, cache.Set(ctx, "a", agent.CompletionResponse{Prompt: "A"}, time.Minute))
+	now = now.Add(59 * time.Second)
+	_, ok, _ := cache.Get(ctx, "a")
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	_, ok, _ = cache.Get(ctx, "a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestFileResponseCache(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "cache")
+	cache, err := NewFileResponseCache(dir)
+	assert.NoError(t, err)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	key, err := CacheKey(userMessages("Hi"), nil, "model", nil)
+	assert.NoError(t, err)
+	_, ok, err := cache.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, cache.Set(ctx, key, agent.CompletionResponse{Prompt: "Hello", Backend: "primary"}, time.Hour))
+
+	// The response survives a new cache over the same directory
+	reopened, err := NewFileResponseCache(dir)
+	assert.NoError(t, err)
+	reopened.now = cache.now
+	response, ok, err := reopened.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, agent.CompletionResponse{Prompt: "Hello", Backend: "primary"}, response)
+
+	// Expired entries are removed
+	now = now.Add(time.Hour)
+	_, ok, err = reopened.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoFileExists(t, filepath.Join(dir, key+".json"))
+}
+
+func TestFileResponseCache_Invalid(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cache, err := NewFileResponseCache(dir)
+	assert.NoError(t, err)
+
+	_, _, err = cache.Get(ctx, "../secrets")
+	assert.ErrorContains(t, err, "invalid cache key")
+	assert.Error(t, cache.Set(ctx, "", agent.CompletionResponse{}, 0))
+
+	// Corrupted entries are treated as missing
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "abcd.json"), []byte("{"), 0o644))
+	_, ok, err := cache.Get(ctx, "abcd")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCacheClient_CachesDeterministicRequests(t *testing.T) {
+	client := &ScriptedLLMClient{}
+	c := NewCacheClient(client, NewLRUResponseCache(10))
+
+	for _, parameters := range []map[string]any{nil, {"temperature": 0.0}, {"temperature": 0}} {
+		response, err := c.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", parameters)
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", response.Prompt)
+	}
+	// Without and with a zero temperature are different requests,
+	// but an int and a float zero are the same
+	assert.Equal(t, 2, client.calls)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2}, c.Stats())
+}
+
+func TestCacheClient_BypassesTemperature(t *testing.T) {
+	client := &ScriptedLLMClient{}
+	c := NewCacheClient(client, NewLRUResponseCache(10))
+	parameters := map[string]any{"temperature": 0.7}
+
+	for range 2 {
+		_, err := c.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", parameters)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, client.calls)
+	assert.Equal(t, CacheStats{Bypassed: 2}, c.Stats())
+
+	// Forcing the cache ignores the temperature
+	forced := NewCacheClient(client, NewLRUResponseCache(10), WithForceCache())
+	for range 2 {
+		_, err := forced.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", parameters)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, client.calls)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, forced.Stats())
+}
+
+func TestCacheClient_DoesNotCacheErrors(t *testing.T) {
+	client := &ScriptedLLMClient{errs: []error{NewAPIError(500, "oops", 0)}}
+	cache := NewLRUResponseCache(10)
+	c := NewCacheClient(client, cache)
+
+	_, err := c.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", nil)
+	assert.ErrorIs(t, err, ErrServerError)
+	assert.Equal(t, 0, cache.Len())
+
+	response, err := c.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", response.Prompt)
+	assert.Equal(t, 2, client.calls)
+}
+
+func TestCacheClient_CacheErrors(t *testing.T) {
+	client := &ScriptedLLMClient{}
+	errs := []error{}
+	c := NewCacheClient(client, BrokenResponseCache{}, WithCacheErrorHook(func(key string, err error) {
+		assert.Equal(t, 64, len(key))
+		errs = append(errs, err)
+	}))
+
+	// A broken cache neither fails the request nor loses the response
+	response, err := c.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", response.Prompt)
+	assert.Equal(t, 1, client.calls)
+
+	assert.Equal(t, CacheStats{Misses: 1, Errors: 2}, c.Stats())
+	assert.Equal(t, 2, len(errs))
+	assert.ErrorContains(t, errs[0], "failed to read response cache: disk unavailable")
+	assert.ErrorContains(t, errs[1], "failed to write response cache: disk full")
+}
+
+func TestCacheClient_KeyError(t *testing.T) {
+	client := &ScriptedLLMClient{}
+	errs := []error{}
+	c := NewCacheClient(client, NewLRUResponseCache(10), WithCacheErrorHook(func(key string, err error) {
+		assert.Empty(t, key)
+		errs = append(errs, err)
+	}))
+
+	// Parameters that can't be encoded skip the cache instead of failing the request
+	params := map[string]any{"temperature": 0.0, "callback": func() {}}
+	response, err := c.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", params)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", response.Prompt)
+	assert.Equal(t, 1, client.calls)
+
+	assert.Equal(t, CacheStats{Errors: 1}, c.Stats())
+	assert.Equal(t, 1, len(errs))
+	assert.ErrorContains(t, errs[0], "failed to encode cache key")
+}
+
+func TestCacheClient_TTL(t *testing.T) {
+	client := &ScriptedLLMClient{}
+	cache := NewLRUResponseCache(10)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	c := NewCacheClient(client, cache, WithCacheTTL(time.Minute))
+
+	for _, elapsed := range []time.Duration{0, 30 * time.Second, time.Minute} {
+		now = now.Add(elapsed)
+		_, err := c.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", nil)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, client.calls)
+}
+
+func TestCacheClient_WithAgents(t *testing.T) {
+	client := &ScriptedLLMClient{}
+	c := NewCacheClient(client, NewLRUResponseCache(10))
+
+	// Separate agents asking the same question share the cached answer
+	for range 2 {
+		a, err := agent.NewBaseAgent(agent.WithClient(c), agent.WithModel("model"))
+		assert.NoError(t, err)
+		_, err = a.Run(context.Background(), "Hello")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, client.calls)
+}
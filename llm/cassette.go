@@ -0,0 +1,382 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/robnmrz/onigiri/utils"
+)
+
+// CassetteMode decides whether a CassetteClient replays, records or neither
+type CassetteMode int
+
+const (
+	// CassetteStrict replays recorded responses and fails for requests
+	// that weren't recorded, the client is never called
+	CassetteStrict CassetteMode = iota
+	// CassetteRecordMissing replays recorded responses and records
+	// the responses of the client for requests that weren't recorded
+	CassetteRecordMissing
+	// CassettePassthrough calls the client for every request and
+	// neither reads nor writes the cassette
+	CassettePassthrough
+)
+
+// ErrCassetteMismatch is returned in strict mode for requests that weren't recorded
+var ErrCassetteMismatch = errors.New("no recorded interaction matches the request")
+
+// Option type for CassetteClient
+type CassetteOption func(*CassetteClient)
+
+// CassetteMessage is a normalized message of a recorded request
+type CassetteMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+// CassetteRequest is a normalized completion request. Requests match if
+// their json encodings are equal
+type CassetteRequest struct {
+	Model      string            `json:"model"`
+	Schema     string            `json:"schema,omitempty"`
+	Parameters map[string]any    `json:"parameters,omitempty"`
+	Messages   []CassetteMessage `json:"messages"`
+}
+
+// CassetteResponse is a recorded completion response
+type CassetteResponse struct {
+	Prompt  string `json:"prompt"`
+	Backend string `json:"backend,omitempty"`
+}
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+// cassetteFile is the json file of a cassette
+type cassetteFile struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// CassetteClient is an LLMClient recording completions of a real client to a
+// cassette file and replaying them, so agents can be tested without a live
+// model. Requests are matched on their model, response schema, parameters and
+// normalized messages, turn ids and metadata are ignored. Identical requests
+// replay their recorded responses in order, in strict mode the last one is
+// repeated once all were replayed. It is safe for concurrent use
+type CassetteClient struct {
+	path      string
+	client    agent.LLMClient
+	mode      CassetteMode
+	normalize func(text string) string
+
+	mu           sync.Mutex
+	interactions []Interaction
+	// used marks the interactions that were replayed
+	used []bool
+}
+
+// Constructor for a new CassetteClient over the cassette file at path.
+// The client is only called when recording or passing through and may be
+// nil in strict mode, which requires the cassette to exist
+func NewCassetteClient(path string, client agent.LLMClient, opts ...CassetteOption) (*CassetteClient, error) {
+	c := &CassetteClient{
+		path:      path,
+		client:    client,
+		normalize: NormalizeWhitespace,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.mode != CassetteStrict && client == nil {
+		return nil, errors.New("recording and passing through need a client")
+	}
+	if c.mode == CassettePassthrough {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && c.mode == CassetteRecordMissing {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette %s: %w", path, err)
+	}
+	var file cassetteFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode cassette %s: %w", path, err)
+	}
+	c.interactions = file.Interactions
+	c.used = make([]bool, len(c.interactions))
+	return c, nil
+}
+
+// Functional option to set the mode, defaults to CassetteStrict
+func WithCassetteMode(mode CassetteMode) CassetteOption {
+	return func(c *CassetteClient) {
+		c.mode = mode
+	}
+}
+
+// Functional option to set how the text of messages is normalized before
+// matching, e.g. to blank out dates in the system prompt. Defaults to
+// NormalizeWhitespace
+func WithCassetteNormalizer(normalize func(text string) string) CassetteOption {
+	return func(c *CassetteClient) {
+		c.normalize = normalize
+	}
+}
+
+// NormalizeWhitespace trims the text and collapses runs of whitespace,
+// so reformatted prompts still match
+func NormalizeWhitespace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// CreateCompletion replays the recorded response of the request,
+// records it or passes it through depending on the mode. If saving a new
+// recording fails, the response is returned along with the error and the
+// interaction is written with the next save
+func (c *CassetteClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	if c.mode == CassettePassthrough {
+		return c.client.CreateCompletion(ctx, messages, responseSchema, model, modelApiParameters)
+	}
+
+	request, err := c.normalizeRequest(messages, responseSchema, model, modelApiParameters)
+	if err != nil {
+		return agent.CompletionResponse{}, err
+	}
+	key, err := json.Marshal(request)
+	if err != nil {
+		return agent.CompletionResponse{}, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	c.mu.Lock()
+	recorded, ok := c.replay(string(key), c.mode == CassetteStrict)
+	if !ok && c.mode == CassetteStrict {
+		err := c.mismatch(request)
+		c.mu.Unlock()
+		return agent.CompletionResponse{}, err
+	}
+	c.mu.Unlock()
+	if ok {
+		return agent.CompletionResponse{Prompt: recorded.Prompt, Backend: recorded.Backend}, nil
+	}
+
+	// The live request runs without the lock so concurrent requests don't
+	// wait for each other, interactions are recorded in the order they finish
+	response, err := c.client.CreateCompletion(ctx, messages, responseSchema, model, modelApiParameters)
+	if err != nil {
+		return agent.CompletionResponse{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.interactions = append(c.interactions, Interaction{
+		Request:  request,
+		Response: CassetteResponse{Prompt: response.Prompt, Backend: response.Backend},
+	})
+	c.used = append(c.used, true)
+	if err := c.save(); err != nil {
+		return response, err
+	}
+	return response, nil
+}
+
+// Interactions returns the recorded interactions
+func (c *CassetteClient) Interactions() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Interaction{}, c.interactions...)
+}
+
+// Unused returns the recorded interactions that haven't been replayed,
+// tests can check it's empty to catch requests they no longer make
+func (c *CassetteClient) Unused() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unused := []Interaction{}
+	for i, interaction := range c.interactions {
+		if !c.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+// replay returns the response of the first unused interaction matching the
+// key. If all were used, the last matching one is reused if reuse is set,
+// otherwise the request is recorded again. The caller holds the lock
+func (c *CassetteClient) replay(key string, reuse bool) (CassetteResponse, bool) {
+	last := -1
+	for i, interaction := range c.interactions {
+		recorded, err := json.Marshal(interaction.Request)
+		if err != nil || string(recorded) != key {
+			continue
+		}
+		if !c.used[i] {
+			c.used[i] = true
+			return interaction.Response, true
+		}
+		last = i
+	}
+	if last < 0 || !reuse {
+		return CassetteResponse{}, false
+	}
+	return c.interactions[last].Response, true
+}
+
+// mismatch returns the error for an unrecorded request,
+// showing how it differs from the closest recorded one
+func (c *CassetteClient) mismatch(request CassetteRequest) error {
+	if len(c.interactions) == 0 {
+		return fmt.Errorf("%w: cassette %s is empty", ErrCassetteMismatch, c.path)
+	}
+
+	actual := indentedLines(request)
+	closest, closestDiff, closestScore := -1, "", -1
+	for i, interaction := range c.interactions {
+		recorded := indentedLines(interaction.Request)
+		if score := len(commonLines(recorded, actual)); score > closestScore {
+			closest, closestScore = i, score
+			closestDiff = lineDiff(recorded, actual)
+		}
+	}
+	return fmt.Errorf("%w in cassette %s, diff to the closest recorded request %d (- recorded, + actual):\n%s",
+		ErrCassetteMismatch, c.path, closest, closestDiff)
+}
+
+// normalizeRequest turns a request into its recorded form. Content and
+// parameters are round tripped through json, so structs and maps with
+// the same fields match and numbers compare by value
+func (c *CassetteClient) normalizeRequest(messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (CassetteRequest, error) {
+	request := CassetteRequest{Model: model, Messages: make([]CassetteMessage, len(messages))}
+	if responseSchema != nil {
+		request.Schema = responseSchema.String()
+	}
+	if len(modelApiParameters) > 0 {
+		if err := roundTrip(modelApiParameters, &request.Parameters); err != nil {
+			return CassetteRequest{}, fmt.Errorf("failed to normalize model API parameters: %w", err)
+		}
+	}
+
+	for i, msg := range messages {
+		var content any
+		if text, ok := msg.Content.Content.(string); ok {
+			content = c.normalize(text)
+		} else if err := roundTrip(msg.Content.Content, &content); err != nil {
+			return CassetteRequest{}, fmt.Errorf("failed to normalize message %d: %w", i, err)
+		}
+		request.Messages[i] = CassetteMessage{Role: msg.Role, Content: content}
+	}
+	return request, nil
+}
+
+// save writes the cassette file. The file is replaced atomically
+// so an interrupted test run never leaves a broken cassette behind.
+// The caller holds the lock
+func (c *CassetteClient) save() error {
+	data, err := json.MarshalIndent(cassetteFile{Version: 1, Interactions: c.interactions}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette %s: %w", c.path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("failed to save cassette %s: %w", c.path, err)
+	}
+	if err := utils.WriteFileAtomic(c.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save cassette %s: %w", c.path, err)
+	}
+	return nil
+}
+
+// roundTrip encodes the value to json and decodes it into target
+func roundTrip(value any, target any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// indentedLines returns the lines of the indented json encoding of the request
+func indentedLines(request CassetteRequest) []string {
+	data, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return strings.Split(string(data), "\n")
+}
+
+// commonLines returns the longest common subsequence of two line lists
+func commonLines(a []string, b []string) []string {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	common := []string{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			common = append(common, a[i])
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return common
+}
+
+// lineDiff returns a diff of two line lists, removed lines are prefixed
+// with "-", added ones with "+" and unchanged ones with a space
+func lineDiff(recorded []string, actual []string) string {
+	var diff strings.Builder
+	i, j := 0, 0
+	for _, line := range commonLines(recorded, actual) {
+		for ; recorded[i] != line; i++ {
+			diff.WriteString("- " + recorded[i] + "\n")
+		}
+		for ; actual[j] != line; j++ {
+			diff.WriteString("+ " + actual[j] + "\n")
+		}
+		diff.WriteString("  " + line + "\n")
+		i++
+		j++
+	}
+	for ; i < len(recorded); i++ {
+		diff.WriteString("- " + recorded[i] + "\n")
+	}
+	for ; j < len(actual); j++ {
+		diff.WriteString("+ " + actual[j] + "\n")
+	}
+	return diff.String()
+}
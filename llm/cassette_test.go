@@ -0,0 +1,264 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake LLM client numbering its answers to the last message
+type EchoLLMClient struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *EchoLLMClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls++
+	return agent.CompletionResponse{Prompt: fmt.Sprintf("answer %d to %v", c.calls, messages[len(messages)-1].Content.Content)}, nil
+}
+
+type cassetteQuestion struct {
+	Question string `json:"question"`
+}
+
+func conversationMessages(texts ...string) []memory.Message {
+	messages := []memory.Message{}
+	for _, text := range texts {
+		messages = append(messages, memory.Message{Role: "user", Content: memory.MessageContent{TypeName: "string", Content: text}})
+	}
+	return messages
+}
+
+func recordCassette(t *testing.T, path string, requests ...[]memory.Message) {
+	t.Helper()
+	recorder, err := NewCassetteClient(path, &EchoLLMClient{}, WithCassetteMode(CassetteRecordMissing))
+	assert.NoError(t, err)
+	for _, messages := range requests {
+		_, err := recorder.CreateCompletion(context.Background(), messages, reflect.TypeOf(""), "model", map[string]any{"temperature": 0})
+		assert.NoError(t, err)
+	}
+}
+
+func TestCassetteClient_RecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassettes", "faq.json")
+	recordCassette(t, path, conversationMessages("Hi"), conversationMessages("Hi", "When are you open?"))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "\"prompt\": \"answer 1 to Hi\"")
+	assert.Contains(t, string(data), "\"version\": 1")
+
+	// Replaying never calls a client, turn ids, metadata and whitespace don't matter
+	replayer, err := NewCassetteClient(path, nil)
+	assert.NoError(t, err)
+	messages := conversationMessages("  Hi\n", "When  are you open?")
+	messages[0].TurnId = "turn-1"
+	messages[1].Metadata = map[string]string{"prompt_hash": "abc"}
+	response, err := replayer.CreateCompletion(context.Background(), messages, reflect.TypeOf(""), "model", map[string]any{"temperature": 0.0})
+	assert.NoError(t, err)
+	assert.Equal(t, "answer 2 to When are you open?", response.Prompt)
+
+	assert.Equal(t, 2, len(replayer.Interactions()))
+	assert.Equal(t, 1, len(replayer.Unused()))
+	assert.Equal(t, "Hi", replayer.Unused()[0].Request.Messages[0].Content)
+}
+
+func TestCassetteClient_RecordMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faq.json")
+	recordCassette(t, path, conversationMessages("Hi"))
+
+	client := &EchoLLMClient{}
+	recorder, err := NewCassetteClient(path, client, WithCassetteMode(CassetteRecordMissing))
+	assert.NoError(t, err)
+	for _, text := range []string{"Hi", "Bye"} {
+		_, err := recorder.CreateCompletion(context.Background(), conversationMessages(text), reflect.TypeOf(""), "model", map[string]any{"temperature": 0})
+		assert.NoError(t, err)
+	}
+	// Only the missing request was sent to the client and added to the cassette
+	assert.Equal(t, 1, client.calls)
+
+	replayer, err := NewCassetteClient(path, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(replayer.Interactions()))
+}
+
+func TestCassetteClient_RepeatedRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faq.json")
+	recordCassette(t, path, conversationMessages("Tell me a joke"), conversationMessages("Tell me a joke"))
+
+	replayer, err := NewCassetteClient(path, nil)
+	assert.NoError(t, err)
+	prompts := []string{}
+	for range 3 {
+		response, err := replayer.CreateCompletion(context.Background(), conversationMessages("Tell me a joke"), reflect.TypeOf(""), "model", map[string]any{"temperature": 0})
+		assert.NoError(t, err)
+		prompts = append(prompts, response.Prompt)
+	}
+	// Responses replay in recorded order, the last one is repeated after that
+	assert.Equal(t, []string{"answer 1 to Tell me a joke", "answer 2 to Tell me a joke", "answer 2 to Tell me a joke"}, prompts)
+	assert.Empty(t, replayer.Unused())
+}
+
+func TestCassetteClient_RecordsConcurrently(t *testing.T) {
+	client := &BlockingLLMClient{release: make(chan struct{})}
+	recorder, err := NewCassetteClient(filepath.Join(t.TempDir(), "faq.json"), client, WithCassetteMode(CassetteRecordMissing))
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for _, text := range []string{"Hi", "Bye"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := recorder.CreateCompletion(context.Background(), conversationMessages(text), reflect.TypeOf(""), "model", nil)
+			assert.NoError(t, err)
+		}()
+	}
+
+	// Both requests reach the client, none waits for the other to be recorded
+	assert.Eventually(t, func() bool { return client.inFlight.Load() == 2 }, time.Second, time.Millisecond)
+	close(client.release)
+	wg.Wait()
+	assert.Equal(t, 2, len(recorder.Interactions()))
+}
+
+func TestCassetteClient_SaveError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faq.json")
+	recorder, err := NewCassetteClient(path, &EchoLLMClient{}, WithCassetteMode(CassetteRecordMissing))
+	assert.NoError(t, err)
+
+	// A non-empty directory in place of the cassette can't be replaced
+	assert.NoError(t, os.MkdirAll(filepath.Join(path, "blocker"), 0o755))
+
+	// The response isn't lost when the cassette can't be written
+	response, err := recorder.CreateCompletion(context.Background(), conversationMessages("Hi"), reflect.TypeOf(""), "model", nil)
+	assert.ErrorContains(t, err, "failed to save cassette")
+	assert.Equal(t, "answer 1 to Hi", response.Prompt)
+
+	// The interaction is written with the next save
+	assert.NoError(t, os.RemoveAll(path))
+	_, err = recorder.CreateCompletion(context.Background(), conversationMessages("Bye"), reflect.TypeOf(""), "model", nil)
+	assert.NoError(t, err)
+	replayer, err := NewCassetteClient(path, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(replayer.Interactions()))
+}
+
+func TestCassetteClient_StrictMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faq.json")
+	recordCassette(t, path, conversationMessages("Hi", "When are you open?"), conversationMessages("Can I get a refund?"))
+
+	replayer, err := NewCassetteClient(path, nil)
+	assert.NoError(t, err)
+	_, err = replayer.CreateCompletion(context.Background(), conversationMessages("Hi", "When do you close?"), reflect.TypeOf(""), "model", map[string]any{"temperature": 0})
+	assert.ErrorIs(t, err, ErrCassetteMismatch)
+	assert.ErrorContains(t, err, "closest recorded request 0")
+	assert.ErrorContains(t, err, "-       \"content\": \"When are you open?\"\n")
+	assert.ErrorContains(t, err, "+       \"content\": \"When do you close?\"\n")
+	assert.ErrorContains(t, err, "        \"content\": \"Hi\"\n")
+
+	// Other models, schemas and parameters don't match either
+	_, err = replayer.CreateCompletion(context.Background(), conversationMessages("Can I get a refund?"), reflect.TypeOf(""), "other-model", map[string]any{"temperature": 0})
+	assert.ErrorContains(t, err, "+   \"model\": \"other-model\"")
+	_, err = replayer.CreateCompletion(context.Background(), conversationMessages("Can I get a refund?"), reflect.TypeOf(0), "model", map[string]any{"temperature": 0})
+	assert.ErrorIs(t, err, ErrCassetteMismatch)
+	_, err = replayer.CreateCompletion(context.Background(), conversationMessages("Can I get a refund?"), reflect.TypeOf(""), "model", nil)
+	assert.ErrorIs(t, err, ErrCassetteMismatch)
+}
+
+func TestCassetteClient_StructuredContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faq.json")
+	recorder, err := NewCassetteClient(path, &EchoLLMClient{}, WithCassetteMode(CassetteRecordMissing))
+	assert.NoError(t, err)
+	messages := []memory.Message{{Role: "user", Content: memory.MessageContent{TypeName: "cassetteQuestion", Content: cassetteQuestion{Question: "Hi"}}}}
+	_, err = recorder.CreateCompletion(context.Background(), messages, nil, "model", nil)
+	assert.NoError(t, err)
+
+	// Decoded content, e.g. from a saved memory, matches the original struct
+	replayer, err := NewCassetteClient(path, nil)
+	assert.NoError(t, err)
+	messages[0].Content.Content = map[string]any{"question": "Hi"}
+	_, err = replayer.CreateCompletion(context.Background(), messages, nil, "model", nil)
+	assert.NoError(t, err)
+}
+
+func TestCassetteClient_Normalizer(t *testing.T) {
+	dates := regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+	normalize := func(text string) string { return dates.ReplaceAllString(NormalizeWhitespace(text), "<date>") }
+	path := filepath.Join(t.TempDir(), "faq.json")
+
+	recorder, err := NewCassetteClient(path, &EchoLLMClient{}, WithCassetteMode(CassetteRecordMissing), WithCassetteNormalizer(normalize))
+	assert.NoError(t, err)
+	_, err = recorder.CreateCompletion(context.Background(), conversationMessages("Today is 2025-01-01"), nil, "model", nil)
+	assert.NoError(t, err)
+
+	replayer, err := NewCassetteClient(path, nil, WithCassetteNormalizer(normalize))
+	assert.NoError(t, err)
+	_, err = replayer.CreateCompletion(context.Background(), conversationMessages("Today is 2025-06-30"), nil, "model", nil)
+	assert.NoError(t, err)
+}
+
+func TestCassetteClient_Passthrough(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faq.json")
+	client := &EchoLLMClient{}
+	passthrough, err := NewCassetteClient(path, client, WithCassetteMode(CassettePassthrough))
+	assert.NoError(t, err)
+
+	for range 2 {
+		_, err := passthrough.CreateCompletion(context.Background(), conversationMessages("Hi"), nil, "model", nil)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, client.calls)
+	assert.NoFileExists(t, path)
+}
+
+func TestNewCassetteClient_Invalid(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewCassetteClient(filepath.Join(dir, "missing.json"), nil)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	_, err = NewCassetteClient(filepath.Join(dir, "missing.json"), nil, WithCassetteMode(CassetteRecordMissing))
+	assert.ErrorContains(t, err, "need a client")
+
+	broken := filepath.Join(dir, "broken.json")
+	assert.NoError(t, os.WriteFile(broken, []byte("{"), 0o644))
+	_, err = NewCassetteClient(broken, nil)
+	assert.ErrorContains(t, err, "failed to decode cassette")
+}
+
+func TestCassetteClient_WithAgent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	run := func(client agent.LLMClient) []string {
+		a, err := agent.NewBaseAgent(agent.WithClient(client), agent.WithModel("model"))
+		assert.NoError(t, err)
+		prompts := []string{}
+		for _, question := range []string{"Hi", "When are you open?"} {
+			response, err := a.Run(context.Background(), question)
+			assert.NoError(t, err)
+			prompts = append(prompts, response.Prompt)
+		}
+		return prompts
+	}
+
+	recorder, err := NewCassetteClient(path, &EchoLLMClient{}, WithCassetteMode(CassetteRecordMissing))
+	assert.NoError(t, err)
+	recorded := run(recorder)
+
+	replayer, err := NewCassetteClient(path, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, recorded, run(replayer))
+	assert.Empty(t, replayer.Unused())
+}
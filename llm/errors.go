@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error classes of failed completions, match them with errors.Is
+var (
+	// ErrRateLimited means the provider throttled the request, retrying later may succeed
+	ErrRateLimited = errors.New("rate limited")
+	// ErrServerError means the provider failed, retrying may succeed
+	ErrServerError = errors.New("server error")
+	// ErrTimeout means the request took too long, retrying may succeed
+	ErrTimeout = errors.New("timeout")
+	// ErrInvalidRequest means the provider rejected the request, retrying won't help
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrAuth means the credentials are missing, invalid or lack permissions
+	ErrAuth = errors.New("authentication failed")
+)
+
+// APIError is an error response of a model provider. LLMClient implementations
+// return it so that callers can classify failures and honor Retry-After hints
+type APIError struct {
+	// Kind is the error class, one of the Err* sentinels or nil if unknown
+	Kind       error
+	StatusCode int
+	Message    string
+	retryAfter time.Duration
+}
+
+// NewAPIError creates an error for a response status code, classifying it.
+// retryAfter is the server's hint how long to wait before retrying, 0 if none
+func NewAPIError(statusCode int, message string, retryAfter time.Duration) *APIError {
+	return &APIError{
+		Kind:       ClassifyStatus(statusCode),
+		StatusCode: statusCode,
+		Message:    message,
+		retryAfter: retryAfter,
+	}
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("api error %d", e.StatusCode)
+	}
+	return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Message)
+}
+
+// Is matches the error's class
+func (e *APIError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// RetryAfter returns the server's hint how long to wait before retrying
+func (e *APIError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// ClassifyStatus returns the error class of an http status code, nil if
+// the status isn't an error or has no class
+func ClassifyStatus(statusCode int) error {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrAuth
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		return ErrTimeout
+	case statusCode >= 500:
+		return ErrServerError
+	case statusCode >= 400:
+		return ErrInvalidRequest
+	default:
+		return nil
+	}
+}
+
+// Classify returns the class of an error, nil if it can't be classified.
+// Besides the sentinels and API errors, deadlines and network timeouts
+// are classified as ErrTimeout
+func Classify(err error) error {
+	for _, kind := range []error{ErrRateLimited, ErrServerError, ErrTimeout, ErrInvalidRequest, ErrAuth} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// RetryAfter returns the Retry-After hint of an error implementing
+// RetryAfter() time.Duration, like APIError
+func RetryAfter(err error) (time.Duration, bool) {
+	var hinted interface{ RetryAfter() time.Duration }
+	if errors.As(err, &hinted) && hinted.RetryAfter() > 0 {
+		return hinted.RetryAfter(), true
+	}
+	return 0, false
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, either
+// a number of seconds or an http date relative to now
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
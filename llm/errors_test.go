@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Network error timing out
+type timeoutError struct{}
+
+func (e timeoutError) Error() string   { return "i/o timeout" }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+func TestClassifyStatus(t *testing.T) {
+	assert.Equal(t, ErrRateLimited, ClassifyStatus(http.StatusTooManyRequests))
+	assert.Equal(t, ErrAuth, ClassifyStatus(http.StatusUnauthorized))
+	assert.Equal(t, ErrAuth, ClassifyStatus(http.StatusForbidden))
+	assert.Equal(t, ErrTimeout, ClassifyStatus(http.StatusGatewayTimeout))
+	assert.Equal(t, ErrServerError, ClassifyStatus(http.StatusServiceUnavailable))
+	assert.Equal(t, ErrInvalidRequest, ClassifyStatus(http.StatusBadRequest))
+	assert.Nil(t, ClassifyStatus(http.StatusOK))
+}
+
+func TestAPIError(t *testing.T) {
+	err := fmt.Errorf("completion failed: %w", NewAPIError(429, "slow down", 2*time.Second))
+
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.NotErrorIs(t, err, ErrServerError)
+	assert.EqualError(t, err, "completion failed: api error 429: slow down")
+
+	var apiErr *APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 429, apiErr.StatusCode)
+
+	hint, ok := RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, hint)
+
+	_, ok = RetryAfter(NewAPIError(500, "", 0))
+	assert.False(t, ok)
+}
+
+func TestClassify(t *testing.T) {
+	assert.Equal(t, ErrServerError, Classify(NewAPIError(502, "bad gateway", 0)))
+	assert.Equal(t, ErrAuth, Classify(fmt.Errorf("wrapped: %w", ErrAuth)))
+	assert.Equal(t, ErrTimeout, Classify(context.DeadlineExceeded))
+	assert.Equal(t, ErrTimeout, Classify(fmt.Errorf("read: %w", timeoutError{})))
+	assert.Nil(t, Classify(errors.New("unknown")))
+	assert.Nil(t, Classify(context.Canceled))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	delay, ok := ParseRetryAfter("120", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, delay)
+
+	delay, ok = ParseRetryAfter("Wed, 01 Jan 2025 12:00:30 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, delay)
+
+	// Dates in the past mean retrying right away
+	delay, ok = ParseRetryAfter("Wed, 01 Jan 2025 11:00:00 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), delay)
+
+	_, ok = ParseRetryAfter("soon", now)
+	assert.False(t, ok)
+	_, ok = ParseRetryAfter("-1", now)
+	assert.False(t, ok)
+}
@@ -0,0 +1,266 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/robnmrz/onigiri/prompt"
+)
+
+// Option type for RateLimitClient
+type RateLimitOption func(*RateLimitClient)
+
+// QueueEvent describes how long a request waited for the rate limits
+type QueueEvent struct {
+	Wait time.Duration
+	// Tokens is the estimated number of tokens the request was charged
+	Tokens int
+	// Err is set if the request gave up waiting
+	Err error
+}
+
+// RateLimitStats are the counters of a RateLimitClient
+type RateLimitStats struct {
+	// Requests is the number of requests that were let through
+	Requests int64
+	// Cancelled is the number of requests whose context ended while queued
+	Cancelled int64
+	// Queued and InFlight are the requests currently waiting and running
+	Queued   int
+	InFlight int
+	// TotalQueueTime and MaxQueueTime cover all requests that were let through
+	TotalQueueTime time.Duration
+	MaxQueueTime   time.Duration
+}
+
+// AverageQueueTime returns the mean time requests waited before being sent
+func (s RateLimitStats) AverageQueueTime() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalQueueTime / time.Duration(s.Requests)
+}
+
+// RateLimitClient is an LLMClient decorator keeping requests within a
+// provider's quotas. Requests per minute and tokens per minute are enforced
+// with token buckets and a semaphore limits the requests in flight.
+// Requests over the limits are queued until there is room or their context
+// is done. Share one RateLimitClient between all agents using the same quota,
+// it is safe for concurrent use
+type RateLimitClient struct {
+	client   agent.LLMClient
+	requests *tokenBucket
+	tokens   *tokenBucket
+	slots    chan struct{}
+	estimate prompt.TokenEstimator
+	onQueued func(QueueEvent)
+	now      func() time.Time
+	sleep    func(ctx context.Context, delay time.Duration) error
+
+	mu    sync.Mutex
+	stats RateLimitStats
+}
+
+// Constructor for a new RateLimitClient wrapping the client.
+// Without options no limits are enforced
+func NewRateLimitClient(client agent.LLMClient, opts ...RateLimitOption) *RateLimitClient {
+	l := &RateLimitClient{
+		client:   client,
+		estimate: prompt.EstimateTokens,
+		now:      time.Now,
+		sleep:    sleep,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Functional option to limit the requests per minute, bursts of up to
+// the full minute's requests are allowed
+func WithRequestsPerMinute(requests int) RateLimitOption {
+	return func(l *RateLimitClient) {
+		l.requests = newTokenBucket(requests)
+	}
+}
+
+// Functional option to limit the tokens per minute. Requests are charged the
+// estimated tokens of their messages plus their max_tokens parameter
+func WithTokensPerMinute(tokens int) RateLimitOption {
+	return func(l *RateLimitClient) {
+		l.tokens = newTokenBucket(tokens)
+	}
+}
+
+// Functional option to limit the number of requests in flight at once
+func WithMaxConcurrency(concurrency int) RateLimitOption {
+	return func(l *RateLimitClient) {
+		l.slots = make(chan struct{}, max(concurrency, 1))
+	}
+}
+
+// Functional option to set how the tokens of requests are estimated,
+// defaults to prompt.EstimateTokens
+func WithRateLimitEstimator(estimator prompt.TokenEstimator) RateLimitOption {
+	return func(l *RateLimitClient) {
+		l.estimate = estimator
+	}
+}
+
+// Functional option to get notified when a request leaves the queue, e.g. for metrics
+func WithQueueHook(hook func(QueueEvent)) RateLimitOption {
+	return func(l *RateLimitClient) {
+		l.onQueued = hook
+	}
+}
+
+// CreateCompletion waits until the request fits the limits and sends it
+func (l *RateLimitClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	tokens := l.cost(messages, modelApiParameters)
+
+	start := l.now()
+	release, err := l.acquire(ctx, tokens)
+	wait := l.now().Sub(start)
+
+	l.mu.Lock()
+	if err != nil {
+		l.stats.Cancelled++
+	} else {
+		l.stats.Requests++
+		l.stats.TotalQueueTime += wait
+		l.stats.MaxQueueTime = max(l.stats.MaxQueueTime, wait)
+	}
+	l.mu.Unlock()
+	if l.onQueued != nil {
+		l.onQueued(QueueEvent{Wait: wait, Tokens: tokens, Err: err})
+	}
+	if err != nil {
+		return agent.CompletionResponse{}, fmt.Errorf("waiting for rate limit: %w", err)
+	}
+
+	defer release()
+	return l.client.CreateCompletion(ctx, messages, responseSchema, model, modelApiParameters)
+}
+
+// Stats returns a snapshot of the counters
+func (l *RateLimitClient) Stats() RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stats
+}
+
+// acquire waits for the buckets to hold the request and then for a
+// concurrency slot. The returned function frees the slot once the request is done
+func (l *RateLimitClient) acquire(ctx context.Context, tokens int) (func(), error) {
+	l.mu.Lock()
+	l.stats.Queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.stats.Queued--
+		l.mu.Unlock()
+	}()
+
+	// Reserving ahead serves waiting requests in the order they arrived.
+	// The concurrency slot is only taken after the wait, so that sleeping
+	// requests don't keep others from running
+	l.mu.Lock()
+	now := l.now()
+	delay := max(l.requests.reserve(now, 1), l.tokens.reserve(now, float64(tokens)))
+	l.mu.Unlock()
+	cancel := func() {
+		l.mu.Lock()
+		l.requests.cancel(1)
+		l.tokens.cancel(float64(tokens))
+		l.mu.Unlock()
+	}
+
+	if delay > 0 {
+		if err := l.sleep(ctx, delay); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			cancel()
+			return nil, ctx.Err()
+		}
+	}
+
+	l.mu.Lock()
+	l.stats.InFlight++
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		l.stats.InFlight--
+		l.mu.Unlock()
+		if l.slots != nil {
+			<-l.slots
+		}
+	}, nil
+}
+
+// cost estimates the tokens a request uses, its messages and the answer
+func (l *RateLimitClient) cost(messages []memory.Message, modelApiParameters map[string]any) int {
+	tokens := l.estimate(messagesText(messages))
+	switch maxTokens := modelApiParameters["max_tokens"].(type) {
+	case int:
+		tokens += maxTokens
+	case float64:
+		tokens += int(maxTokens)
+	}
+	return tokens
+}
+
+// tokenBucket refills its capacity once per minute. Reservations may take
+// the bucket below zero, the deficit is the time the reservation has to wait.
+// A nil bucket has no limit
+type tokenBucket struct {
+	capacity float64
+	// rate is the refill per second
+	rate    float64
+	tokens  float64
+	updated time.Time
+}
+
+// newTokenBucket returns a full bucket for the amount per minute
+func newTokenBucket(perMinute int) *tokenBucket {
+	capacity := float64(max(perMinute, 1))
+	return &tokenBucket{capacity: capacity, rate: capacity / 60, tokens: capacity}
+}
+
+// reserve takes n tokens and returns how long to wait until they are available.
+// Requests larger than the capacity are charged the capacity so they can pass
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	if !b.updated.IsZero() {
+		elapsed := now.Sub(b.updated).Seconds()
+		b.tokens = min(b.capacity, b.tokens+elapsed*b.rate)
+	}
+	b.updated = now
+
+	b.tokens -= min(n, b.capacity)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns the tokens of a reservation that gave up waiting
+func (b *tokenBucket) cancel(n float64) {
+	if b == nil {
+		return
+	}
+	b.tokens = min(b.capacity, b.tokens+min(n, b.capacity))
+}
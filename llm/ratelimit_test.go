@@ -0,0 +1,233 @@
+package llm
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake LLM client blocking until released and tracking the calls in flight
+type BlockingLLMClient struct {
+	release     chan struct{}
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (c *BlockingLLMClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	current := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	for {
+		previous := c.maxInFlight.Load()
+		if current <= previous || c.maxInFlight.CompareAndSwap(previous, current) {
+			break
+		}
+	}
+
+	select {
+	case <-c.release:
+		return agent.CompletionResponse{Prompt: "ok"}, nil
+	case <-ctx.Done():
+		return agent.CompletionResponse{}, ctx.Err()
+	}
+}
+
+// newTestRateLimitClient uses a fake clock that advances when sleeping
+// and records the delays
+func newTestRateLimitClient(client agent.LLMClient, delays *[]time.Duration, opts ...RateLimitOption) *RateLimitClient {
+	l := NewRateLimitClient(client, opts...)
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return clock }
+	l.sleep = func(ctx context.Context, delay time.Duration) error {
+		*delays = append(*delays, delay)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		clock = clock.Add(delay)
+		return nil
+	}
+	return l
+}
+
+func TestRateLimitClient_NoLimits(t *testing.T) {
+	delays := []time.Duration{}
+	l := newTestRateLimitClient(&ScriptedLLMClient{}, &delays)
+
+	for range 100 {
+		_, err := l.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", nil)
+		assert.NoError(t, err)
+	}
+	assert.Empty(t, delays)
+	assert.Equal(t, int64(100), l.Stats().Requests)
+}
+
+func TestRateLimitClient_RequestsPerMinute(t *testing.T) {
+	delays := []time.Duration{}
+	l := newTestRateLimitClient(&ScriptedLLMClient{}, &delays, WithRequestsPerMinute(60))
+
+	// A full minute's requests may burst, after that one per second is let through
+	for range 62 {
+		_, err := l.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", nil)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []time.Duration{time.Second, time.Second}, delays)
+
+	stats := l.Stats()
+	assert.Equal(t, int64(62), stats.Requests)
+	assert.Equal(t, 2*time.Second, stats.TotalQueueTime)
+	assert.Equal(t, time.Second, stats.MaxQueueTime)
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, 0, stats.Queued)
+}
+
+func TestRateLimitClient_TokensPerMinute(t *testing.T) {
+	delays := []time.Duration{}
+	events := []QueueEvent{}
+	l := newTestRateLimitClient(&ScriptedLLMClient{}, &delays,
+		WithTokensPerMinute(1200),
+		WithRateLimitEstimator(func(text string) int { return len(text) }),
+		WithQueueHook(func(event QueueEvent) { events = append(events, event) }),
+	)
+
+	// "Hi\n" and the max tokens add up to 600 tokens per request
+	parameters := map[string]any{"max_tokens": 597}
+	for range 3 {
+		_, err := l.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", parameters)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []time.Duration{30 * time.Second}, delays)
+	assert.Equal(t, []QueueEvent{{Tokens: 600}, {Tokens: 600}, {Wait: 30 * time.Second, Tokens: 600}}, events)
+	assert.Equal(t, 10*time.Second, l.Stats().AverageQueueTime())
+
+	// Requests larger than the whole budget wait for a full bucket
+	delays = delays[:0]
+	_, err := l.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", map[string]any{"max_tokens": 5000.0})
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Minute}, delays)
+}
+
+func TestRateLimitClient_CancelledWhileWaiting(t *testing.T) {
+	delays := []time.Duration{}
+	client := &ScriptedLLMClient{}
+	l := newTestRateLimitClient(client, &delays, WithRequestsPerMinute(1))
+
+	_, err := l.CreateCompletion(context.Background(), nil, reflect.TypeOf(""), "model", nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.CreateCompletion(ctx, nil, reflect.TypeOf(""), "model", nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "waiting for rate limit")
+	assert.Equal(t, 1, client.calls)
+	assert.Equal(t, int64(1), l.Stats().Cancelled)
+
+	// The cancelled request gave its reservation back
+	assert.Equal(t, 0.0, l.requests.tokens)
+}
+
+func TestRateLimitClient_MaxConcurrency(t *testing.T) {
+	client := &BlockingLLMClient{release: make(chan struct{})}
+	l := NewRateLimitClient(client, WithMaxConcurrency(2))
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := l.CreateCompletion(context.Background(), nil, reflect.TypeOf(""), "model", nil)
+			assert.NoError(t, err)
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		stats := l.Stats()
+		return stats.InFlight == 2 && stats.Queued == 2
+	}, time.Second, time.Millisecond)
+	close(client.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(2), client.maxInFlight.Load())
+	stats := l.Stats()
+	assert.Equal(t, int64(4), stats.Requests)
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, 0, stats.Queued)
+	assert.Greater(t, stats.MaxQueueTime, time.Duration(0))
+}
+
+func TestRateLimitClient_SleepsWithoutSlot(t *testing.T) {
+	client := &BlockingLLMClient{release: make(chan struct{})}
+	l := NewRateLimitClient(client, WithMaxConcurrency(1), WithRequestsPerMinute(1))
+	sleeping := make(chan struct{})
+	wake := make(chan struct{})
+	l.sleep = func(ctx context.Context, delay time.Duration) error {
+		close(sleeping)
+		<-wake
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := l.CreateCompletion(context.Background(), nil, reflect.TypeOf(""), "model", nil)
+			assert.NoError(t, err)
+		}()
+	}
+
+	// The second request waits for the bucket while the first one runs,
+	// it isn't in flight until it got past the bucket and took the slot
+	<-sleeping
+	assert.Eventually(t, func() bool {
+		stats := l.Stats()
+		return stats.InFlight == 1 && stats.Queued == 1
+	}, time.Second, time.Millisecond)
+
+	close(wake)
+	close(client.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), client.maxInFlight.Load())
+	assert.Equal(t, 0, l.Stats().InFlight)
+}
+
+func TestRateLimitClient_QueueTimeout(t *testing.T) {
+	client := &BlockingLLMClient{release: make(chan struct{})}
+	l := NewRateLimitClient(client, WithMaxConcurrency(1))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := l.CreateCompletion(context.Background(), nil, reflect.TypeOf(""), "model", nil)
+		assert.NoError(t, err)
+	}()
+	assert.Eventually(t, func() bool { return l.Stats().InFlight == 1 }, time.Second, time.Millisecond)
+
+	// A queued request gives up when its context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := l.CreateCompletion(ctx, nil, reflect.TypeOf(""), "model", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(client.release)
+	<-done
+	stats := l.Stats()
+	assert.Equal(t, int64(1), stats.Requests)
+	assert.Equal(t, int64(1), stats.Cancelled)
+}
+
+func TestRateLimitClient_WithAgent(t *testing.T) {
+	l := NewRateLimitClient(&ScriptedLLMClient{}, WithRequestsPerMinute(10), WithMaxConcurrency(1))
+	a, err := agent.NewBaseAgent(agent.WithClient(l), agent.WithModel("model"))
+	assert.NoError(t, err)
+
+	_, err = a.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), l.Stats().Requests)
+}
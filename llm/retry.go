@@ -0,0 +1,162 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+)
+
+// Option type for RetryClient
+type RetryOption func(*RetryClient)
+
+// RetryEvent describes a failed attempt that is about to be retried
+type RetryEvent struct {
+	Attempt int
+	Err     error
+	Kind    error
+	Delay   time.Duration
+}
+
+// RetryClient is an LLMClient decorator retrying failed completions with
+// jittered exponential backoff. Only rate limits, server errors and timeouts
+// are retried by default, a server's Retry-After hint replaces the backoff.
+// Returned errors match their class with errors.Is, e.g. errors.Is(err, ErrAuth)
+type RetryClient struct {
+	client        agent.LLMClient
+	maxAttempts   int
+	baseDelay     time.Duration
+	maxDelay      time.Duration
+	maxRetryAfter time.Duration
+	retryOn       []error
+	onRetry       func(RetryEvent)
+	random        func() float64
+	sleep         func(ctx context.Context, delay time.Duration) error
+}
+
+// Constructor for a new RetryClient wrapping the client. By default it makes
+// up to 4 attempts, waiting 500ms, 1s and 2s with jitter in between
+func NewRetryClient(client agent.LLMClient, opts ...RetryOption) *RetryClient {
+	r := &RetryClient{
+		client:        client,
+		maxAttempts:   4,
+		baseDelay:     500 * time.Millisecond,
+		maxDelay:      30 * time.Second,
+		maxRetryAfter: time.Minute,
+		retryOn:       []error{ErrRateLimited, ErrServerError, ErrTimeout},
+		random:        rand.Float64,
+		sleep:         sleep,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Functional option to set the maximum number of attempts including the first one
+func WithMaxAttempts(attempts int) RetryOption {
+	return func(r *RetryClient) {
+		r.maxAttempts = max(attempts, 1)
+	}
+}
+
+// Functional option to set the delay before the first retry, which doubles
+// with every further retry up to maxDelay
+func WithBackoff(baseDelay time.Duration, maxDelay time.Duration) RetryOption {
+	return func(r *RetryClient) {
+		r.baseDelay = baseDelay
+		r.maxDelay = maxDelay
+	}
+}
+
+// Functional option to set the longest Retry-After hint that is waited for,
+// defaults to a minute. Errors with longer hints are returned right away
+func WithMaxRetryAfter(maxRetryAfter time.Duration) RetryOption {
+	return func(r *RetryClient) {
+		r.maxRetryAfter = maxRetryAfter
+	}
+}
+
+// Functional option to set the error classes that are retried
+func WithRetryOn(kinds ...error) RetryOption {
+	return func(r *RetryClient) {
+		r.retryOn = slices.Clone(kinds)
+	}
+}
+
+// Functional option to get notified before each retry, e.g. for logging
+func WithRetryHook(hook func(RetryEvent)) RetryOption {
+	return func(r *RetryClient) {
+		r.onRetry = hook
+	}
+}
+
+// CreateCompletion requests a completion, retrying retryable errors.
+// Waiting for a retry ends early when the context is done
+func (r *RetryClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	for attempt := 1; ; attempt++ {
+		response, err := r.client.CreateCompletion(ctx, messages, responseSchema, model, modelApiParameters)
+		if err == nil {
+			return response, nil
+		}
+
+		kind := Classify(err)
+		if kind != nil && !errors.Is(err, kind) {
+			err = fmt.Errorf("%w: %w", kind, err)
+		}
+		if !slices.Contains(r.retryOn, kind) || ctx.Err() != nil {
+			return agent.CompletionResponse{}, err
+		}
+		if attempt >= r.maxAttempts {
+			return agent.CompletionResponse{}, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		delay := r.backoff(attempt)
+		if hint, ok := RetryAfter(err); ok {
+			if hint > r.maxRetryAfter {
+				return agent.CompletionResponse{}, fmt.Errorf("retry after %v exceeds the maximum of %v: %w", hint, r.maxRetryAfter, err)
+			}
+			delay = hint
+		}
+
+		if r.onRetry != nil {
+			r.onRetry(RetryEvent{Attempt: attempt, Err: err, Kind: kind, Delay: delay})
+		}
+		if sleepErr := r.sleep(ctx, delay); sleepErr != nil {
+			return agent.CompletionResponse{}, fmt.Errorf("waiting for retry: %w", errors.Join(sleepErr, err))
+		}
+	}
+}
+
+// backoff returns the delay before the retry following the attempt,
+// a random duration between half and the full exponential delay
+func (r *RetryClient) backoff(attempt int) time.Duration {
+	delay := r.baseDelay
+	for range attempt - 1 {
+		delay *= 2
+		if delay >= r.maxDelay {
+			delay = r.maxDelay
+			break
+		}
+	}
+	delay = min(delay, r.maxDelay)
+	return delay/2 + time.Duration(r.random()*float64(delay/2))
+}
+
+// sleep waits for the delay or until the context is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
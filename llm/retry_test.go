@@ -0,0 +1,162 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake LLM client failing with the scripted errors before succeeding
+type ScriptedLLMClient struct {
+	mu       sync.Mutex
+	errs     []error
+	calls    int
+	contexts []context.Context
+}
+
+func (c *ScriptedLLMClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls++
+	c.contexts = append(c.contexts, ctx)
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return agent.CompletionResponse{}, err
+	}
+	return agent.CompletionResponse{Prompt: "ok"}, nil
+}
+
+// newTestRetryClient records the delays instead of sleeping
+func newTestRetryClient(client agent.LLMClient, delays *[]time.Duration, opts ...RetryOption) *RetryClient {
+	r := NewRetryClient(client, opts...)
+	r.random = func() float64 { return 1 }
+	r.sleep = func(ctx context.Context, delay time.Duration) error {
+		*delays = append(*delays, delay)
+		return ctx.Err()
+	}
+	return r
+}
+
+func complete(r *RetryClient) (agent.CompletionResponse, error) {
+	return r.CreateCompletion(context.Background(), nil, reflect.TypeOf(""), "test-model", nil)
+}
+
+func TestRetryClient_RetriesRetryableErrors(t *testing.T) {
+	client := &ScriptedLLMClient{errs: []error{
+		NewAPIError(503, "overloaded", 0),
+		context.DeadlineExceeded,
+		NewAPIError(429, "slow down", 0),
+	}}
+	var delays []time.Duration
+	var events []RetryEvent
+	r := newTestRetryClient(client, &delays, WithRetryHook(func(event RetryEvent) { events = append(events, event) }))
+
+	response, err := complete(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", response.Prompt)
+	assert.Equal(t, 4, client.calls)
+
+	// Exponential backoff starting at 500ms
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}, delays)
+	assert.Equal(t, ErrServerError, events[0].Kind)
+	assert.Equal(t, ErrTimeout, events[1].Kind)
+	assert.Equal(t, 3, events[2].Attempt)
+}
+
+func TestRetryClient_DoesNotRetryPermanentErrors(t *testing.T) {
+	for _, failure := range []error{NewAPIError(400, "bad schema", 0), NewAPIError(401, "bad key", 0), errors.New("unknown")} {
+		client := &ScriptedLLMClient{errs: []error{failure}}
+		var delays []time.Duration
+
+		_, err := complete(newTestRetryClient(client, &delays))
+		assert.ErrorIs(t, err, failure)
+		assert.Equal(t, 1, client.calls)
+		assert.Empty(t, delays)
+	}
+
+	client := &ScriptedLLMClient{errs: []error{NewAPIError(401, "bad key", 0)}}
+	_, err := complete(NewRetryClient(client))
+	assert.ErrorIs(t, err, ErrAuth)
+}
+
+func TestRetryClient_GivesUp(t *testing.T) {
+	client := &ScriptedLLMClient{errs: []error{context.DeadlineExceeded, context.DeadlineExceeded, context.DeadlineExceeded}}
+	var delays []time.Duration
+
+	_, err := complete(newTestRetryClient(client, &delays, WithMaxAttempts(2)))
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "giving up after 2 attempts")
+	assert.Equal(t, 2, client.calls)
+}
+
+func TestRetryClient_RetryAfter(t *testing.T) {
+	client := &ScriptedLLMClient{errs: []error{NewAPIError(429, "slow down", 7*time.Second)}}
+	var delays []time.Duration
+
+	_, err := complete(newTestRetryClient(client, &delays))
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{7 * time.Second}, delays)
+
+	// Hints beyond the maximum are not waited for
+	client = &ScriptedLLMClient{errs: []error{NewAPIError(429, "come back tomorrow", 24*time.Hour)}}
+	_, err = complete(newTestRetryClient(client, &delays))
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.ErrorContains(t, err, "exceeds the maximum")
+	assert.Equal(t, 1, client.calls)
+}
+
+func TestRetryClient_Backoff(t *testing.T) {
+	r := NewRetryClient(&ScriptedLLMClient{}, WithBackoff(time.Second, 5*time.Second))
+
+	r.random = func() float64 { return 0 }
+	assert.Equal(t, 500*time.Millisecond, r.backoff(1))
+	r.random = func() float64 { return 1 }
+	assert.Equal(t, 4*time.Second, r.backoff(3))
+	assert.Equal(t, 5*time.Second, r.backoff(10))
+	assert.Equal(t, 5*time.Second, r.backoff(100))
+}
+
+func TestRetryClient_RetryOn(t *testing.T) {
+	client := &ScriptedLLMClient{errs: []error{NewAPIError(503, "overloaded", 0)}}
+	var delays []time.Duration
+
+	_, err := complete(newTestRetryClient(client, &delays, WithRetryOn(ErrRateLimited)))
+	assert.ErrorIs(t, err, ErrServerError)
+	assert.Equal(t, 1, client.calls)
+}
+
+func TestRetryClient_ContextCancelled(t *testing.T) {
+	client := &ScriptedLLMClient{errs: []error{NewAPIError(503, "overloaded", 0)}}
+	r := NewRetryClient(client, WithBackoff(time.Hour, time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := r.CreateCompletion(ctx, nil, reflect.TypeOf(""), "test-model", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, ErrServerError)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Same(t, ctx, client.contexts[0])
+}
+
+func TestRetryClient_WithAgent(t *testing.T) {
+	client := &ScriptedLLMClient{errs: []error{NewAPIError(500, "oops", 0)}}
+	var delays []time.Duration
+	a, err := agent.NewBaseAgent(agent.WithClient(newTestRetryClient(client, &delays)), agent.WithModel("test-model"))
+	assert.NoError(t, err)
+
+	response, err := a.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", response.Prompt)
+	assert.Equal(t, 2, a.GetMemory().GetMessageCount())
+}
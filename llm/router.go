@@ -0,0 +1,255 @@
+package llm
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/robnmrz/onigiri/prompt"
+)
+
+// Option type for RouterClient
+type RouterOption func(*RouterClient)
+
+// Backend is a client and model a RouterClient can send completions to
+type Backend struct {
+	// Name identifies the backend in rules and in CompletionResponse.Backend
+	Name   string
+	Client agent.LLMClient
+	// Model replaces the requested model, empty keeps it
+	Model string
+	// Weight balances the load between consecutive backends with a weight,
+	// which are tried in a random order proportional to their weights.
+	// Backends without a weight are tried in list order
+	Weight int
+}
+
+// RouteRequest is a completion request as seen by routing rules
+type RouteRequest struct {
+	Messages           []memory.Message
+	Model              string
+	ModelApiParameters map[string]any
+	// EstimatedTokens is the estimated size of all text in the messages
+	EstimatedTokens int
+}
+
+// RouteRule sends matching requests to the named backends, in that order
+type RouteRule struct {
+	Name     string
+	Match    func(RouteRequest) bool
+	Backends []string
+}
+
+// RouterClient is an LLMClient composite trying its backends in order until
+// one succeeds, so that a failing or rate limited backend falls back to the
+// next one. Rules can route requests to a subset of the backends.
+// The backend that served a completion is reported in the response
+type RouterClient struct {
+	backends   []Backend
+	rules      []RouteRule
+	fallbackOn []error
+	estimate   prompt.TokenEstimator
+	random     func() float64
+}
+
+// Constructor for a new RouterClient over the backends in fallback order
+func NewRouterClient(backends []Backend, opts ...RouterOption) (*RouterClient, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("router needs at least one backend")
+	}
+	names := map[string]bool{}
+	for i, backend := range backends {
+		if backend.Name == "" {
+			return nil, fmt.Errorf("backend %d has no name", i)
+		}
+		if names[backend.Name] {
+			return nil, fmt.Errorf("duplicate backend %q", backend.Name)
+		}
+		if backend.Client == nil {
+			return nil, fmt.Errorf("backend %q has no client", backend.Name)
+		}
+		if backend.Weight < 0 {
+			return nil, fmt.Errorf("backend %q has a negative weight", backend.Name)
+		}
+		names[backend.Name] = true
+	}
+
+	r := &RouterClient{
+		backends: slices.Clone(backends),
+		estimate: prompt.EstimateTokens,
+		random:   rand.Float64,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	for _, rule := range r.rules {
+		if rule.Match == nil {
+			return nil, fmt.Errorf("rule %q has no match function", rule.Name)
+		}
+		for _, name := range rule.Backends {
+			if !names[name] {
+				return nil, fmt.Errorf("rule %q routes to unknown backend %q", rule.Name, name)
+			}
+		}
+	}
+	return r, nil
+}
+
+// Functional option to add a routing rule. Rules are checked in the order
+// they were added, the first matching one decides the backends.
+// Requests matching no rule go to all backends
+func WithRule(rule RouteRule) RouterOption {
+	return func(r *RouterClient) {
+		r.rules = append(r.rules, rule)
+	}
+}
+
+// Functional option to set the error classes that fall back to the next
+// backend. By default every error except ErrInvalidRequest falls back,
+// including errors that can't be classified
+func WithFallbackOn(kinds ...error) RouterOption {
+	return func(r *RouterClient) {
+		r.fallbackOn = slices.Clone(kinds)
+	}
+}
+
+// Functional option to set how the size of requests is estimated for rules,
+// defaults to prompt.EstimateTokens
+func WithTokenEstimator(estimator prompt.TokenEstimator) RouterOption {
+	return func(r *RouterClient) {
+		r.estimate = estimator
+	}
+}
+
+// MinTokens matches requests estimated at least at the given size
+func MinTokens(tokens int) func(RouteRequest) bool {
+	return func(request RouteRequest) bool {
+		return request.EstimatedTokens >= tokens
+	}
+}
+
+// MaxTokens matches requests estimated at most at the given size
+func MaxTokens(tokens int) func(RouteRequest) bool {
+	return func(request RouteRequest) bool {
+		return request.EstimatedTokens <= tokens
+	}
+}
+
+// ParameterEquals matches requests with the model API parameter set to the value
+func ParameterEquals(key string, value any) func(RouteRequest) bool {
+	return func(request RouteRequest) bool {
+		actual, ok := request.ModelApiParameters[key]
+		return ok && reflect.DeepEqual(actual, value)
+	}
+}
+
+// CreateCompletion sends the request to the routed backends until one succeeds.
+// If all fail, the returned error joins the errors of all backends
+func (r *RouterClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	backends := r.route(RouteRequest{
+		Messages:           messages,
+		Model:              model,
+		ModelApiParameters: modelApiParameters,
+		EstimatedTokens:    r.estimate(messagesText(messages)),
+	})
+
+	var errs []error
+	for _, backend := range backends {
+		backendModel := model
+		if backend.Model != "" {
+			backendModel = backend.Model
+		}
+
+		response, err := backend.Client.CreateCompletion(ctx, messages, responseSchema, backendModel, modelApiParameters)
+		if err == nil {
+			response.Backend = backend.Name
+			return response, nil
+		}
+		errs = append(errs, fmt.Errorf("backend %s: %w", backend.Name, err))
+		if !r.fallsBack(err) || ctx.Err() != nil {
+			break
+		}
+	}
+	return agent.CompletionResponse{}, fmt.Errorf("%d of %d backends failed: %w", len(errs), len(backends), errors.Join(errs...))
+}
+
+// fallsBack reports whether the error is tried on the next backend
+func (r *RouterClient) fallsBack(err error) bool {
+	kind := Classify(err)
+	if r.fallbackOn == nil {
+		return kind != ErrInvalidRequest
+	}
+	return slices.Contains(r.fallbackOn, kind)
+}
+
+// route returns the backends for the request in the order they are tried
+func (r *RouterClient) route(request RouteRequest) []Backend {
+	backends := r.backends
+	for _, rule := range r.rules {
+		if rule.Match(request) {
+			backends = make([]Backend, len(rule.Backends))
+			for i, name := range rule.Backends {
+				backends[i] = r.backends[slices.IndexFunc(r.backends, func(b Backend) bool { return b.Name == name })]
+			}
+			break
+		}
+	}
+
+	// Shuffle each run of weighted backends proportional to their weights
+	ordered := make([]Backend, 0, len(backends))
+	for start := 0; start < len(backends); {
+		end := start + 1
+		if backends[start].Weight > 0 {
+			for end < len(backends) && backends[end].Weight > 0 {
+				end++
+			}
+		}
+		ordered = append(ordered, r.weightedOrder(backends[start:end])...)
+		start = end
+	}
+	return ordered
+}
+
+// weightedOrder returns the backends in a random order where each backend
+// comes first with a probability proportional to its weight
+func (r *RouterClient) weightedOrder(backends []Backend) []Backend {
+	if len(backends) < 2 {
+		return slices.Clone(backends)
+	}
+	// Weighted sampling without replacement, sorting by u^(1/weight)
+	type keyed struct {
+		backend Backend
+		key     float64
+	}
+	keyedBackends := make([]keyed, len(backends))
+	for i, backend := range backends {
+		keyedBackends[i] = keyed{backend: backend, key: math.Pow(r.random(), 1/float64(backend.Weight))}
+	}
+	slices.SortStableFunc(keyedBackends, func(a, b keyed) int { return cmp.Compare(b.key, a.key) })
+
+	ordered := make([]Backend, len(backends))
+	for i, entry := range keyedBackends {
+		ordered[i] = entry.backend
+	}
+	return ordered
+}
+
+// messagesText joins the text of all messages for estimating their size
+func messagesText(messages []memory.Message) string {
+	var text []byte
+	for _, msg := range messages {
+		for _, part := range msg.Content.Parts() {
+			text = append(text, part.Text...)
+			text = append(text, '\n')
+		}
+	}
+	return string(text)
+}
@@ -0,0 +1,171 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake backend answering with its name or failing with a fixed error
+type FakeBackendClient struct {
+	mu     sync.Mutex
+	name   string
+	err    error
+	models []string
+}
+
+func (c *FakeBackendClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.models = append(c.models, model)
+	if c.err != nil {
+		return agent.CompletionResponse{}, c.err
+	}
+	return agent.CompletionResponse{Prompt: "from " + c.name}, nil
+}
+
+func (c *FakeBackendClient) calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.models)
+}
+
+func userMessages(text string) []memory.Message {
+	return []memory.Message{{Role: "user", Content: memory.MessageContent{TypeName: "string", Content: text}}}
+}
+
+func TestNewRouterClient_Invalid(t *testing.T) {
+	client := &FakeBackendClient{}
+
+	_, err := NewRouterClient(nil)
+	assert.Error(t, err)
+	_, err = NewRouterClient([]Backend{{Name: "a", Client: client}, {Name: "a", Client: client}})
+	assert.ErrorContains(t, err, "duplicate backend")
+	_, err = NewRouterClient([]Backend{{Name: "a"}})
+	assert.ErrorContains(t, err, "has no client")
+	_, err = NewRouterClient([]Backend{{Name: "a", Client: client}}, WithRule(RouteRule{Name: "big", Match: MinTokens(1), Backends: []string{"b"}}))
+	assert.ErrorContains(t, err, `unknown backend "b"`)
+}
+
+func TestRouterClient_FallsBack(t *testing.T) {
+	primary := &FakeBackendClient{name: "primary", err: NewAPIError(429, "slow down", 0)}
+	secondary := &FakeBackendClient{name: "secondary", err: errors.New("connection refused")}
+	tertiary := &FakeBackendClient{name: "tertiary"}
+	router, err := NewRouterClient([]Backend{
+		{Name: "primary", Client: primary},
+		{Name: "secondary", Client: secondary, Model: "other-model"},
+		{Name: "tertiary", Client: tertiary},
+	})
+	assert.NoError(t, err)
+
+	response, err := router.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "main-model", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "from tertiary", response.Prompt)
+	assert.Equal(t, "tertiary", response.Backend)
+
+	// Backends can replace the requested model
+	assert.Equal(t, []string{"main-model"}, primary.models)
+	assert.Equal(t, []string{"other-model"}, secondary.models)
+	assert.Equal(t, []string{"main-model"}, tertiary.models)
+}
+
+func TestRouterClient_AllFail(t *testing.T) {
+	primary := &FakeBackendClient{name: "primary", err: NewAPIError(503, "down", 0)}
+	secondary := &FakeBackendClient{name: "secondary", err: NewAPIError(429, "busy", 0)}
+	router, err := NewRouterClient([]Backend{{Name: "primary", Client: primary}, {Name: "secondary", Client: secondary}})
+	assert.NoError(t, err)
+
+	_, err = router.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "main-model", nil)
+	assert.ErrorIs(t, err, ErrServerError)
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.ErrorContains(t, err, "2 of 2 backends failed")
+	assert.ErrorContains(t, err, "backend primary: api error 503: down")
+}
+
+func TestRouterClient_InvalidRequestDoesNotFallBack(t *testing.T) {
+	primary := &FakeBackendClient{name: "primary", err: NewAPIError(400, "bad schema", 0)}
+	secondary := &FakeBackendClient{name: "secondary"}
+	router, err := NewRouterClient([]Backend{{Name: "primary", Client: primary}, {Name: "secondary", Client: secondary}})
+	assert.NoError(t, err)
+
+	_, err = router.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "main-model", nil)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	assert.Equal(t, 0, secondary.calls())
+
+	// The classes that fall back can be configured
+	router, err = NewRouterClient([]Backend{{Name: "primary", Client: primary}, {Name: "secondary", Client: secondary}},
+		WithFallbackOn(ErrInvalidRequest))
+	assert.NoError(t, err)
+	response, err := router.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "main-model", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "secondary", response.Backend)
+}
+
+func TestRouterClient_Rules(t *testing.T) {
+	small := &FakeBackendClient{name: "small"}
+	large := &FakeBackendClient{name: "large"}
+	precise := &FakeBackendClient{name: "precise"}
+	router, err := NewRouterClient(
+		[]Backend{{Name: "small", Client: small}, {Name: "large", Client: large}, {Name: "precise", Client: precise}},
+		WithRule(RouteRule{Name: "deterministic", Match: ParameterEquals("temperature", 0.0), Backends: []string{"precise"}}),
+		WithRule(RouteRule{Name: "long prompts", Match: MinTokens(100), Backends: []string{"large", "small"}}),
+	)
+	assert.NoError(t, err)
+
+	complete := func(text string, parameters map[string]any) string {
+		response, err := router.CreateCompletion(context.Background(), userMessages(text), reflect.TypeOf(""), "model", parameters)
+		assert.NoError(t, err)
+		return response.Backend
+	}
+	assert.Equal(t, "small", complete("Hi", nil))
+	assert.Equal(t, "large", complete(strings.Repeat("long ", 100), nil))
+	assert.Equal(t, "precise", complete(strings.Repeat("long ", 100), map[string]any{"temperature": 0.0}))
+	assert.Equal(t, "small", complete("Hi", map[string]any{"temperature": 0.7}))
+}
+
+func TestRouterClient_WeightedLoadBalancing(t *testing.T) {
+	heavy := &FakeBackendClient{name: "heavy"}
+	light := &FakeBackendClient{name: "light"}
+	fallback := &FakeBackendClient{name: "fallback"}
+	router, err := NewRouterClient([]Backend{
+		{Name: "heavy", Client: heavy, Weight: 3},
+		{Name: "light", Client: light, Weight: 1},
+		{Name: "fallback", Client: fallback},
+	})
+	assert.NoError(t, err)
+
+	for range 2000 {
+		_, err := router.CreateCompletion(context.Background(), userMessages("Hi"), reflect.TypeOf(""), "model", nil)
+		assert.NoError(t, err)
+	}
+	assert.InDelta(t, 1500, heavy.calls(), 150)
+	assert.InDelta(t, 500, light.calls(), 150)
+	assert.Equal(t, 0, fallback.calls())
+
+	// The unweighted backend stays last
+	order := router.route(RouteRequest{})
+	assert.Equal(t, "fallback", order[2].Name)
+}
+
+func TestRouterClient_WithAgent(t *testing.T) {
+	router, err := NewRouterClient([]Backend{
+		{Name: "primary", Client: &FakeBackendClient{name: "primary", err: NewAPIError(500, "oops", 0)}},
+		{Name: "secondary", Client: &FakeBackendClient{name: "secondary"}},
+	})
+	assert.NoError(t, err)
+	a, err := agent.NewBaseAgent(agent.WithClient(router), agent.WithModel("model"))
+	assert.NoError(t, err)
+
+	response, err := a.Run(context.Background(), "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "secondary", response.Backend)
+}
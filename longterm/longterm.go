@@ -0,0 +1,318 @@
+package longterm
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/robnmrz/onigiri/prompt"
+	"github.com/robnmrz/onigiri/rag"
+)
+
+// Option type for LongTermMemory
+type Option func(*LongTermMemory)
+
+// ChangeKind describes what remembering did with an extracted fact
+type ChangeKind int
+
+const (
+	// FactAdded means the fact was new and has been stored
+	FactAdded ChangeKind = iota
+	// FactUpdated means the fact replaced a contradicting or outdated one
+	FactUpdated
+	// FactDuplicate means an equivalent fact was already stored
+	FactDuplicate
+)
+
+// FactChange is the outcome of remembering one extracted fact.
+// Previous holds the replaced fact of updates and the existing fact of duplicates
+type FactChange struct {
+	Kind     ChangeKind
+	Fact     Fact
+	Previous *Fact
+}
+
+// ScoredFact is a recalled fact with its similarity to the query
+type ScoredFact struct {
+	Fact  Fact
+	Score float64
+}
+
+// extraction is the response schema of the fact extraction
+type extraction struct {
+	Facts []extractedFact `json:"facts"`
+}
+
+// extractedFact is a fact extracted by the model. Replaces holds the
+// id of a known fact the new one contradicts or makes outdated
+type extractedFact struct {
+	Text     string `json:"text"`
+	Replaces string `json:"replaces,omitempty"`
+}
+
+// LongTermMemory extracts facts about users from their conversations with
+// a model, stores them with embeddings and recalls the relevant ones later.
+// It is safe for concurrent use, concurrent updates of one user are serialized
+type LongTermMemory struct {
+	client             agent.LLMClient
+	model              string
+	modelApiParameters map[string]any
+	embedder           rag.Embedder
+	store              FactStore
+	duplicateThreshold float64
+	now                func() time.Time
+
+	// userLocks holds a *sync.Mutex per user id
+	userLocks sync.Map
+}
+
+// Constructor for a new LongTermMemory extracting facts with the model of the client
+func NewLongTermMemory(client agent.LLMClient, model string, embedder rag.Embedder, store FactStore, opts ...Option) *LongTermMemory {
+	ltm := &LongTermMemory{
+		client:             client,
+		model:              model,
+		modelApiParameters: map[string]any{},
+		embedder:           embedder,
+		store:              store,
+		duplicateThreshold: 0.92,
+		now:                time.Now,
+	}
+	for _, opt := range opts {
+		opt(ltm)
+	}
+	return ltm
+}
+
+// Functional option to set the similarity from which an extracted fact
+// counts as a duplicate of a stored one, defaults to 0.92
+func WithDuplicateThreshold(threshold float64) Option {
+	return func(ltm *LongTermMemory) {
+		ltm.duplicateThreshold = threshold
+	}
+}
+
+// Functional option to add a model API parameter to the extraction requests
+func WithModelParameter(key string, value any) Option {
+	return func(ltm *LongTermMemory) {
+		ltm.modelApiParameters[key] = value
+	}
+}
+
+// lock serializes the updates of a user's facts
+func (ltm *LongTermMemory) lock(userId string) func() {
+	mu, _ := ltm.userLocks.LoadOrStore(userId, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// RememberTurn extracts facts from the messages of a completed turn in the memory
+func (ltm *LongTermMemory) RememberTurn(ctx context.Context, userId string, mem *memory.AgentMemory, turnId string) ([]FactChange, error) {
+	messages := []memory.Message{}
+	for _, msg := range mem.GetHistory() {
+		if msg.TurnId == turnId {
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("turn %s not found in memory", turnId)
+	}
+	return ltm.Remember(ctx, userId, messages)
+}
+
+// Remember extracts the facts worth remembering from the messages and stores
+// them for the user. The model sees the known facts, so it can replace the ones
+// the conversation contradicts. Facts very similar to a stored one are skipped
+func (ltm *LongTermMemory) Remember(ctx context.Context, userId string, messages []memory.Message) ([]FactChange, error) {
+	unlock := ltm.lock(userId)
+	defer unlock()
+
+	facts, err := ltm.store.Load(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load facts: %w", err)
+	}
+
+	extracted, err := ltm.extract(ctx, facts, messages)
+	if err != nil {
+		return nil, err
+	}
+	if len(extracted) == 0 {
+		return []FactChange{}, nil
+	}
+
+	texts := make([]string, len(extracted))
+	for i, fact := range extracted {
+		texts[i] = fact.Text
+	}
+	embeddings, err := ltm.embed(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	turnId := messages[len(messages)-1].TurnId
+	now := ltm.now()
+	changes := []FactChange{}
+	for i, candidate := range extracted {
+		// Replace the fact the model marked as outdated
+		if index := slices.IndexFunc(facts, func(f Fact) bool { return f.Id == candidate.Replaces }); candidate.Replaces != "" && index != -1 {
+			previous := facts[index]
+			facts[index].Text = candidate.Text
+			facts[index].Embedding = embeddings[i]
+			facts[index].TurnId = turnId
+			facts[index].UpdatedAt = now
+			changes = append(changes, FactChange{Kind: FactUpdated, Fact: facts[index], Previous: &previous})
+			continue
+		}
+
+		if index, score := mostSimilar(facts, embeddings[i]); index != -1 && score >= ltm.duplicateThreshold {
+			existing := facts[index]
+			changes = append(changes, FactChange{Kind: FactDuplicate, Fact: existing, Previous: &existing})
+			continue
+		}
+
+		fact := Fact{
+			Id:        uuid.New().String(),
+			Text:      candidate.Text,
+			Embedding: embeddings[i],
+			TurnId:    turnId,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		facts = append(facts, fact)
+		changes = append(changes, FactChange{Kind: FactAdded, Fact: fact})
+	}
+
+	if err := ltm.store.Save(ctx, userId, facts); err != nil {
+		return nil, fmt.Errorf("failed to save facts: %w", err)
+	}
+	return changes, nil
+}
+
+// extract asks the model for the facts in the messages
+func (ltm *LongTermMemory) extract(ctx context.Context, known []Fact, messages []memory.Message) ([]extractedFact, error) {
+	knownLines := []string{}
+	for _, fact := range known {
+		knownLines = append(knownLines, fmt.Sprintf("[%s] %s", fact.Id, fact.Text))
+	}
+	spg := prompt.NewSystemPromptGenerator(
+		prompt.WithBackground([]string{
+			"You maintain the long-term memory of an assistant about its user.",
+		}),
+		prompt.WithSteps([]string{
+			"Read the conversation and find lasting facts about the user, such as preferences, personal details, goals and decisions.",
+			"Ignore small talk, one-off requests and anything only relevant to the current conversation.",
+			"Compare each fact with the known facts. Leave out facts that are already known.",
+			"If a fact contradicts or updates a known fact, set replaces to the id of the known fact.",
+		}),
+		prompt.WithOutputInstructions([]string{
+			`Answer with json like {"facts": [{"text": "The user lives in Berlin", "replaces": "<id or empty>"}]}.`,
+			"Write every fact as a short, self-contained sentence about the user.",
+			`Answer with {"facts": []} if there is nothing worth remembering.`,
+		}),
+		prompt.WithSection(prompt.PromptSection{Name: "known_facts", Title: "KNOWN FACTS", Content: knownLines}),
+	)
+	systemPrompt, err := spg.GeneratePrompt(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate extraction prompt: %w", err)
+	}
+
+	request := append([]memory.Message{{
+		Role:    "system",
+		Content: memory.MessageContent{TypeName: "string", Content: systemPrompt},
+	}}, messages...)
+	response, err := ltm.client.CreateCompletion(ctx, request, reflect.TypeOf(extraction{}), ltm.model, ltm.modelApiParameters)
+	if err != nil {
+		return nil, fmt.Errorf("fact extraction failed: %w", err)
+	}
+
+	var result extraction
+	if err := json.Unmarshal([]byte(response.Prompt), &result); err != nil {
+		return nil, fmt.Errorf("failed to decode extracted facts: %w", err)
+	}
+	facts := []extractedFact{}
+	for _, fact := range result.Facts {
+		if fact.Text = strings.TrimSpace(fact.Text); fact.Text != "" {
+			facts = append(facts, fact)
+		}
+	}
+	return facts, nil
+}
+
+// Recall returns the k stored facts of the user most similar to the query
+func (ltm *LongTermMemory) Recall(ctx context.Context, userId string, query string, k int) ([]ScoredFact, error) {
+	facts, err := ltm.store.Load(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load facts: %w", err)
+	}
+	if len(facts) == 0 || k <= 0 {
+		return []ScoredFact{}, nil
+	}
+
+	embeddings, err := ltm.embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	scored := make([]ScoredFact, len(facts))
+	for i, fact := range facts {
+		scored[i] = ScoredFact{Fact: fact, Score: rag.CosineSimilarity(embeddings[0], fact.Embedding)}
+	}
+	slices.SortStableFunc(scored, func(a, b ScoredFact) int { return cmp.Compare(b.Score, a.Score) })
+	return scored[:min(k, len(scored))], nil
+}
+
+// Facts returns all stored facts of the user
+func (ltm *LongTermMemory) Facts(ctx context.Context, userId string) ([]Fact, error) {
+	return ltm.store.Load(ctx, userId)
+}
+
+// Forget removes a fact of the user
+func (ltm *LongTermMemory) Forget(ctx context.Context, userId string, factId string) error {
+	unlock := ltm.lock(userId)
+	defer unlock()
+
+	facts, err := ltm.store.Load(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("failed to load facts: %w", err)
+	}
+	index := slices.IndexFunc(facts, func(f Fact) bool { return f.Id == factId })
+	if index == -1 {
+		return fmt.Errorf("fact %s not found", factId)
+	}
+	return ltm.store.Save(ctx, userId, slices.Delete(facts, index, index+1))
+}
+
+// embed embeds the texts, checking that there is a vector for each
+func (ltm *LongTermMemory) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if ltm.embedder == nil {
+		return nil, errors.New("long-term memory has no embedder")
+	}
+	embeddings, err := ltm.embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed facts: %w", err)
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedder returned %d vectors for %d texts", len(embeddings), len(texts))
+	}
+	return embeddings, nil
+}
+
+// mostSimilar returns the index of the fact most similar to the embedding, -1 if there are no facts
+func mostSimilar(facts []Fact, embedding []float32) (int, float64) {
+	best, bestScore := -1, 0.0
+	for i, fact := range facts {
+		if score := rag.CosineSimilarity(embedding, fact.Embedding); best == -1 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best, bestScore
+}
@@ -0,0 +1,181 @@
+package longterm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake LLM client answering with queued responses and recording the requests
+type FakeLLMClient struct {
+	mu        sync.Mutex
+	responses []string
+	requests  [][]memory.Message
+	err       error
+}
+
+func (c *FakeLLMClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests = append(c.requests, messages)
+	if c.err != nil {
+		return agent.CompletionResponse{}, c.err
+	}
+	response := c.responses[0]
+	c.responses = c.responses[1:]
+	return agent.CompletionResponse{Prompt: response}, nil
+}
+
+// Fake embedder counting the words of a small vocabulary
+type FakeEmbedder struct{}
+
+var vocabulary = []string{"berlin", "munich", "tea", "coffee", "german", "lives", "likes"}
+
+func (e FakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(vocabulary))
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			for j, known := range vocabulary {
+				if strings.Trim(word, ".,?!") == known {
+					vectors[i][j]++
+				}
+			}
+		}
+	}
+	return vectors, nil
+}
+
+func newTestMemory(client *FakeLLMClient) *LongTermMemory {
+	ltm := NewLongTermMemory(client, "test-model", FakeEmbedder{}, NewInMemoryFactStore())
+	ltm.now = func() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+	return ltm
+}
+
+func conversation(turnId string, texts ...string) []memory.Message {
+	messages := []memory.Message{}
+	for i, text := range texts {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		messages = append(messages, memory.Message{Role: role, Content: memory.MessageContent{TypeName: "string", Content: text}, TurnId: turnId})
+	}
+	return messages
+}
+
+func TestRemember_AddsFacts(t *testing.T) {
+	client := &FakeLLMClient{responses: []string{`{"facts": [{"text": "The user lives in Munich."}, {"text": "The user likes tea."}, {"text": " "}]}`}}
+	ltm := newTestMemory(client)
+
+	changes, err := ltm.Remember(context.Background(), "jane", conversation("turn-1", "I live in Munich and love tea", "Nice!"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, FactAdded, changes[0].Kind)
+	assert.Equal(t, "The user lives in Munich.", changes[0].Fact.Text)
+	assert.Equal(t, "turn-1", changes[0].Fact.TurnId)
+
+	facts, err := ltm.Facts(context.Background(), "jane")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(facts))
+	assert.NotEmpty(t, facts[0].Embedding)
+
+	// The conversation follows the extraction prompt
+	request := client.requests[0]
+	assert.Equal(t, "system", request[0].Role)
+	assert.Contains(t, request[0].Content.Content, "long-term memory")
+	assert.Equal(t, "I live in Munich and love tea", request[1].Content.Content)
+
+	// Facts are kept per user
+	facts, err = ltm.Facts(context.Background(), "john")
+	assert.NoError(t, err)
+	assert.Empty(t, facts)
+}
+
+func TestRemember_UpdatesAndDeduplicates(t *testing.T) {
+	client := &FakeLLMClient{responses: []string{`{"facts": [{"text": "The user lives in Munich."}]}`}}
+	ltm := newTestMemory(client)
+	changes, err := ltm.Remember(context.Background(), "jane", conversation("turn-1", "I live in Munich"))
+	assert.NoError(t, err)
+	munich := changes[0].Fact
+
+	// The model replaces the outdated fact, a rephrased known fact is a duplicate
+	client.responses = []string{`{"facts": [{"text": "The user lives in Berlin.", "replaces": "` + munich.Id + `"}, {"text": "User lives in Berlin"}]}`}
+	changes, err = ltm.Remember(context.Background(), "jane", conversation("turn-2", "I moved to Berlin"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, FactUpdated, changes[0].Kind)
+	assert.Equal(t, munich.Id, changes[0].Fact.Id)
+	assert.Equal(t, "The user lives in Munich.", changes[0].Previous.Text)
+	assert.Equal(t, FactDuplicate, changes[1].Kind)
+
+	facts, err := ltm.Facts(context.Background(), "jane")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(facts))
+	assert.Equal(t, "The user lives in Berlin.", facts[0].Text)
+	assert.Equal(t, "turn-2", facts[0].TurnId)
+
+	// The known facts were shown to the model with their ids
+	assert.Contains(t, client.requests[1][0].Content.Content, "# KNOWN FACTS\n- ["+munich.Id+"] The user lives in Munich.")
+}
+
+func TestRemember_Errors(t *testing.T) {
+	ltm := newTestMemory(&FakeLLMClient{err: errors.New("rate limited")})
+	_, err := ltm.Remember(context.Background(), "jane", conversation("turn-1", "Hi"))
+	assert.ErrorContains(t, err, "rate limited")
+
+	ltm = newTestMemory(&FakeLLMClient{responses: []string{"not json"}})
+	_, err = ltm.Remember(context.Background(), "jane", conversation("turn-1", "Hi"))
+	assert.ErrorContains(t, err, "failed to decode extracted facts")
+}
+
+func TestRememberTurn(t *testing.T) {
+	client := &FakeLLMClient{responses: []string{`{"facts": [{"text": "The user likes coffee."}]}`}}
+	ltm := newTestMemory(client)
+
+	mem := memory.NewAgentMemory()
+	mem.InitializeTurn()
+	mem.AddMessage("user", "Hello")
+	mem.InitializeTurn()
+	turnId := mem.GetTurnId()
+	mem.AddMessage("user", "I like coffee")
+	mem.AddMessage("assistant", "Noted")
+
+	changes, err := ltm.RememberTurn(context.Background(), "jane", mem, turnId)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(changes))
+
+	// Only the messages of the turn are sent after the system prompt
+	assert.Equal(t, 3, len(client.requests[0]))
+
+	_, err = ltm.RememberTurn(context.Background(), "jane", mem, "missing")
+	assert.Error(t, err)
+}
+
+func TestRecallAndForget(t *testing.T) {
+	client := &FakeLLMClient{responses: []string{`{"facts": [{"text": "The user likes tea."}, {"text": "The user lives in Berlin."}]}`}}
+	ltm := newTestMemory(client)
+	_, err := ltm.Remember(context.Background(), "jane", conversation("turn-1", "..."))
+	assert.NoError(t, err)
+
+	recalled, err := ltm.Recall(context.Background(), "jane", "Where in Berlin should I go?", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(recalled))
+	assert.Equal(t, "The user lives in Berlin.", recalled[0].Fact.Text)
+	assert.Greater(t, recalled[0].Score, 0.0)
+
+	assert.NoError(t, ltm.Forget(context.Background(), "jane", recalled[0].Fact.Id))
+	facts, err := ltm.Facts(context.Background(), "jane")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(facts))
+	assert.Error(t, ltm.Forget(context.Background(), "jane", "missing"))
+}
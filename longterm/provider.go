@@ -0,0 +1,81 @@
+package longterm
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/robnmrz/onigiri/prompt"
+	"github.com/robnmrz/onigiri/rag"
+)
+
+// Option type for the long-term memory context provider
+type ProviderOption func(*Provider)
+
+// Provider is a context provider injecting the user's facts most relevant to
+// the user input the prompt is generated for, see prompt.ContextWithUserInput
+type Provider struct {
+	title    string
+	memory   *LongTermMemory
+	userId   string
+	topK     int
+	minScore float64
+}
+
+// Constructor for a new Provider recalling the top 5 facts of the user by default
+func NewProvider(title string, ltm *LongTermMemory, userId string, opts ...ProviderOption) *Provider {
+	p := &Provider{title: title, memory: ltm, userId: userId, topK: 5}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Functional option to set the number of recalled facts, at least 1
+func WithTopK(k int) ProviderOption {
+	return func(p *Provider) {
+		p.topK = max(k, 1)
+	}
+}
+
+// Functional option to leave out facts less similar to the input than minScore
+func WithMinScore(minScore float64) ProviderOption {
+	return func(p *Provider) {
+		p.minScore = minScore
+	}
+}
+
+// GetInfo recalls the facts relevant to the user input, one per line.
+// Without user input the most recently updated facts are used
+func (p *Provider) GetInfo(ctx context.Context) (string, error) {
+	var facts []Fact
+	if input, ok := prompt.UserInputFromContext(ctx); ok {
+		scored, err := p.memory.Recall(ctx, p.userId, rag.DefaultQuery(input), p.topK)
+		if err != nil {
+			return "", err
+		}
+		for _, fact := range scored {
+			if fact.Score >= p.minScore {
+				facts = append(facts, fact.Fact)
+			}
+		}
+	} else {
+		stored, err := p.memory.Facts(ctx, p.userId)
+		if err != nil {
+			return "", err
+		}
+		slices.SortStableFunc(stored, func(a, b Fact) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+		facts = stored[:min(p.topK, len(stored))]
+	}
+
+	lines := make([]string, len(facts))
+	for i, fact := range facts {
+		lines[i] = fact.Text
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// GetTitle returns the title of the provider
+func (p *Provider) GetTitle() string {
+	return p.title
+}
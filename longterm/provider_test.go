@@ -0,0 +1,89 @@
+package longterm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robnmrz/onigiri/prompt"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRememberingMemory(t *testing.T) *LongTermMemory {
+	t.Helper()
+	store := NewInMemoryFactStore()
+	facts := []Fact{
+		{Id: "1", Text: "The user likes tea.", UpdatedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Id: "2", Text: "The user lives in Berlin.", UpdatedAt: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{Id: "3", Text: "The user speaks German.", UpdatedAt: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for i := range facts {
+		embeddings, err := FakeEmbedder{}.Embed(context.Background(), []string{facts[i].Text})
+		assert.NoError(t, err)
+		facts[i].Embedding = embeddings[0]
+	}
+	assert.NoError(t, store.Save(context.Background(), "jane", facts))
+	return NewLongTermMemory(&FakeLLMClient{}, "test-model", FakeEmbedder{}, store)
+}
+
+func TestProvider_RelevantFacts(t *testing.T) {
+	p := NewProvider("What you know about the user", newRememberingMemory(t), "jane", WithTopK(2), WithMinScore(0.1))
+
+	info, err := p.GetInfo(prompt.ContextWithUserInput(context.Background(), "Any tea shops in Berlin?"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"The user likes tea.", "The user lives in Berlin."}, splitLines(info))
+	assert.Equal(t, "What you know about the user", p.GetTitle())
+
+	// Facts below the minimum score are left out
+	info, err = p.GetInfo(prompt.ContextWithUserInput(context.Background(), "Recommend some tea"))
+	assert.NoError(t, err)
+	assert.Equal(t, "The user likes tea.", info)
+}
+
+func TestProvider_WithoutInput(t *testing.T) {
+	p := NewProvider("Facts", newRememberingMemory(t), "jane", WithTopK(2))
+
+	// The most recently updated facts are used
+	info, err := p.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "The user lives in Berlin.\nThe user speaks German.", info)
+
+	info, err = NewProvider("Facts", newRememberingMemory(t), "john").GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, info)
+}
+
+func TestProvider_TopKAtLeastOne(t *testing.T) {
+	for _, k := range []int{0, -1} {
+		p := NewProvider("Facts", newRememberingMemory(t), "jane", WithTopK(k))
+
+		info, err := p.GetInfo(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "The user lives in Berlin.", info)
+
+		info, err = p.GetInfo(prompt.ContextWithUserInput(context.Background(), "Recommend some tea"))
+		assert.NoError(t, err)
+		assert.Equal(t, "The user likes tea.", info)
+	}
+}
+
+func TestProvider_InPrompt(t *testing.T) {
+	spg := prompt.NewSystemPromptGenerator(
+		prompt.WithFallibleContextProvider("facts", NewProvider("USER FACTS", newRememberingMemory(t), "jane", WithTopK(1))),
+	)
+
+	generated, err := spg.GeneratePrompt(prompt.ContextWithUserInput(context.Background(), "Do you speak German?"))
+	assert.NoError(t, err)
+	assert.Contains(t, generated, "# USER FACTS\n- The user speaks German.")
+}
+
+func splitLines(text string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
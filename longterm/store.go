@@ -0,0 +1,135 @@
+package longterm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robnmrz/onigiri/utils"
+)
+
+// Fact is a piece of information about a user that is worth remembering
+// across sessions, e.g. "The user prefers answers in German"
+type Fact struct {
+	Id        string    `json:"id"`
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+	// TurnId is the turn the fact was last extracted from
+	TurnId    string    `json:"turn_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FactStore persists the facts of each user.
+// Load returns an empty list for users without facts
+type FactStore interface {
+	Load(ctx context.Context, userId string) ([]Fact, error)
+	Save(ctx context.Context, userId string, facts []Fact) error
+}
+
+// InMemoryFactStore keeps the facts in memory, mostly useful for tests
+type InMemoryFactStore struct {
+	mu    sync.RWMutex
+	facts map[string][]Fact
+}
+
+// Constructor for a new, empty InMemoryFactStore
+func NewInMemoryFactStore() *InMemoryFactStore {
+	return &InMemoryFactStore{facts: map[string][]Fact{}}
+}
+
+// Load returns a copy of the user's facts
+func (s *InMemoryFactStore) Load(ctx context.Context, userId string) ([]Fact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return cloneFacts(s.facts[userId]), nil
+}
+
+// Save replaces the user's facts with a copy of the given ones
+func (s *InMemoryFactStore) Save(ctx context.Context, userId string, facts []Fact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.facts[userId] = cloneFacts(facts)
+	return nil
+}
+
+// cloneFacts deep copies facts, so that stored embeddings can't be changed from outside
+func cloneFacts(facts []Fact) []Fact {
+	cloned := make([]Fact, len(facts))
+	for i, fact := range facts {
+		cloned[i] = fact
+		cloned[i].Embedding = slices.Clone(fact.Embedding)
+	}
+	return cloned
+}
+
+// FileFactStore keeps the facts of each user in a json file in a directory
+type FileFactStore struct {
+	dir string
+}
+
+// Constructor for a new FileFactStore, creating the directory if needed
+func NewFileFactStore(dir string) (*FileFactStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create fact directory: %w", err)
+	}
+	return &FileFactStore{dir: dir}, nil
+}
+
+// path returns the file path for a user, rejecting ids
+// that would escape the store's directory
+func (s *FileFactStore) path(userId string) (string, error) {
+	if userId == "" || userId == "." || userId == ".." || strings.ContainsAny(userId, `/\`) {
+		return "", fmt.Errorf("invalid user id %q", userId)
+	}
+	return filepath.Join(s.dir, userId+".json"), nil
+}
+
+// Load reads the fact file of the user
+func (s *FileFactStore) Load(ctx context.Context, userId string) ([]Fact, error) {
+	path, err := s.path(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Fact{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read facts of user %s: %w", userId, err)
+	}
+
+	facts := []Fact{}
+	if err := json.Unmarshal(data, &facts); err != nil {
+		return nil, fmt.Errorf("failed to decode facts of user %s: %w", userId, err)
+	}
+	return facts, nil
+}
+
+// Save writes the fact file of the user, replacing it atomically
+func (s *FileFactStore) Save(ctx context.Context, userId string, facts []Fact) error {
+	path, err := s.path(userId)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(facts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode facts of user %s: %w", userId, err)
+	}
+
+	if err := utils.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to save facts of user %s: %w", userId, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package longterm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryFactStore(t *testing.T) {
+	store := NewInMemoryFactStore()
+
+	facts, err := store.Load(context.Background(), "jane")
+	assert.NoError(t, err)
+	assert.Empty(t, facts)
+
+	stored := []Fact{{Id: "1", Text: "Likes tea", Embedding: []float32{1, 0}}}
+	assert.NoError(t, store.Save(context.Background(), "jane", stored))
+
+	// Stored facts are copies
+	stored[0].Embedding[0] = 5
+	facts, err = store.Load(context.Background(), "jane")
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{1, 0}, facts[0].Embedding)
+
+	facts, err = store.Load(context.Background(), "john")
+	assert.NoError(t, err)
+	assert.Empty(t, facts)
+}
+
+func TestFileFactStore(t *testing.T) {
+	store, err := NewFileFactStore(t.TempDir())
+	assert.NoError(t, err)
+
+	facts, err := store.Load(context.Background(), "jane")
+	assert.NoError(t, err)
+	assert.Empty(t, facts)
+
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	stored := []Fact{{Id: "1", Text: "Likes tea", Embedding: []float32{1, 0}, TurnId: "t", CreatedAt: now, UpdatedAt: now}}
+	assert.NoError(t, store.Save(context.Background(), "jane", stored))
+
+	facts, err = store.Load(context.Background(), "jane")
+	assert.NoError(t, err)
+	assert.Equal(t, stored, facts)
+}
+
+func TestFileFactStore_InvalidUserId(t *testing.T) {
+	store, err := NewFileFactStore(t.TempDir())
+	assert.NoError(t, err)
+
+	_, err = store.Load(context.Background(), "../etc")
+	assert.Error(t, err)
+	assert.Error(t, store.Save(context.Background(), "", nil))
+}
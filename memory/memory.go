@@ -2,9 +2,11 @@ package memory
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-
+	"maps"
 	"slices"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/robnmrz/onigiri/utils"
@@ -23,27 +25,90 @@ type MessageContent struct {
 	Content  any    `json:"content"`
 }
 
+// Cloner can be implemented by message content types that need
+// control over how they are copied, e.g. because they hold unexported
+// mutable state. All other content is copied via reflection.
+type Cloner interface {
+	Clone() any
+}
+
+// Clone returns a deep copy of the message content
+func (mc MessageContent) Clone() MessageContent {
+	if cloner, ok := mc.Content.(Cloner); ok {
+		return MessageContent{TypeName: mc.TypeName, Content: cloner.Clone()}
+	}
+	return MessageContent{TypeName: mc.TypeName, Content: utils.DeepCopy(mc.Content)}
+}
+
 // Message is a struct that holds the role and content of a message
 type Message struct {
 	Role    string         `json:"role"`
 	Content MessageContent `json:"content"`
 	TurnId  string         `json:"turn_id"`
+	// Metadata holds additional information about the message,
+	// e.g. the prompt version an assistant message was generated with
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Clone returns a deep copy of the message
+func (m Message) Clone() Message {
+	return Message{
+		Role:     m.Role,
+		Content:  m.Content.Clone(),
+		TurnId:   m.TurnId,
+		Metadata: maps.Clone(m.Metadata),
+	}
+}
+
+// cloneMessages deep copies a list of messages, keeping nil as nil
+func cloneMessages(messages []Message) []Message {
+	if messages == nil {
+		return nil
+	}
+	cloned := make([]Message, len(messages))
+	for i, msg := range messages {
+		cloned[i] = msg.Clone()
+	}
+	return cloned
+}
+
+// DefaultBranchId is the id of the branch every memory starts on
+const DefaultBranchId = "main"
+
+// Branch is a line of conversation inside the memory's tree of turns.
+// A branch forked from another one starts with a copy of its parent's
+// history up to and including the turn it was forked at.
+type Branch struct {
+	Id         string    `json:"id"`
+	ParentId   string    `json:"parent_id,omitempty"`
+	ForkTurnId string    `json:"fork_turn_id,omitempty"`
+	History    []Message `json:"history,omitempty"`
 }
 
 // TODO: Maybe implementing Messages a map of turnId and Message
 // AgentMemory is a struct that holds the memory of the agent
-// in form of the chat history, the current turn id and the max messages
+// in form of the chat history, the current turn id and the max messages.
+// History always holds the messages of the active branch, the
+// messages of all other branches are kept in Branches.
+// All methods are safe for concurrent use, the exported fields
+// must not be accessed directly while the memory is shared.
 type AgentMemory struct {
+	mu sync.RWMutex
+
 	History       []Message `json:"history"`
 	MaxMessages   int       `json:"max_messages"`
 	CurrentTurnId string    `json:"current_turn_id"`
+	ActiveBranch  string    `json:"active_branch,omitempty"`
+	Branches      []*Branch `json:"branches,omitempty"`
 }
 
 // Constructor for a new AgentMemory struct with options
 func NewAgentMemory(ops ...MemoryOption) *AgentMemory {
 	am := &AgentMemory{
-		History:     []Message{},
-		MaxMessages: -1,
+		History:      []Message{},
+		MaxMessages:  -1,
+		ActiveBranch: DefaultBranchId,
+		Branches:     []*Branch{{Id: DefaultBranchId}},
 	}
 
 	for _, op := range ops {
@@ -63,15 +128,27 @@ func WithMaxMessages(maxMessages int) MemoryOption {
 
 // Initialize a new turn
 func (am *AgentMemory) InitializeTurn() {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
 	am.CurrentTurnId = uuid.New().String()
 }
 
 // Add a new message to the history
 func (am *AgentMemory) AddMessage(role string, content any) {
+	am.AddMessageWithMetadata(role, content, nil)
+}
+
+// Add a new message with metadata to the history, the metadata is copied
+func (am *AgentMemory) AddMessageWithMetadata(role string, content any, metadata map[string]string) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
 	am.History = append(am.History, Message{
-		Role:    role,
-		Content: MessageContent{TypeName: utils.GetTypeName(content), Content: content},
-		TurnId:  am.CurrentTurnId,
+		Role:     role,
+		Content:  MessageContent{TypeName: utils.GetTypeName(content), Content: content},
+		TurnId:   am.CurrentTurnId,
+		Metadata: maps.Clone(metadata),
 	})
 
 	// Handle overflow
@@ -100,23 +177,76 @@ func (am *AgentMemory) manageOverflow() {
 // 	}
 // }
 
-// Copy the memory to a new struct
+// Copy the memory to a new struct. The copy is deep, neither the
+// history nor the message contents are shared with the original
 func (am *AgentMemory) Copy() *AgentMemory {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	var branches []*Branch
+	if am.Branches != nil {
+		branches = make([]*Branch, len(am.Branches))
+		for i, branch := range am.Branches {
+			branches[i] = &Branch{
+				Id:         branch.Id,
+				ParentId:   branch.ParentId,
+				ForkTurnId: branch.ForkTurnId,
+				History:    cloneMessages(branch.History),
+			}
+		}
+	}
 	return &AgentMemory{
-		History:       am.History,
+		History:       cloneMessages(am.History),
 		MaxMessages:   am.MaxMessages,
 		CurrentTurnId: am.CurrentTurnId,
+		ActiveBranch:  am.ActiveBranch,
+		Branches:      branches,
 	}
 }
 
+// MemorySnapshot is a point in time copy of an AgentMemory
+// that can be restored later on
+type MemorySnapshot struct {
+	memory *AgentMemory
+}
+
+// Function to take a snapshot of the memory, e.g. before a risky operation
+func (am *AgentMemory) Snapshot() MemorySnapshot {
+	return MemorySnapshot{memory: am.Copy()}
+}
+
+// Function to roll the memory back to a snapshot. The snapshot
+// stays untouched and can be restored multiple times
+func (am *AgentMemory) Restore(snapshot MemorySnapshot) error {
+	if snapshot.memory == nil {
+		return errors.New("cannot restore empty memory snapshot")
+	}
+	restored := snapshot.memory.Copy()
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.History = restored.History
+	am.MaxMessages = restored.MaxMessages
+	am.CurrentTurnId = restored.CurrentTurnId
+	am.ActiveBranch = restored.ActiveBranch
+	am.Branches = restored.Branches
+	return nil
+}
+
 // Getter for retrieving the current turn id
 func (am *AgentMemory) GetTurnId() string {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
 	return am.CurrentTurnId
 }
 
 // Function to delete messages by turn id. If message for turn Id
 // is not found, return error
 func (am *AgentMemory) DeleteMessagesByTurnId(turnId string) error {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
 	initialLength := len(am.History)
 	for i, msg := range am.History {
 		if msg.TurnId == turnId {
@@ -129,14 +259,161 @@ func (am *AgentMemory) DeleteMessagesByTurnId(turnId string) error {
 	return nil
 }
 
+// Function to get a copy of the messages in the history of the
+// active branch. The message contents are shared with the memory
+func (am *AgentMemory) GetHistory() []Message {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	return slices.Clone(am.History)
+}
+
 // Function to get the number of messages in the history
 func (am *AgentMemory) GetMessageCount() int {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
 	return len(am.History)
 }
 
+// ensureBranches makes sure the active branch is registered, e.g. for
+// memories that were created as struct literals or loaded from json
+// written before branches existed
+func (am *AgentMemory) ensureBranches() {
+	if am.ActiveBranch == "" {
+		am.ActiveBranch = DefaultBranchId
+	}
+	if am.findBranch(am.ActiveBranch) == nil {
+		am.Branches = append(am.Branches, &Branch{Id: am.ActiveBranch})
+	}
+}
+
+// findBranch returns the branch with the given id or nil
+func (am *AgentMemory) findBranch(branchId string) *Branch {
+	for _, branch := range am.Branches {
+		if branch.Id == branchId {
+			return branch
+		}
+	}
+	return nil
+}
+
+// branchHistory returns the messages of a branch. The messages of the
+// active branch live in History, not in the branch itself
+func (am *AgentMemory) branchHistory(branch *Branch) []Message {
+	if branch.Id == am.ActiveBranch {
+		return am.History
+	}
+	return branch.History
+}
+
+// Getter for retrieving the id of the active branch
+func (am *AgentMemory) GetActiveBranch() string {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	am.ensureBranches()
+	return am.ActiveBranch
+}
+
+// Function to fork the conversation at the given turn id into a new branch.
+// The new branch starts with the history of the branch containing the turn,
+// up to and including the last message of that turn. The active branch is
+// searched first. Returns the id of the new branch, the active branch is
+// not changed.
+func (am *AgentMemory) ForkBranch(turnId string) (string, error) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	am.ensureBranches()
+
+	// Search the active branch first, then all others in creation order
+	candidates := []*Branch{am.findBranch(am.ActiveBranch)}
+	for _, branch := range am.Branches {
+		if branch.Id != am.ActiveBranch {
+			candidates = append(candidates, branch)
+		}
+	}
+
+	for _, branch := range candidates {
+		history := am.branchHistory(branch)
+		last := -1
+		for i, msg := range history {
+			if msg.TurnId == turnId {
+				last = i
+			}
+		}
+		if last == -1 {
+			continue
+		}
+
+		forked := &Branch{
+			Id:         uuid.New().String(),
+			ParentId:   branch.Id,
+			ForkTurnId: turnId,
+			History:    slices.Clone(history[:last+1]),
+		}
+		am.Branches = append(am.Branches, forked)
+		return forked.Id, nil
+	}
+	return "", fmt.Errorf("turn id %s not found in any branch", turnId)
+}
+
+// Function to switch the active branch. The history of the branch
+// becomes the memory's History. Returns an error if the branch does not exist
+func (am *AgentMemory) SwitchBranch(branchId string) error {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	am.ensureBranches()
+	target := am.findBranch(branchId)
+	if target == nil {
+		return fmt.Errorf("branch %s not found", branchId)
+	}
+	if target.Id == am.ActiveBranch {
+		return nil
+	}
+
+	// Park the active history in its branch and load the target one
+	current := am.findBranch(am.ActiveBranch)
+	current.History = am.History
+	am.History = target.History
+	if am.History == nil {
+		am.History = []Message{}
+	}
+	target.History = nil
+	am.ActiveBranch = target.Id
+
+	// Continue from the last turn of the branch
+	am.CurrentTurnId = ""
+	if len(am.History) > 0 {
+		am.CurrentTurnId = am.History[len(am.History)-1].TurnId
+	}
+	return nil
+}
+
+// Function to list all branches in creation order. The returned branches
+// are copies holding their full history, including the active one
+func (am *AgentMemory) ListBranches() []Branch {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	am.ensureBranches()
+	branches := make([]Branch, 0, len(am.Branches))
+	for _, branch := range am.Branches {
+		b := *branch
+		b.History = slices.Clone(am.branchHistory(branch))
+		branches = append(branches, b)
+	}
+	return branches
+}
+
 // Function to serialize the memory to json.
 // Returns the json string or returns an error
 func (am *AgentMemory) ToJson() (string, error) {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
 	jsonBytes, err := json.MarshalIndent(am, "", "  ")
 	if err != nil {
 		return "", err
@@ -145,7 +422,23 @@ func (am *AgentMemory) ToJson() (string, error) {
 }
 
 // Function to deserialize the memory from json.
-// Populated the memory with the json data or returns an error
+// Replaces the memory with the json data or returns an error,
+// nothing of the previous memory is kept
 func (am *AgentMemory) FromJson(jsonString string) error {
-	return json.Unmarshal([]byte(jsonString), am)
+	// Decode into a fresh memory so fields missing from the json,
+	// e.g. branches of json written before they existed, don't survive
+	loaded := &AgentMemory{}
+	if err := json.Unmarshal([]byte(jsonString), loaded); err != nil {
+		return err
+	}
+	loaded.ensureBranches()
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.History = loaded.History
+	am.MaxMessages = loaded.MaxMessages
+	am.CurrentTurnId = loaded.CurrentTurnId
+	am.ActiveBranch = loaded.ActiveBranch
+	am.Branches = loaded.Branches
+	return nil
 }
@@ -2,6 +2,8 @@ package memory
 
 import (
 	"encoding/json"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -43,6 +45,27 @@ func TestAddMessage(t *testing.T) {
 	assert.Equal(t, "DummyContent", am.History[0].Content.TypeName)
 }
 
+func TestAddMessageWithMetadata(t *testing.T) {
+	am := NewAgentMemory()
+	metadata := map[string]string{"prompt_version": "v1"}
+	am.AddMessageWithMetadata("assistant", DummyContent{Text: "Hi"}, metadata)
+	metadata["prompt_version"] = "v2"
+
+	history := am.GetHistory()
+	assert.Equal(t, "v1", history[0].Metadata["prompt_version"])
+
+	// Metadata survives a json round trip and is deep copied
+	jsonString, err := am.ToJson()
+	assert.NoError(t, err)
+	restored := NewAgentMemory()
+	assert.NoError(t, restored.FromJson(jsonString))
+	assert.Equal(t, "v1", restored.History[0].Metadata["prompt_version"])
+
+	copied := am.Copy()
+	copied.History[0].Metadata["prompt_version"] = "changed"
+	assert.Equal(t, "v1", am.History[0].Metadata["prompt_version"])
+}
+
 func TestMessageOverflow(t *testing.T) {
 	am := NewAgentMemory(WithMaxMessages(2))
 	am.InitializeTurn()
@@ -103,3 +126,265 @@ func TestDeleteMessagesByTurnId_NotFound(t *testing.T) {
 	err := am.DeleteMessagesByTurnId("non-existent-id")
 	assert.Error(t, err)
 }
+
+func TestNewAgentMemory_DefaultBranch(t *testing.T) {
+	am := NewAgentMemory()
+
+	assert.Equal(t, DefaultBranchId, am.GetActiveBranch())
+	assert.Equal(t, 1, len(am.ListBranches()))
+}
+
+func TestForkBranch(t *testing.T) {
+	am := NewAgentMemory()
+	am.InitializeTurn()
+	firstTurn := am.CurrentTurnId
+	am.AddMessage("user", DummyContent{Text: "question"})
+	am.AddMessage("assistant", DummyContent{Text: "answer"})
+	am.InitializeTurn()
+	am.AddMessage("user", DummyContent{Text: "follow up"})
+
+	branchId, err := am.ForkBranch(firstTurn)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, branchId)
+
+	// Forking does not change the active branch
+	assert.Equal(t, DefaultBranchId, am.GetActiveBranch())
+	assert.Equal(t, 3, am.GetMessageCount())
+
+	branches := am.ListBranches()
+	assert.Equal(t, 2, len(branches))
+	assert.Equal(t, branchId, branches[1].Id)
+	assert.Equal(t, DefaultBranchId, branches[1].ParentId)
+	assert.Equal(t, firstTurn, branches[1].ForkTurnId)
+	assert.Equal(t, 2, len(branches[1].History))
+}
+
+func TestForkBranch_NotFound(t *testing.T) {
+	am := NewAgentMemory()
+
+	_, err := am.ForkBranch("non-existent-id")
+	assert.Error(t, err)
+}
+
+func TestSwitchBranch(t *testing.T) {
+	am := NewAgentMemory()
+	am.InitializeTurn()
+	firstTurn := am.CurrentTurnId
+	am.AddMessage("user", DummyContent{Text: "question"})
+	am.AddMessage("assistant", DummyContent{Text: "answer"})
+
+	branchId, err := am.ForkBranch(firstTurn)
+	assert.NoError(t, err)
+
+	err = am.SwitchBranch(branchId)
+	assert.NoError(t, err)
+	assert.Equal(t, branchId, am.GetActiveBranch())
+	assert.Equal(t, firstTurn, am.GetTurnId())
+
+	// Messages on the new branch don't show up on the original one
+	am.InitializeTurn()
+	am.AddMessage("user", DummyContent{Text: "alternative"})
+	assert.Equal(t, 3, am.GetMessageCount())
+
+	err = am.SwitchBranch(DefaultBranchId)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, am.GetMessageCount())
+	assert.Equal(t, "answer", am.History[1].Content.Content.(DummyContent).Text)
+}
+
+func TestSwitchBranch_NotFound(t *testing.T) {
+	am := NewAgentMemory()
+
+	err := am.SwitchBranch("non-existent-id")
+	assert.Error(t, err)
+	assert.Equal(t, DefaultBranchId, am.GetActiveBranch())
+}
+
+func TestBranchesToJsonAndFromJson(t *testing.T) {
+	am := NewAgentMemory()
+	am.InitializeTurn()
+	am.AddMessage("user", DummyContent{Text: "question"})
+	branchId, err := am.ForkBranch(am.CurrentTurnId)
+	assert.NoError(t, err)
+	assert.NoError(t, am.SwitchBranch(branchId))
+
+	jsonStr, err := am.ToJson()
+	assert.NoError(t, err)
+
+	newAm := NewAgentMemory()
+	err = newAm.FromJson(jsonStr)
+	assert.NoError(t, err)
+	assert.Equal(t, branchId, newAm.GetActiveBranch())
+	assert.Equal(t, 2, len(newAm.ListBranches()))
+
+	assert.NoError(t, newAm.SwitchBranch(DefaultBranchId))
+	assert.Equal(t, 1, newAm.GetMessageCount())
+}
+
+func TestFromJson_WithoutBranches(t *testing.T) {
+	am := NewAgentMemory()
+	err := am.FromJson(`{"history": [], "max_messages": -1, "current_turn_id": ""}`)
+	assert.NoError(t, err)
+
+	assert.Equal(t, DefaultBranchId, am.GetActiveBranch())
+	assert.Equal(t, 1, len(am.ListBranches()))
+}
+
+func TestFromJson_ReplacesExistingBranches(t *testing.T) {
+	am := NewAgentMemory()
+	am.InitializeTurn()
+	am.AddMessage("user", DummyContent{Text: "question"})
+	branchId, err := am.ForkBranch(am.CurrentTurnId)
+	assert.NoError(t, err)
+	assert.NoError(t, am.SwitchBranch(branchId))
+	am.AddMessage("assistant", DummyContent{Text: "answer"})
+
+	// Json written before branches existed doesn't keep the old branches
+	err = am.FromJson(`{"history": [], "max_messages": -1, "current_turn_id": ""}`)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultBranchId, am.GetActiveBranch())
+	assert.Equal(t, 1, len(am.ListBranches()))
+	assert.Equal(t, 0, am.GetMessageCount())
+	assert.Error(t, am.SwitchBranch(branchId))
+
+}
+
+func TestFromJson_DoesNotReuseBranches(t *testing.T) {
+	am := NewAgentMemory()
+	am.InitializeTurn()
+	am.AddMessage("user", DummyContent{Text: "stale"})
+	branchId, err := am.ForkBranch(am.CurrentTurnId)
+	assert.NoError(t, err)
+	assert.NoError(t, am.SwitchBranch(branchId))
+
+	// The empty main branch of the json doesn't pick up the old history
+	jsonStr := `{"history": [], "max_messages": -1, "current_turn_id": "", "active_branch": "other",
+		"branches": [{"id": "main"}, {"id": "other"}]}`
+	assert.NoError(t, am.FromJson(jsonStr))
+	assert.NoError(t, am.SwitchBranch(DefaultBranchId))
+	assert.Equal(t, 0, am.GetMessageCount())
+}
+
+// Dummy content with mutable fields for copy tests
+type MutableContent struct {
+	Items []string          `json:"items"`
+	Meta  map[string]string `json:"meta"`
+}
+
+// Dummy content implementing its own cloning strategy
+type ClonerContent struct {
+	Text   string
+	clones *int
+}
+
+func (c ClonerContent) Clone() any {
+	*c.clones++
+	return ClonerContent{Text: c.Text, clones: c.clones}
+}
+
+func TestCopy_IsDeep(t *testing.T) {
+	am := NewAgentMemory()
+	am.InitializeTurn()
+	am.AddMessage("user", MutableContent{Items: []string{"a"}, Meta: map[string]string{"k": "v"}})
+
+	copy := am.Copy()
+
+	// Appending to either history must not leak into the other
+	copy.AddMessage("assistant", DummyContent{Text: "only in copy"})
+	am.AddMessage("assistant", DummyContent{Text: "only in original"})
+	assert.Equal(t, "only in copy", copy.History[1].Content.Content.(DummyContent).Text)
+	assert.Equal(t, "only in original", am.History[1].Content.Content.(DummyContent).Text)
+
+	// Mutable content values are not shared
+	copied := copy.History[0].Content.Content.(MutableContent)
+	copied.Items[0] = "changed"
+	copied.Meta["k"] = "changed"
+	original := am.History[0].Content.Content.(MutableContent)
+	assert.Equal(t, "a", original.Items[0])
+	assert.Equal(t, "v", original.Meta["k"])
+}
+
+func TestCopy_UsesCloner(t *testing.T) {
+	clones := 0
+	am := NewAgentMemory()
+	am.AddMessage("user", ClonerContent{Text: "clone me", clones: &clones})
+
+	copy := am.Copy()
+	assert.Equal(t, 1, clones)
+	assert.Equal(t, "clone me", copy.History[0].Content.Content.(ClonerContent).Text)
+}
+
+func TestCopy_Branches(t *testing.T) {
+	am := NewAgentMemory()
+	am.InitializeTurn()
+	am.AddMessage("user", DummyContent{Text: "question"})
+	branchId, err := am.ForkBranch(am.CurrentTurnId)
+	assert.NoError(t, err)
+
+	copy := am.Copy()
+	assert.NoError(t, copy.SwitchBranch(branchId))
+	copy.AddMessage("assistant", DummyContent{Text: "answer"})
+
+	// The original's branch is unaffected
+	branches := am.ListBranches()
+	assert.Equal(t, 1, len(branches[1].History))
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	am := NewAgentMemory()
+	am.InitializeTurn()
+	am.AddMessage("user", MutableContent{Items: []string{"keep"}})
+	snapshot := am.Snapshot()
+	turnId := am.CurrentTurnId
+
+	am.InitializeTurn()
+	am.AddMessage("user", DummyContent{Text: "risky"})
+	am.History[0].Content.Content.(MutableContent).Items[0] = "changed"
+
+	err := am.Restore(snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, am.GetMessageCount())
+	assert.Equal(t, turnId, am.GetTurnId())
+	assert.Equal(t, "keep", am.History[0].Content.Content.(MutableContent).Items[0])
+
+	// The snapshot can be restored again after further changes
+	am.AddMessage("assistant", DummyContent{Text: "again"})
+	assert.NoError(t, am.Restore(snapshot))
+	assert.Equal(t, 1, am.GetMessageCount())
+}
+
+func TestRestore_EmptySnapshot(t *testing.T) {
+	am := NewAgentMemory()
+
+	err := am.Restore(MemorySnapshot{})
+	assert.Error(t, err)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	am := NewAgentMemory(WithMaxMessages(10))
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			am.InitializeTurn()
+			am.AddMessage("user", DummyContent{Text: fmt.Sprintf("msg %d", i)})
+		}()
+		go func() {
+			defer wg.Done()
+			_ = am.GetHistory()
+			_ = am.Copy()
+			_, _ = am.ToJson()
+		}()
+		go func() {
+			defer wg.Done()
+			snapshot := am.Snapshot()
+			_ = am.ListBranches()
+			assert.NoError(t, am.Restore(snapshot))
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, am.GetMessageCount(), 10)
+}
@@ -0,0 +1,190 @@
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// PartType is the kind of a part in a multi-part message
+type PartType string
+
+const (
+	PartTypeText  PartType = "text"
+	PartTypeImage PartType = "image"
+	PartTypeAudio PartType = "audio"
+	PartTypeFile  PartType = "file"
+)
+
+// multiPartTypeName is the type name of multi-part message content
+const multiPartTypeName = "MultiPartContent"
+
+// ContentPart is a single part of a multi-part message. Text parts use Text,
+// all other parts reference their data either by URL or inline in Data,
+// which is base64 encoded when serialized to json
+type ContentPart struct {
+	Type     PartType `json:"type"`
+	Text     string   `json:"text,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Data     []byte   `json:"data,omitempty"`
+	MimeType string   `json:"mime_type,omitempty"`
+	Name     string   `json:"name,omitempty"`
+}
+
+// MultiPartContent is message content made up of typed parts,
+// e.g. a question together with an image
+type MultiPartContent struct {
+	Parts []ContentPart `json:"parts"`
+}
+
+// TextPart creates a text part
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: PartTypeText, Text: text}
+}
+
+// ImageURLPart creates an image part referencing the image by url
+func ImageURLPart(url string, mimeType string) ContentPart {
+	return ContentPart{Type: PartTypeImage, URL: url, MimeType: mimeType}
+}
+
+// ImageDataPart creates an image part holding the image bytes
+func ImageDataPart(data []byte, mimeType string) ContentPart {
+	return ContentPart{Type: PartTypeImage, Data: data, MimeType: mimeType}
+}
+
+// AudioURLPart creates an audio part referencing the audio by url
+func AudioURLPart(url string, mimeType string) ContentPart {
+	return ContentPart{Type: PartTypeAudio, URL: url, MimeType: mimeType}
+}
+
+// AudioDataPart creates an audio part holding the audio bytes
+func AudioDataPart(data []byte, mimeType string) ContentPart {
+	return ContentPart{Type: PartTypeAudio, Data: data, MimeType: mimeType}
+}
+
+// FileURLPart creates a file attachment part referencing the file by url
+func FileURLPart(name string, url string, mimeType string) ContentPart {
+	return ContentPart{Type: PartTypeFile, Name: name, URL: url, MimeType: mimeType}
+}
+
+// FileDataPart creates a file attachment part holding the file bytes
+func FileDataPart(name string, data []byte, mimeType string) ContentPart {
+	return ContentPart{Type: PartTypeFile, Name: name, Data: data, MimeType: mimeType}
+}
+
+// ToPart converts the image to an image part referencing it by url
+func (img Image) ToPart() ContentPart {
+	return ImageURLPart(img.Value, "")
+}
+
+// Validate checks that the part carries the data its type requires
+func (p ContentPart) Validate() error {
+	switch p.Type {
+	case PartTypeText:
+		return nil
+	case PartTypeImage, PartTypeAudio, PartTypeFile:
+		if p.URL == "" && len(p.Data) == 0 {
+			return fmt.Errorf("%s part needs either a url or inline data", p.Type)
+		}
+		if p.URL != "" && len(p.Data) > 0 {
+			return fmt.Errorf("%s part cannot have both a url and inline data", p.Type)
+		}
+		if len(p.Data) > 0 && p.MimeType == "" {
+			return fmt.Errorf("%s part with inline data needs a mime type", p.Type)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown part type %q", p.Type)
+	}
+}
+
+// cloneParts copies the parts including their data,
+// so the copies don't share memory with the originals
+func cloneParts(parts []ContentPart) []ContentPart {
+	cloned := slices.Clone(parts)
+	for i := range cloned {
+		cloned[i].Data = slices.Clone(cloned[i].Data)
+	}
+	return cloned
+}
+
+// Parts returns the typed parts of the message content, so LLM clients
+// can handle all content alike. Multi-part content returns copies of its parts,
+// strings become a text part and any other content is encoded to json
+// and returned as a single text part
+func (mc MessageContent) Parts() []ContentPart {
+	switch content := mc.Content.(type) {
+	case nil:
+		return nil
+	case MultiPartContent:
+		return cloneParts(content.Parts)
+	case *MultiPartContent:
+		return cloneParts(content.Parts)
+	case string:
+		return []ContentPart{TextPart(content)}
+	default:
+		jsonBytes, err := json.Marshal(content)
+		if err != nil {
+			return []ContentPart{TextPart(fmt.Sprint(content))}
+		}
+		return []ContentPart{TextPart(string(jsonBytes))}
+	}
+}
+
+// UnmarshalJSON decodes message content. Multi-part content is decoded
+// into MultiPartContent, any other content into generic json values
+func (mc *MessageContent) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		TypeName string          `json:"type_name"`
+		Content  json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	mc.TypeName = raw.TypeName
+	mc.Content = nil
+	if len(raw.Content) == 0 {
+		return nil
+	}
+
+	if raw.TypeName == multiPartTypeName {
+		var multiPart MultiPartContent
+		if err := json.Unmarshal(raw.Content, &multiPart); err != nil {
+			return err
+		}
+		mc.Content = multiPart
+		return nil
+	}
+	return json.Unmarshal(raw.Content, &mc.Content)
+}
+
+// Add a new multi-part message to the history. Returns an
+// error if there are no parts or one of them is invalid
+func (am *AgentMemory) AddMultiPartMessage(role string, parts ...ContentPart) error {
+	if len(parts) == 0 {
+		return errors.New("multi-part message needs at least one part")
+	}
+	for i, part := range parts {
+		if err := part.Validate(); err != nil {
+			return fmt.Errorf("invalid part %d: %w", i, err)
+		}
+	}
+
+	// The caller may reuse its buffers, so the message gets its own data
+	am.AddMessage(role, MultiPartContent{Parts: cloneParts(parts)})
+	return nil
+}
+
+// Add a new message with text and images referenced by url to the history
+func (am *AgentMemory) AddImageMessage(role string, text string, images ...Image) error {
+	parts := []ContentPart{}
+	if text != "" {
+		parts = append(parts, TextPart(text))
+	}
+	for _, img := range images {
+		parts = append(parts, img.ToPart())
+	}
+	return am.AddMultiPartMessage(role, parts...)
+}
@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddMultiPartMessage(t *testing.T) {
+	am := NewAgentMemory()
+	am.InitializeTurn()
+
+	err := am.AddMultiPartMessage("user",
+		TextPart("What is in this picture?"),
+		ImageDataPart([]byte{0x89, 0x50, 0x4e, 0x47}, "image/png"),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, am.GetMessageCount())
+	assert.Equal(t, "MultiPartContent", am.History[0].Content.TypeName)
+
+	parts := am.History[0].Content.Parts()
+	assert.Equal(t, 2, len(parts))
+	assert.Equal(t, PartTypeText, parts[0].Type)
+	assert.Equal(t, PartTypeImage, parts[1].Type)
+	assert.Equal(t, "image/png", parts[1].MimeType)
+}
+
+func TestAddMultiPartMessage_Invalid(t *testing.T) {
+	am := NewAgentMemory()
+
+	assert.Error(t, am.AddMultiPartMessage("user"))
+	assert.Error(t, am.AddMultiPartMessage("user", ImageDataPart([]byte{1}, "")))
+	assert.Error(t, am.AddMultiPartMessage("user", AudioURLPart("", "audio/mpeg")))
+	assert.Error(t, am.AddMultiPartMessage("user", ContentPart{Type: "video", URL: "https://example.com"}))
+	assert.Equal(t, 0, am.GetMessageCount())
+}
+
+func TestAddImageMessage(t *testing.T) {
+	am := NewAgentMemory()
+
+	err := am.AddImageMessage("user", "Compare these", Image{Value: "https://example.com/a.png"}, Image{Value: "https://example.com/b.png"})
+	assert.NoError(t, err)
+
+	parts := am.History[0].Content.Parts()
+	assert.Equal(t, 3, len(parts))
+	assert.Equal(t, "https://example.com/b.png", parts[2].URL)
+}
+
+func TestMultiPartMessage_ToJsonAndFromJson(t *testing.T) {
+	am := NewAgentMemory()
+	err := am.AddMultiPartMessage("user",
+		TextPart("Transcribe this"),
+		AudioDataPart([]byte("raw audio"), "audio/wav"),
+		FileURLPart("report.pdf", "https://example.com/report.pdf", "application/pdf"),
+	)
+	assert.NoError(t, err)
+
+	jsonStr, err := am.ToJson()
+	assert.NoError(t, err)
+	// Inline data is base64 encoded
+	assert.True(t, strings.Contains(jsonStr, `"data": "cmF3IGF1ZGlv"`))
+
+	newAm := NewAgentMemory()
+	assert.NoError(t, newAm.FromJson(jsonStr))
+
+	content, ok := newAm.History[0].Content.Content.(MultiPartContent)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("raw audio"), content.Parts[1].Data)
+	assert.Equal(t, "report.pdf", content.Parts[2].Name)
+}
+
+func TestParts_NonMultiPartContent(t *testing.T) {
+	assert.Nil(t, MessageContent{}.Parts())
+
+	parts := MessageContent{TypeName: "string", Content: "Hello"}.Parts()
+	assert.Equal(t, []ContentPart{TextPart("Hello")}, parts)
+
+	parts = MessageContent{TypeName: "DummyContent", Content: DummyContent{Text: "Hello"}}.Parts()
+	assert.Equal(t, []ContentPart{TextPart(`{"text":"Hello"}`)}, parts)
+}
+
+func TestCopy_MultiPartDataIsDeep(t *testing.T) {
+	am := NewAgentMemory()
+	assert.NoError(t, am.AddMultiPartMessage("user", FileDataPart("a.txt", []byte("abc"), "text/plain")))
+
+	copy := am.Copy()
+	copy.History[0].Content.Content.(MultiPartContent).Parts[0].Data[0] = 'x'
+
+	assert.Equal(t, []byte("abc"), am.History[0].Content.Parts()[0].Data)
+}
+
+func TestAddMultiPartMessage_CopiesData(t *testing.T) {
+	am := NewAgentMemory()
+	buffer := []byte("abc")
+	assert.NoError(t, am.AddMultiPartMessage("user", FileDataPart("a.txt", buffer, "text/plain")))
+
+	// Reusing the buffer doesn't change the message
+	copy(buffer, "xyz")
+	parts := am.History[0].Content.Parts()
+	assert.Equal(t, []byte("abc"), parts[0].Data)
+
+	// Neither does changing the returned parts
+	parts[0].Data[0] = 'x'
+	assert.Equal(t, []byte("abc"), am.History[0].Content.Parts()[0].Data)
+}
@@ -0,0 +1,166 @@
+package prompt
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenEstimator estimates the number of tokens of a text
+type TokenEstimator func(text string) int
+
+// EstimateTokens is the default token estimator, it assumes
+// four characters per token which is close enough for english text
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// truncationMarker is appended to context provider info that was truncated
+const truncationMarker = " [truncated]"
+
+// PromptReport describes the size of a generated prompt and what
+// was cut from the context providers to fit the token budget
+type PromptReport struct {
+	// Budget is the token budget, 0 means no limit
+	Budget          int
+	EstimatedTokens int
+	// OverBudget is set if the prompt exceeds the budget even without any
+	// context provider info, the sections themselves are never cut
+	OverBudget bool
+	Cuts       []ContextCut
+}
+
+// ContextCut describes the info of a context provider that was truncated or dropped
+type ContextCut struct {
+	Name           string
+	OriginalTokens int
+	KeptTokens     int
+	Dropped        bool
+}
+
+// Funtions to limit the estimated tokens of the system prompt. Context
+// provider info is truncated or dropped to fit, lowest priority first.
+// The sections, including background and output instructions, are never cut
+func WithTokenBudget(budget int) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		spg.tokenBudget = budget
+	}
+}
+
+// Funtions to set how tokens are estimated, defaults to EstimateTokens
+func WithTokenEstimator(estimator TokenEstimator) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		spg.tokenEstimator = estimator
+	}
+}
+
+// Functional option to limit the share of the token budget
+// a context provider's info may take, between 0 and 1
+func WithMaxShare(share float64) ContextProviderOption {
+	return func(entry *ContextProviderEntry) {
+		entry.MaxShare = share
+	}
+}
+
+// tokenBudget fits context provider info into a token limit
+type tokenBudget struct {
+	limit    int
+	estimate TokenEstimator
+}
+
+// fit renders the prompt, truncating or dropping context provider info until
+// it fits the budget. Providers are in priority order, so the highest priority
+// ones keep their info and the lowest priority ones are cut first
+func (b tokenBudget) fit(renderer PromptRenderer, data PromptData, providers []registeredContextProvider) (string, PromptReport, error) {
+	report := PromptReport{Budget: b.limit}
+	if b.limit <= 0 {
+		prompt, err := renderPrompt(renderer, data)
+		report.EstimatedTokens = b.estimate(prompt)
+		return prompt, report, err
+	}
+
+	shares := map[string]float64{}
+	for _, registered := range providers {
+		shares[registered.Name] = registered.MaxShare
+	}
+
+	contexts := data.ContextProviders
+	data.ContextProviders = []ContextSection{}
+	prompt, err := renderPrompt(renderer, data)
+	if err != nil {
+		return "", report, err
+	}
+	if b.estimate(prompt) > b.limit {
+		report.OverBudget = true
+		for _, section := range contexts {
+			report.Cuts = append(report.Cuts, ContextCut{Name: section.Name, OriginalTokens: b.estimate(section.Info), Dropped: true})
+		}
+		report.EstimatedTokens = b.estimate(prompt)
+		return prompt, report, nil
+	}
+
+	kept := []ContextSection{}
+	for _, section := range contexts {
+		shareLimit := -1
+		if share := shares[section.Name]; share > 0 {
+			shareLimit = int(share * float64(b.limit))
+		}
+
+		var renderErr error
+		fits := func(info string) bool {
+			if shareLimit >= 0 && b.estimate(info) > shareLimit {
+				return false
+			}
+			candidate := section
+			candidate.Info = info
+			data.ContextProviders = append(kept[:len(kept):len(kept)], candidate)
+			prompt, err := renderPrompt(renderer, data)
+			if err != nil {
+				renderErr = err
+				return false
+			}
+			return b.estimate(prompt) <= b.limit
+		}
+
+		if fits(section.Info) {
+			kept = append(kept, section)
+			continue
+		}
+		if renderErr != nil {
+			return "", report, renderErr
+		}
+
+		// Find the longest prefix that still fits
+		runes := []rune(section.Info)
+		length := sort.Search(len(runes), func(n int) bool {
+			return n > 0 && !fits(truncateInfo(runes, n))
+		}) - 1
+		if renderErr != nil {
+			return "", report, renderErr
+		}
+
+		cut := ContextCut{Name: section.Name, OriginalTokens: b.estimate(section.Info)}
+		if length < 1 {
+			cut.Dropped = true
+		} else {
+			section.Info = truncateInfo(runes, length)
+			cut.KeptTokens = b.estimate(section.Info)
+			kept = append(kept, section)
+		}
+		report.Cuts = append(report.Cuts, cut)
+	}
+
+	data.ContextProviders = kept
+	prompt, err = renderPrompt(renderer, data)
+	if err != nil {
+		return "", report, err
+	}
+	report.EstimatedTokens = b.estimate(prompt)
+	return prompt, report, nil
+}
+
+// truncateInfo keeps the first n characters of the info and marks it as truncated
+func truncateInfo(runes []rune, n int) string {
+	return strings.TrimRightFunc(string(runes[:n]), unicode.IsSpace) + truncationMarker
+}
@@ -0,0 +1,127 @@
+package prompt
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countChars estimates one token per character to make budgets easy to reason about
+func countChars(text string) int {
+	return len(text)
+}
+
+func newBudgetGenerator(opts ...SytemPromptGeneratorOption) *SystemPromptGenerator {
+	opts = append([]SytemPromptGeneratorOption{
+		WithBackground([]string{"I am a support assistant."}),
+		WithOutputInstructions([]string{"Be concise."}),
+		WithTokenEstimator(countChars),
+	}, opts...)
+	return NewSystemPromptGenerator(opts...)
+}
+
+func TestEstimateTokens(t *testing.T) {
+	assert.Equal(t, 0, EstimateTokens(""))
+	assert.Equal(t, 1, EstimateTokens("abc"))
+	assert.Equal(t, 2, EstimateTokens("abcdefgh"))
+	assert.Equal(t, 1, EstimateTokens("äöü"))
+}
+
+func TestGeneratePromptWithReport_NoBudget(t *testing.T) {
+	spg := newBudgetGenerator(WithContextProvider("docs", StaticContextProvider{Title: "Docs", Info: strings.Repeat("a", 500)}))
+
+	prompt, report, err := spg.GeneratePromptWithReport(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Budget)
+	assert.Equal(t, len(prompt), report.EstimatedTokens)
+	assert.Empty(t, report.Cuts)
+}
+
+func TestTokenBudget_DropsLowestPriority(t *testing.T) {
+	high := StaticContextProvider{Title: "High", Info: "important facts"}
+	low := StaticContextProvider{Title: "Low", Info: "nice to know"}
+
+	// The budget only leaves room for the high priority provider
+	expected, err := newBudgetGenerator(WithContextProvider("high", high)).GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+
+	spg := newBudgetGenerator(
+		WithTokenBudget(len(expected)+5),
+		WithContextProvider("low", low, WithPriority(1)),
+		WithContextProvider("high", high, WithPriority(10)),
+	)
+	prompt, report, err := spg.GeneratePromptWithReport(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, prompt)
+	assert.Equal(t, len(expected), report.EstimatedTokens)
+	assert.Equal(t, []ContextCut{{Name: "low", OriginalTokens: len(low.Info), Dropped: true}}, report.Cuts)
+}
+
+func TestTokenBudget_TruncatesToFit(t *testing.T) {
+	full := StaticContextProvider{Title: "Docs", Info: strings.Repeat("word ", 40)}
+	untruncated, err := newBudgetGenerator(WithContextProvider("docs", full)).GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+
+	budget := len(untruncated) - 50
+	spg := newBudgetGenerator(WithTokenBudget(budget), WithContextProvider("docs", full))
+	prompt, report, err := spg.GeneratePromptWithReport(context.Background(), nil)
+	assert.NoError(t, err)
+
+	assert.LessOrEqual(t, report.EstimatedTokens, budget)
+	assert.True(t, strings.HasSuffix(prompt, "wo [truncated]"))
+	assert.Equal(t, 1, len(report.Cuts))
+	assert.Equal(t, "docs", report.Cuts[0].Name)
+	assert.False(t, report.Cuts[0].Dropped)
+	assert.Less(t, report.Cuts[0].KeptTokens, report.Cuts[0].OriginalTokens)
+}
+
+func TestTokenBudget_MaxShare(t *testing.T) {
+	spg := newBudgetGenerator(
+		WithTokenBudget(1000),
+		WithContextProvider("docs", StaticContextProvider{Title: "Docs", Info: strings.Repeat("a", 300)}, WithMaxShare(0.1)),
+		WithContextProvider("facts", StaticContextProvider{Title: "Facts", Info: strings.Repeat("b", 300)}),
+	)
+
+	prompt, report, err := spg.GeneratePromptWithReport(context.Background(), nil)
+	assert.NoError(t, err)
+
+	// Only the provider with a max share is cut, the other one fits the budget
+	assert.Equal(t, 1, len(report.Cuts))
+	assert.Equal(t, "docs", report.Cuts[0].Name)
+	assert.LessOrEqual(t, report.Cuts[0].KeptTokens, 100)
+	assert.Contains(t, prompt, strings.Repeat("b", 300))
+}
+
+func TestTokenBudget_NeverCutsSections(t *testing.T) {
+	spg := newBudgetGenerator(
+		WithTokenBudget(10),
+		WithContextProvider("docs", StaticContextProvider{Title: "Docs", Info: "info"}),
+	)
+
+	prompt, report, err := spg.GeneratePromptWithReport(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.True(t, report.OverBudget)
+	assert.Contains(t, prompt, "I am a support assistant.")
+	assert.Contains(t, prompt, "Be concise.")
+	assert.NotContains(t, prompt, "Docs")
+	assert.Equal(t, []ContextCut{{Name: "docs", OriginalTokens: 4, Dropped: true}}, report.Cuts)
+}
+
+func TestTokenBudget_GeneratePrompt(t *testing.T) {
+	spg := newBudgetGenerator(
+		WithTokenBudget(150),
+		WithContextProvider("docs", StaticContextProvider{Title: "Docs", Info: strings.Repeat("a", 500)}),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(prompt), 150)
+
+	// The budget applies to other renderers too
+	prompt, err = spg.GeneratePromptWith(context.Background(), XMLRenderer{})
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(prompt), 150)
+	assert.Contains(t, prompt, "<output_instructions>")
+}
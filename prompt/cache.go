@@ -0,0 +1,229 @@
+package prompt
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CacheStats holds the hit and miss counters of a caching context provider
+type CacheStats struct {
+	// Hits counts calls answered with a fresh cached info
+	Hits uint64
+	// StaleHits counts calls answered with an expired info while revalidating
+	StaleHits uint64
+	// Misses counts calls that had to fetch the info from the provider
+	Misses uint64
+	// Shared counts misses that waited for a fetch already in progress
+	// instead of fetching the info again
+	Shared uint64
+	// Refreshes counts background refreshes that were started
+	Refreshes uint64
+	// Errors counts failed fetches, both in the foreground and in the background
+	Errors uint64
+}
+
+// Option type for caching context providers
+type CachingOption func(*infoCache)
+
+// Functional option to keep serving an expired info for up to maxStale
+// after the TTL while it is refreshed in the background
+func WithStaleWhileRevalidate(maxStale time.Duration) CachingOption {
+	return func(c *infoCache) {
+		c.maxStale = maxStale
+	}
+}
+
+// CachingContextProvider memoizes the info of a SystemPromptContextProviderBase
+// for a TTL. It is a SystemPromptContextProviderBase itself and is registered
+// with RegisterContextProvider like the provider it wraps
+type CachingContextProvider struct {
+	*infoCache
+	provider SystemPromptContextProviderBase
+}
+
+// Constructor for a new CachingContextProvider wrapping the given provider
+func NewCachingContextProvider(provider SystemPromptContextProviderBase, ttl time.Duration, opts ...CachingOption) *CachingContextProvider {
+	return &CachingContextProvider{
+		infoCache: newInfoCache(AdaptContextProvider(provider), ttl, opts...),
+		provider:  provider,
+	}
+}
+
+// GetTitle returns the title of the wrapped provider
+func (c *CachingContextProvider) GetTitle() string {
+	return c.provider.GetTitle()
+}
+
+// GetInfo returns the cached info while it is fresh and fetches it otherwise
+func (c *CachingContextProvider) GetInfo() string {
+	// The wrapped provider can't fail, so neither can the cache
+	info, _ := c.get(context.Background())
+	return info
+}
+
+// FallibleCachingContextProvider memoizes the info of a ContextProvider for a
+// TTL, failed fetches are not cached. It is registered with
+// RegisterFallibleContextProvider
+type FallibleCachingContextProvider struct {
+	*infoCache
+	provider ContextProvider
+}
+
+// Constructor for a new FallibleCachingContextProvider wrapping the given provider
+func NewFallibleCachingContextProvider(provider ContextProvider, ttl time.Duration, opts ...CachingOption) *FallibleCachingContextProvider {
+	return &FallibleCachingContextProvider{
+		infoCache: newInfoCache(provider, ttl, opts...),
+		provider:  provider,
+	}
+}
+
+// GetTitle returns the title of the wrapped provider
+func (c *FallibleCachingContextProvider) GetTitle() string {
+	return c.provider.GetTitle()
+}
+
+// GetInfo returns the cached info while it is fresh and fetches it otherwise.
+// With stale-while-revalidate an expired info is returned right away and
+// refreshed in the background
+func (c *FallibleCachingContextProvider) GetInfo(ctx context.Context) (string, error) {
+	return c.get(ctx)
+}
+
+// fetchCall is a fetch of the provider's info in progress,
+// done is closed once info and err are set
+type fetchCall struct {
+	done chan struct{}
+	info string
+	err  error
+}
+
+// infoCache memoizes the info of a provider, it backs both caching providers.
+// Concurrent misses share a single fetch
+type infoCache struct {
+	provider ContextProvider
+	ttl      time.Duration
+	maxStale time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	info      string
+	fetchedAt time.Time
+	valid     bool
+	// inflight is the fetch in progress, nil if there is none
+	inflight *fetchCall
+	// generation is increased on invalidation so that fetches started
+	// before don't store their outdated result
+	generation uint64
+	stats      CacheStats
+}
+
+// newInfoCache returns an empty cache for the provider
+func newInfoCache(provider ContextProvider, ttl time.Duration, opts ...CachingOption) *infoCache {
+	c := &infoCache{
+		provider: provider,
+		ttl:      ttl,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// get returns the cached info while it is fresh and fetches it otherwise.
+// A call that finds a fetch in progress waits for it instead of fetching
+// again, giving up when its context is done
+func (c *infoCache) get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	age := c.now().Sub(c.fetchedAt)
+	if c.valid && age < c.ttl {
+		c.stats.Hits++
+		info := c.info
+		c.mu.Unlock()
+		return info, nil
+	}
+	if c.valid && age < c.ttl+c.maxStale {
+		c.stats.StaleHits++
+		info := c.info
+		if c.inflight == nil {
+			c.stats.Refreshes++
+			c.fetch(context.WithoutCancel(ctx))
+		}
+		c.mu.Unlock()
+		return info, nil
+	}
+
+	// The fetch is shared, so it must not end with the caller that started
+	// it. Each caller still stops waiting when its own context is done
+	call := c.inflight
+	if call != nil {
+		c.stats.Shared++
+	} else {
+		c.stats.Misses++
+		call = c.fetch(context.WithoutCancel(ctx))
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.info, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// fetch starts fetching the info in the background,
+// requires c.mu to be held
+func (c *infoCache) fetch(ctx context.Context) *fetchCall {
+	call := &fetchCall{done: make(chan struct{})}
+	c.inflight = call
+	generation := c.generation
+
+	go func() {
+		info, err := c.provider.GetInfo(ctx)
+
+		c.mu.Lock()
+		call.info, call.err = info, err
+		if c.inflight == call {
+			c.inflight = nil
+		}
+		if err != nil {
+			c.stats.Errors++
+		} else {
+			c.store(info, generation)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// store caches a fetched info unless the cache was invalidated
+// since the fetch started, requires c.mu to be held
+func (c *infoCache) store(info string, generation uint64) {
+	if generation != c.generation {
+		return
+	}
+	c.info = info
+	c.fetchedAt = c.now()
+	c.valid = true
+}
+
+// Invalidate drops the cached info, the next call fetches it again
+func (c *infoCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.valid = false
+	c.inflight = nil
+	c.generation++
+}
+
+// Stats returns a copy of the cache's counters
+func (c *infoCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
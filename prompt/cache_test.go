@@ -0,0 +1,257 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Context provider counting its calls
+type CountingContextProvider struct {
+	mu      sync.Mutex
+	calls   int
+	err     error
+	release chan struct{}
+}
+
+func (p *CountingContextProvider) GetInfo(ctx context.Context) (string, error) {
+	if p.release != nil {
+		select {
+		case <-p.release:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls++
+	if p.err != nil {
+		return "", p.err
+	}
+	return fmt.Sprintf("info %d", p.calls), nil
+}
+
+func (p *CountingContextProvider) GetTitle() string {
+	return "Counting"
+}
+
+func (p *CountingContextProvider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.calls
+}
+
+// Fake clock for TTL tests
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func newTestCache(provider ContextProvider, ttl time.Duration, opts ...CachingOption) (*FallibleCachingContextProvider, *FakeClock) {
+	clock := &FakeClock{now: time.Unix(0, 0)}
+	cache := NewFallibleCachingContextProvider(provider, ttl, opts...)
+	cache.now = clock.Now
+	return cache, clock
+}
+
+func TestFallibleCachingContextProvider_HitWithinTTL(t *testing.T) {
+	provider := &CountingContextProvider{}
+	cache, clock := newTestCache(provider, time.Minute)
+
+	info, err := cache.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "info 1", info)
+
+	clock.Advance(30 * time.Second)
+	info, err = cache.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "info 1", info)
+
+	assert.Equal(t, 1, provider.Calls())
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, cache.Stats())
+	assert.Equal(t, "Counting", cache.GetTitle())
+}
+
+func TestFallibleCachingContextProvider_MissAfterTTL(t *testing.T) {
+	provider := &CountingContextProvider{}
+	cache, clock := newTestCache(provider, time.Minute)
+
+	_, _ = cache.GetInfo(context.Background())
+	clock.Advance(time.Minute)
+	info, err := cache.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "info 2", info)
+	assert.Equal(t, CacheStats{Misses: 2}, cache.Stats())
+}
+
+func TestFallibleCachingContextProvider_Invalidate(t *testing.T) {
+	provider := &CountingContextProvider{}
+	cache, _ := newTestCache(provider, time.Hour)
+
+	_, _ = cache.GetInfo(context.Background())
+	cache.Invalidate()
+	info, err := cache.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "info 2", info)
+}
+
+func TestFallibleCachingContextProvider_ErrorsAreNotCached(t *testing.T) {
+	provider := &CountingContextProvider{err: errors.New("boom")}
+	cache, _ := newTestCache(provider, time.Hour)
+
+	_, err := cache.GetInfo(context.Background())
+	assert.Error(t, err)
+	_, err = cache.GetInfo(context.Background())
+	assert.Error(t, err)
+
+	assert.Equal(t, 2, provider.Calls())
+	assert.Equal(t, uint64(2), cache.Stats().Errors)
+}
+
+func TestFallibleCachingContextProvider_StaleWhileRevalidate(t *testing.T) {
+	provider := &CountingContextProvider{}
+	cache, clock := newTestCache(provider, time.Minute, WithStaleWhileRevalidate(time.Minute))
+
+	_, _ = cache.GetInfo(context.Background())
+	clock.Advance(90 * time.Second)
+
+	// The stale info is served right away and refreshed in the background
+	info, err := cache.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "info 1", info)
+	assert.Eventually(t, func() bool {
+		info, _ := cache.GetInfo(context.Background())
+		return info == "info 2"
+	}, time.Second, time.Millisecond)
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(1), stats.Refreshes)
+	assert.GreaterOrEqual(t, stats.StaleHits, uint64(1))
+
+	// Past the stale window the info is fetched in the foreground
+	clock.Advance(3 * time.Minute)
+	info, err = cache.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "info 3", info)
+}
+
+func TestFallibleCachingContextProvider_InGenerator(t *testing.T) {
+	provider := &CountingContextProvider{}
+	cache := NewFallibleCachingContextProvider(provider, time.Hour)
+	spg := NewSystemPromptGenerator(WithFallibleContextProvider("cached", cache))
+
+	for range 3 {
+		prompt, err := spg.GeneratePrompt(context.Background())
+		assert.NoError(t, err)
+		assert.Contains(t, prompt, "- info 1")
+	}
+	assert.Equal(t, 1, provider.Calls())
+}
+
+func TestFallibleCachingContextProvider_SharesConcurrentMisses(t *testing.T) {
+	provider := &CountingContextProvider{release: make(chan struct{})}
+	cache, _ := newTestCache(provider, time.Hour)
+
+	var wg sync.WaitGroup
+	infos := make([]string, 10)
+	for i := range infos {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			infos[i], _ = cache.GetInfo(context.Background())
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		stats := cache.Stats()
+		return stats.Misses+stats.Shared == 10
+	}, time.Second, time.Millisecond)
+	close(provider.release)
+	wg.Wait()
+
+	assert.Equal(t, 1, provider.Calls())
+	for _, info := range infos {
+		assert.Equal(t, "info 1", info)
+	}
+	assert.Equal(t, CacheStats{Misses: 1, Shared: 9}, cache.Stats())
+}
+
+func TestFallibleCachingContextProvider_WaitingCallerCanCancel(t *testing.T) {
+	provider := &CountingContextProvider{release: make(chan struct{})}
+	defer close(provider.release)
+	cache, _ := newTestCache(provider, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := cache.GetInfo(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFallibleCachingContextProvider_CancelledCallerDoesNotFailOthers(t *testing.T) {
+	provider := &CountingContextProvider{release: make(chan struct{})}
+	cache, _ := newTestCache(provider, time.Hour)
+
+	// The caller starting the fetch gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan error)
+	go func() {
+		_, err := cache.GetInfo(ctx)
+		started <- err
+	}()
+	assert.Eventually(t, func() bool { return cache.Stats().Misses == 1 }, time.Second, time.Millisecond)
+
+	waited := make(chan string)
+	go func() {
+		info, err := cache.GetInfo(context.Background())
+		assert.NoError(t, err)
+		waited <- info
+	}()
+	assert.Eventually(t, func() bool { return cache.Stats().Shared == 1 }, time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-started, context.Canceled)
+
+	// The other caller still gets the info
+	close(provider.release)
+	assert.Equal(t, "info 1", <-waited)
+	assert.Equal(t, uint64(0), cache.Stats().Errors)
+}
+
+func TestCachingContextProvider_BaseProvider(t *testing.T) {
+	provider := StaticContextProvider{Title: "Static", Info: "static info"}
+	cache := NewCachingContextProvider(provider, time.Hour)
+
+	// The cache is a SystemPromptContextProviderBase and registers like one
+	spg := NewSystemPromptGenerator()
+	spg.RegisterContextProvider("cached", cache)
+	registered, ok := spg.GetContextProvider("cached")
+	assert.True(t, ok)
+	assert.Same(t, cache, registered)
+
+	for range 3 {
+		prompt, err := spg.GeneratePrompt(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "# EXTRA INFORMATION AND CONTEXT\n# Static\n- static info", prompt)
+	}
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1}, cache.Stats())
+	assert.Equal(t, "Static", cache.GetTitle())
+}
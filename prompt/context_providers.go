@@ -0,0 +1,405 @@
+package prompt
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContextProvider is a context provider that gets the context of the prompt
+// generation and can fail, e.g. because it queries a database or a service
+type ContextProvider interface {
+	GetInfo(ctx context.Context) (string, error)
+	GetTitle() string
+}
+
+// userInputKey is the context key of the user input
+type userInputKey struct{}
+
+// ContextWithUserInput returns a context carrying the user input the prompt is
+// generated for. Agents set it so that providers can tailor their info to it
+func ContextWithUserInput(ctx context.Context, input any) context.Context {
+	return context.WithValue(ctx, userInputKey{}, input)
+}
+
+// UserInputFromContext returns the user input the prompt is generated for
+func UserInputFromContext(ctx context.Context) (any, bool) {
+	input := ctx.Value(userInputKey{})
+	return input, input != nil
+}
+
+// FailurePolicy decides what happens to the prompt when a context provider fails
+type FailurePolicy int
+
+const (
+	// FailurePolicyFail fails the prompt generation
+	FailurePolicyFail FailurePolicy = iota
+	// FailurePolicySkip leaves the provider's section out of the prompt
+	FailurePolicySkip
+	// FailurePolicyUseCached uses the provider's last successful info,
+	// the section is left out if there is none yet
+	FailurePolicyUseCached
+)
+
+// ContextProviderError is returned when a context provider
+// fails and its failure policy is FailurePolicyFail
+type ContextProviderError struct {
+	Name string
+	Err  error
+}
+
+func (e *ContextProviderError) Error() string {
+	return fmt.Sprintf("context provider '%s' failed: %v", e.Name, e.Err)
+}
+
+func (e *ContextProviderError) Unwrap() error {
+	return e.Err
+}
+
+// AdaptContextProvider turns a SystemPromptContextProviderBase
+// into a ContextProvider that never fails
+func AdaptContextProvider(provider SystemPromptContextProviderBase) ContextProvider {
+	return contextProviderAdapter{provider: provider}
+}
+
+// contextProviderAdapter adapts a SystemPromptContextProviderBase
+type contextProviderAdapter struct {
+	provider SystemPromptContextProviderBase
+}
+
+func (a contextProviderAdapter) GetInfo(ctx context.Context) (string, error) {
+	return a.provider.GetInfo(), nil
+}
+
+func (a contextProviderAdapter) GetTitle() string {
+	return a.provider.GetTitle()
+}
+
+// ContextProviderEntry is a context provider registered under a name.
+// Providers with a higher priority are rendered first, providers with
+// the same priority in the order they were registered. A zero Timeout
+// and a nil FailurePolicy fall back to the generator's defaults
+type ContextProviderEntry struct {
+	Name          string
+	Priority      int
+	Timeout       time.Duration
+	FailurePolicy *FailurePolicy
+	// MaxShare is the largest share of the token budget the provider's
+	// info may take, between 0 and 1. 0 means no limit
+	MaxShare float64
+	// Provider is the provider as it was registered, FallibleProvider is
+	// set instead for providers registered as fallible
+	Provider         SystemPromptContextProviderBase
+	FallibleProvider ContextProvider
+}
+
+// Option type for registering context providers
+type ContextProviderOption func(*ContextProviderEntry)
+
+// registeredContextProvider is a ContextProviderEntry together
+// with its registration sequence number and last successful info
+type registeredContextProvider struct {
+	ContextProviderEntry
+	seq   uint64
+	cache *cachedInfo
+}
+
+// provider returns the registered provider as a ContextProvider
+func (r registeredContextProvider) provider() ContextProvider {
+	if r.FallibleProvider != nil {
+		return r.FallibleProvider
+	}
+	return AdaptContextProvider(r.Provider)
+}
+
+// cachedInfo holds the last successful info of a provider
+type cachedInfo struct {
+	mu    sync.Mutex
+	info  string
+	valid bool
+}
+
+// ContextSection is the fetched info of a context provider
+type ContextSection struct {
+	Name  string
+	Title string
+	Info  string
+}
+
+// Functional option to set the priority of a context provider, defaults to 0
+func WithPriority(priority int) ContextProviderOption {
+	return func(entry *ContextProviderEntry) {
+		entry.Priority = priority
+	}
+}
+
+// Functional option to set how long fetching a context provider may take
+func WithTimeout(timeout time.Duration) ContextProviderOption {
+	return func(entry *ContextProviderEntry) {
+		entry.Timeout = timeout
+	}
+}
+
+// Functional option to set what happens when a context provider fails
+func WithFailurePolicy(policy FailurePolicy) ContextProviderOption {
+	return func(entry *ContextProviderEntry) {
+		entry.FailurePolicy = &policy
+	}
+}
+
+// Funtions to set the default timeout for fetching context providers, 0 means none
+func WithContextProviderTimeout(timeout time.Duration) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		spg.providerTimeout = timeout
+	}
+}
+
+// Funtions to set the default failure policy of context providers
+func WithDefaultFailurePolicy(policy FailurePolicy) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		spg.failurePolicy = policy
+	}
+}
+
+// Funtions to add an optional context provider (runtime input) to the system prompt
+func WithContextProvider(name string, provider SystemPromptContextProviderBase, opts ...ContextProviderOption) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		spg.registerContextProvider(ContextProviderEntry{Name: name, Provider: provider}, opts...)
+	}
+}
+
+// Funtions to add an optional fallible context provider (runtime input) to the system prompt
+func WithFallibleContextProvider(name string, provider ContextProvider, opts ...ContextProviderOption) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		spg.registerContextProvider(ContextProviderEntry{Name: name, FallibleProvider: provider}, opts...)
+	}
+}
+
+// Funtions to add optional context providers (runtime input) to the system prompt.
+// Maps have no order, so the providers are registered sorted by name
+func WithContextProviders(contextProviders map[string]SystemPromptContextProviderBase) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		for _, name := range slices.Sorted(maps.Keys(contextProviders)) {
+			spg.registerContextProvider(ContextProviderEntry{Name: name, Provider: contextProviders[name]})
+		}
+	}
+}
+
+// ContextProviders returns the registered context providers in the order
+// they are rendered into the prompt
+func (spg *SystemPromptGenerator) ContextProviders() []ContextProviderEntry {
+	spg.mu.RLock()
+	defer spg.mu.RUnlock()
+
+	entries := make([]ContextProviderEntry, len(spg.contextProviders))
+	for i, registered := range spg.contextProviders {
+		entries[i] = registered.ContextProviderEntry
+	}
+	return entries
+}
+
+// GetContextProvider returns the context provider registered under the given
+// name. Fallible providers are returned by GetFallibleContextProvider instead
+func (spg *SystemPromptGenerator) GetContextProvider(name string) (SystemPromptContextProviderBase, bool) {
+	spg.mu.RLock()
+	defer spg.mu.RUnlock()
+
+	i := spg.indexOfContextProvider(name)
+	if i == -1 || spg.contextProviders[i].Provider == nil {
+		return nil, false
+	}
+	return spg.contextProviders[i].Provider, true
+}
+
+// GetFallibleContextProvider returns the context provider registered under
+// the given name as a ContextProvider, whether it was registered as fallible or not
+func (spg *SystemPromptGenerator) GetFallibleContextProvider(name string) (ContextProvider, bool) {
+	spg.mu.RLock()
+	defer spg.mu.RUnlock()
+
+	i := spg.indexOfContextProvider(name)
+	if i == -1 {
+		return nil, false
+	}
+	return spg.contextProviders[i].provider(), true
+}
+
+// RegisterContextProvider adds a context provider under the given name.
+// Registering a name again replaces the provider and its options
+// but keeps its original registration order
+func (spg *SystemPromptGenerator) RegisterContextProvider(name string, provider SystemPromptContextProviderBase, opts ...ContextProviderOption) {
+	spg.mu.Lock()
+	defer spg.mu.Unlock()
+
+	spg.registerContextProvider(ContextProviderEntry{Name: name, Provider: provider}, opts...)
+}
+
+// RegisterFallibleContextProvider adds a fallible context provider under the
+// given name, behaving like RegisterContextProvider otherwise
+func (spg *SystemPromptGenerator) RegisterFallibleContextProvider(name string, provider ContextProvider, opts ...ContextProviderOption) {
+	spg.mu.Lock()
+	defer spg.mu.Unlock()
+
+	spg.registerContextProvider(ContextProviderEntry{Name: name, FallibleProvider: provider}, opts...)
+}
+
+// UnregisterContextProvider removes the context provider with the given name
+func (spg *SystemPromptGenerator) UnregisterContextProvider(name string) {
+	spg.mu.Lock()
+	defer spg.mu.Unlock()
+
+	if i := spg.indexOfContextProvider(name); i != -1 {
+		spg.contextProviders = slices.Delete(spg.contextProviders, i, i+1)
+	}
+}
+
+// registerContextProvider registers the entry's provider under its name,
+// requires spg.mu to be held
+func (spg *SystemPromptGenerator) registerContextProvider(entry ContextProviderEntry, opts ...ContextProviderOption) {
+	for _, opt := range opts {
+		opt(&entry)
+	}
+
+	if i := spg.indexOfContextProvider(entry.Name); i != -1 {
+		spg.contextProviders[i].ContextProviderEntry = entry
+		spg.contextProviders[i].cache = &cachedInfo{}
+	} else {
+		spg.contextProviders = append(spg.contextProviders, registeredContextProvider{
+			ContextProviderEntry: entry,
+			seq:                  spg.nextSeq,
+			cache:                &cachedInfo{},
+		})
+		spg.nextSeq++
+	}
+
+	slices.SortFunc(spg.contextProviders, func(a, b registeredContextProvider) int {
+		if a.Priority != b.Priority {
+			return cmp.Compare(b.Priority, a.Priority)
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+}
+
+// indexOfContextProvider returns the position of the named provider
+// or -1, requires spg.mu to be held
+func (spg *SystemPromptGenerator) indexOfContextProvider(name string) int {
+	return slices.IndexFunc(spg.contextProviders, func(registered registeredContextProvider) bool {
+		return registered.Name == name
+	})
+}
+
+// resolveContextProviders fetches all providers concurrently, each with its
+// own timeout, and applies the failure policies. The results keep the order
+// of the providers, failed providers that are skipped and providers without
+// info are left out
+func (spg *SystemPromptGenerator) resolveContextProviders(ctx context.Context, providers []registeredContextProvider) ([]ContextSection, error) {
+	type result struct {
+		info string
+		err  error
+	}
+	results := make([]result, len(providers))
+
+	var wg sync.WaitGroup
+	for i, registered := range providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			info, err := spg.fetchContextProvider(ctx, registered)
+			results[i] = result{info: info, err: err}
+		}()
+	}
+	wg.Wait()
+
+	resolved := []ContextSection{}
+	var errs []error
+	for i, registered := range providers {
+		info, err := results[i].info, results[i].err
+		if err != nil {
+			policy := spg.failurePolicy
+			if registered.FailurePolicy != nil {
+				policy = *registered.FailurePolicy
+			}
+			switch policy {
+			case FailurePolicySkip:
+				continue
+			case FailurePolicyUseCached:
+				cached, ok := registered.cache.get()
+				if !ok {
+					continue
+				}
+				info = cached
+			default:
+				errs = append(errs, &ContextProviderError{Name: registered.Name, Err: err})
+				continue
+			}
+		}
+		// A provider without info for this prompt, e.g. a retrieval that
+		// found nothing, is left out instead of rendering an empty section
+		if strings.TrimSpace(info) == "" {
+			continue
+		}
+		resolved = append(resolved, ContextSection{
+			Name:  registered.Name,
+			Title: registered.provider().GetTitle(),
+			Info:  info,
+		})
+	}
+	return resolved, errors.Join(errs...)
+}
+
+// fetchContextProvider gets the info of a provider, giving up once its
+// timeout expires even if the provider doesn't honor the context
+func (spg *SystemPromptGenerator) fetchContextProvider(ctx context.Context, registered registeredContextProvider) (string, error) {
+	timeout := spg.providerTimeout
+	if registered.Timeout > 0 {
+		timeout = registered.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		info string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		info, err := registered.provider().GetInfo(ctx)
+		done <- result{info: info, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err == nil {
+			registered.cache.set(res.info)
+		}
+		return res.info, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// get returns the cached info and whether there is one
+func (c *cachedInfo) get() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.info, c.valid
+}
+
+// set caches a successful info
+func (c *cachedInfo) set(info string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.info = info
+	c.valid = true
+}
@@ -0,0 +1,281 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Static context provider for ordering tests
+type StaticContextProvider struct {
+	Title string
+	Info  string
+}
+
+func (p StaticContextProvider) GetInfo() string {
+	return p.Info
+}
+
+func (p StaticContextProvider) GetTitle() string {
+	return p.Title
+}
+
+// Fallible context provider for failure policy tests
+type FallibleContextProvider struct {
+	Title string
+	Info  string
+	Err   error
+	Delay time.Duration
+}
+
+func (p *FallibleContextProvider) GetInfo(ctx context.Context) (string, error) {
+	select {
+	case <-time.After(p.Delay):
+		return p.Info, p.Err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (p *FallibleContextProvider) GetTitle() string {
+	return p.Title
+}
+
+func providerNames(spg *SystemPromptGenerator) []string {
+	names := []string{}
+	for _, entry := range spg.ContextProviders() {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+func TestRegisterContextProvider(t *testing.T) {
+	mockProvider := new(MockContextProvider)
+	spg := NewSystemPromptGenerator()
+
+	spg.RegisterContextProvider("mock", mockProvider)
+
+	provider, ok := spg.GetContextProvider("mock")
+	assert.True(t, ok)
+	assert.Equal(t, mockProvider, provider)
+}
+
+func TestGetFallibleContextProvider(t *testing.T) {
+	fallible := &FallibleContextProvider{Title: "Fallible", Info: "info"}
+	spg := NewSystemPromptGenerator(
+		WithContextProvider("static", StaticContextProvider{Title: "Static", Info: "static info"}),
+		WithFallibleContextProvider("fallible", fallible),
+	)
+
+	// Fallible providers are only returned as ContextProvider
+	_, ok := spg.GetContextProvider("fallible")
+	assert.False(t, ok)
+	provider, ok := spg.GetFallibleContextProvider("fallible")
+	assert.True(t, ok)
+	assert.Same(t, fallible, provider)
+
+	// Other providers are adapted
+	provider, ok = spg.GetFallibleContextProvider("static")
+	assert.True(t, ok)
+	info, err := provider.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "static info", info)
+
+	_, ok = spg.GetFallibleContextProvider("missing")
+	assert.False(t, ok)
+}
+
+func TestUnregisterContextProvider(t *testing.T) {
+	mockProvider := new(MockContextProvider)
+	spg := NewSystemPromptGenerator(WithContextProviders(map[string]SystemPromptContextProviderBase{
+		"mock": mockProvider,
+	}))
+
+	spg.UnregisterContextProvider("mock")
+
+	_, ok := spg.GetContextProvider("mock")
+	assert.False(t, ok)
+}
+
+func TestRegisterContextProvider_InsertionOrder(t *testing.T) {
+	spg := NewSystemPromptGenerator()
+	for _, name := range []string{"c", "a", "b"} {
+		spg.RegisterContextProvider(name, StaticContextProvider{Title: name})
+	}
+
+	assert.Equal(t, []string{"c", "a", "b"}, providerNames(spg))
+}
+
+func TestRegisterContextProvider_Priority(t *testing.T) {
+	spg := NewSystemPromptGenerator()
+	spg.RegisterContextProvider("low", StaticContextProvider{}, WithPriority(-1))
+	spg.RegisterContextProvider("default", StaticContextProvider{})
+	spg.RegisterContextProvider("high", StaticContextProvider{}, WithPriority(10))
+	spg.RegisterContextProvider("also-default", StaticContextProvider{})
+
+	assert.Equal(t, []string{"high", "default", "also-default", "low"}, providerNames(spg))
+}
+
+func TestRegisterContextProvider_ReplaceKeepsOrder(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithContextProvider("first", StaticContextProvider{Info: "old"}),
+		WithContextProvider("second", StaticContextProvider{}),
+	)
+
+	spg.RegisterContextProvider("first", StaticContextProvider{Info: "new"})
+
+	assert.Equal(t, []string{"first", "second"}, providerNames(spg))
+	provider, _ := spg.GetContextProvider("first")
+	assert.Equal(t, "new", provider.GetInfo())
+}
+
+func TestWithContextProviders_SortedByName(t *testing.T) {
+	spg := NewSystemPromptGenerator(WithContextProviders(map[string]SystemPromptContextProviderBase{
+		"zeta":  StaticContextProvider{},
+		"alpha": StaticContextProvider{},
+		"mu":    StaticContextProvider{},
+	}))
+
+	assert.Equal(t, []string{"alpha", "mu", "zeta"}, providerNames(spg))
+}
+
+func TestGeneratePrompt_Deterministic(t *testing.T) {
+	build := func() *SystemPromptGenerator {
+		providers := map[string]SystemPromptContextProviderBase{}
+		for i := range 20 {
+			providers[fmt.Sprintf("provider-%d", i)] = StaticContextProvider{
+				Title: fmt.Sprintf("Title %d", i),
+				Info:  fmt.Sprintf("Info %d", i),
+			}
+		}
+		return NewSystemPromptGenerator(
+			WithBackground([]string{"I am a helpful assistant."}),
+			WithContextProviders(providers),
+		)
+	}
+
+	expected, err := build().GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	for range 10 {
+		prompt, err := build().GeneratePrompt(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, expected, prompt)
+	}
+}
+
+func TestGeneratePrompt_ProviderOrder(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithContextProvider("second", StaticContextProvider{Title: "Second", Info: "2"}),
+		WithContextProvider("first", StaticContextProvider{Title: "First", Info: "1"}, WithPriority(1)),
+	)
+
+	expected := "# EXTRA INFORMATION AND CONTEXT\n# First\n- 1\n\n# Second\n- 2"
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, prompt)
+}
+
+func TestGeneratePrompt_SkipsEmptyInfo(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithContextProvider("empty", StaticContextProvider{Title: "Empty", Info: " "}),
+		WithFallibleContextProvider("ok", &FallibleContextProvider{Title: "Ok", Info: "fine"}),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "# EXTRA INFORMATION AND CONTEXT\n# Ok\n- fine", prompt)
+}
+
+func TestGeneratePrompt_FailurePolicyFail(t *testing.T) {
+	failing := &FallibleContextProvider{Title: "Failing", Err: errors.New("database down")}
+	spg := NewSystemPromptGenerator(WithFallibleContextProvider("failing", failing))
+
+	_, err := spg.GeneratePrompt(context.Background())
+
+	var providerErr *ContextProviderError
+	assert.ErrorAs(t, err, &providerErr)
+	assert.Equal(t, "failing", providerErr.Name)
+	assert.ErrorContains(t, err, "database down")
+}
+
+func TestGeneratePrompt_FailurePolicySkip(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithFallibleContextProvider("ok", &FallibleContextProvider{Title: "Ok", Info: "fine"}),
+		WithFallibleContextProvider("failing", &FallibleContextProvider{Title: "Failing", Err: errors.New("boom")},
+			WithFailurePolicy(FailurePolicySkip)),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "# EXTRA INFORMATION AND CONTEXT\n# Ok\n- fine", prompt)
+}
+
+func TestGeneratePrompt_FailurePolicyUseCached(t *testing.T) {
+	provider := &FallibleContextProvider{Title: "Flaky", Info: "cached info"}
+	spg := NewSystemPromptGenerator(WithDefaultFailurePolicy(FailurePolicyUseCached))
+	spg.RegisterFallibleContextProvider("flaky", provider)
+
+	_, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+
+	provider.Info = ""
+	provider.Err = errors.New("temporarily unavailable")
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, prompt, "- cached info")
+}
+
+func TestGeneratePrompt_FailurePolicyUseCachedWithoutCache(t *testing.T) {
+	provider := &FallibleContextProvider{Title: "Flaky", Err: errors.New("boom")}
+	spg := NewSystemPromptGenerator(
+		WithFallibleContextProvider("flaky", provider, WithFailurePolicy(FailurePolicyUseCached)),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, prompt)
+}
+
+func TestGeneratePrompt_ProviderTimeout(t *testing.T) {
+	slow := &FallibleContextProvider{Title: "Slow", Info: "too late", Delay: time.Second}
+	spg := NewSystemPromptGenerator(
+		WithContextProviderTimeout(time.Minute),
+		WithFallibleContextProvider("slow", slow, WithTimeout(10*time.Millisecond)),
+	)
+
+	_, err := spg.GeneratePrompt(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestGeneratePrompt_FetchesConcurrently(t *testing.T) {
+	spg := NewSystemPromptGenerator()
+	for i := range 10 {
+		spg.RegisterFallibleContextProvider(fmt.Sprintf("provider-%d", i), &FallibleContextProvider{
+			Title: fmt.Sprintf("Title %d", i),
+			Info:  fmt.Sprintf("Info %d", i),
+			Delay: 50 * time.Millisecond,
+		})
+	}
+
+	start := time.Now()
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+
+	// Results keep the registration order
+	assert.Less(t, strings.Index(prompt, "Info 0"), strings.Index(prompt, "Info 9"))
+}
+
+func TestContextWithUserInput(t *testing.T) {
+	_, ok := UserInputFromContext(context.Background())
+	assert.False(t, ok)
+
+	input, ok := UserInputFromContext(ContextWithUserInput(context.Background(), "Hello"))
+	assert.True(t, ok)
+	assert.Equal(t, "Hello", input)
+}
@@ -0,0 +1,93 @@
+package prompt
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Example is a typed input/output pair grounding the model's answers.
+// Input and Output should match the agent's input and output schemas
+type Example struct {
+	Input  any
+	Output any
+}
+
+// ExampleMode decides how examples are passed to the model
+type ExampleMode int
+
+const (
+	// ExamplesInPrompt renders the examples as json in the examples section
+	ExamplesInPrompt ExampleMode = iota
+	// ExamplesAsMessages leaves the examples out of the prompt, the agent
+	// sends them as user/assistant message pairs ahead of the history instead
+	ExamplesAsMessages
+)
+
+// SectionExamples is the name of the section the examples are rendered in.
+// Like the other built-in sections its content is filled in automatically
+const SectionExamples = "examples"
+
+// Funtions to add few-shot examples to the system prompt. An examples
+// section is appended to the sections unless there already is one
+func WithExamples(examples ...Example) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		spg.examples = append(spg.examples, examples...)
+		if spg.indexOfSection(SectionExamples) == -1 {
+			spg.sections = append(spg.sections, PromptSection{
+				Name:  SectionExamples,
+				Title: "EXAMPLES",
+				Style: SectionStyleParagraph,
+			})
+		}
+	}
+}
+
+// Funtions to set how examples are passed to the model, defaults to ExamplesInPrompt
+func WithExampleMode(mode ExampleMode) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		spg.exampleMode = mode
+	}
+}
+
+// Examples returns the few-shot examples
+func (spg *SystemPromptGenerator) Examples() []Example {
+	spg.mu.RLock()
+	defer spg.mu.RUnlock()
+
+	return slices.Clone(spg.examples)
+}
+
+// ExampleMode returns how examples are passed to the model
+func (spg *SystemPromptGenerator) ExampleMode() ExampleMode {
+	spg.mu.RLock()
+	defer spg.mu.RUnlock()
+
+	return spg.exampleMode
+}
+
+// renderExamples renders each example as an input and an output line
+// holding compact json, with a blank line between examples
+func renderExamples(examples []Example) []string {
+	lines := []string{}
+	for i, example := range examples {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines,
+			fmt.Sprintf("Input: %s", exampleJson(example.Input)),
+			fmt.Sprintf("Output: %s", exampleJson(example.Output)),
+		)
+	}
+	return lines
+}
+
+// exampleJson encodes an example value. Values that can't be encoded,
+// which agents reject when they are created, fall back to their default format
+func exampleJson(value any) string {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(jsonBytes)
+}
@@ -0,0 +1,71 @@
+package prompt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Typed example output
+type Answer struct {
+	Value int    `json:"value"`
+	Unit  string `json:"unit,omitempty"`
+}
+
+func TestWithExamples_InPrompt(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithBackground([]string{"I answer math questions."}),
+		WithExamples(
+			Example{Input: "What is 2+2?", Output: Answer{Value: 4}},
+			Example{Input: "How many meters in a km?", Output: Answer{Value: 1000, Unit: "m"}},
+		),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+
+	expected := "# IDENTITY and PURPOSE\n- I answer math questions.\n\n" +
+		"# EXAMPLES\n" +
+		"Input: \"What is 2+2?\"\n" +
+		"Output: {\"value\":4}\n" +
+		"\n" +
+		"Input: \"How many meters in a km?\"\n" +
+		"Output: {\"value\":1000,\"unit\":\"m\"}"
+	assert.Equal(t, expected, prompt)
+	assert.Equal(t, 2, len(spg.Examples()))
+}
+
+func TestWithExamples_AsMessages(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithExamples(Example{Input: "What is 2+2?", Output: Answer{Value: 4}}),
+		WithExampleMode(ExamplesAsMessages),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, prompt)
+	assert.Equal(t, ExamplesAsMessages, spg.ExampleMode())
+}
+
+func TestWithExamples_SingleSection(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithExamples(Example{Input: "a", Output: "b"}),
+		WithExamples(Example{Input: "c", Output: "d"}),
+	)
+
+	assert.Equal(t, []string{SectionBackground, SectionSteps, SectionOutputInstructions, SectionExamples}, sectionNames(spg))
+	assert.Equal(t, 2, len(spg.Examples()))
+}
+
+func TestWithExamples_CustomSectionPosition(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithSteps([]string{"Answer"}),
+		WithExamples(Example{Input: "a", Output: "b"}),
+		WithSectionOrder(SectionExamples),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "# EXAMPLES\nInput: \"a\"\nOutput: \"b\"\n\n# INTERNAL ASSISTANT STEPS\n- Answer", prompt)
+}
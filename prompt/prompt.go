@@ -1,14 +1,24 @@
 package prompt
 
 import (
+	"context"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
-// Struct type for prompt sections
+// Struct type for prompt sections. Sections are identified by
+// their name and rendered in the given style
 type PromptSection struct {
+	Name    string
 	Title   string
 	Content []string
+	Style   SectionStyle
+	// Language is the language of code block sections, e.g. "json"
+	Language string
 }
 
 // Option type for SystemPromptGenerator
@@ -20,12 +30,31 @@ type SystemPromptContextProviderBase interface {
 	GetTitle() string
 }
 
-// SystemPromptGenerator struct
+// SystemPromptGenerator struct. Context providers should be
+// managed through the generator's methods, which are safe for concurrent use
 type SystemPromptGenerator struct {
+	mu sync.RWMutex
+
 	Background         []string
 	Steps              []string
 	OutputInstructions []string
-	ContextProviders   map[string]SystemPromptContextProviderBase
+	// sections holds the built-in and custom sections in render order
+	sections    []PromptSection
+	examples    []Example
+	exampleMode ExampleMode
+	// contextProviders is kept ordered by priority and registration order
+	contextProviders []registeredContextProvider
+	// nextSeq is the registration sequence number of the next new provider
+	nextSeq uint64
+	// providerTimeout and failurePolicy are the defaults for all providers
+	providerTimeout time.Duration
+	failurePolicy   FailurePolicy
+	// renderer renders the prompt, nil means MarkdownRenderer
+	renderer  PromptRenderer
+	variables map[string]any
+	// tokenBudget limits the estimated tokens of the prompt, 0 means no limit
+	tokenBudget    int
+	tokenEstimator TokenEstimator
 }
 
 // Constructor for SystemPromptGenerator
@@ -35,7 +64,7 @@ func NewSystemPromptGenerator(ops ...SytemPromptGeneratorOption) *SystemPromptGe
 		Background:         []string{},
 		Steps:              []string{},
 		OutputInstructions: []string{},
-		ContextProviders:   map[string]SystemPromptContextProviderBase{},
+		sections:           slices.Clone(defaultSections),
 	}
 	for _, opt := range ops {
 		opt(spg)
@@ -43,6 +72,41 @@ func NewSystemPromptGenerator(ops ...SytemPromptGeneratorOption) *SystemPromptGe
 	return spg
 }
 
+// Clone returns a copy of the generator that can be changed without affecting
+// the original. Registered context providers are shared, the info cached for
+// FailurePolicyUseCached is copied
+func (spg *SystemPromptGenerator) Clone() *SystemPromptGenerator {
+	spg.mu.RLock()
+	defer spg.mu.RUnlock()
+
+	clone := &SystemPromptGenerator{
+		Background:         slices.Clone(spg.Background),
+		Steps:              slices.Clone(spg.Steps),
+		OutputInstructions: slices.Clone(spg.OutputInstructions),
+		sections:           make([]PromptSection, len(spg.sections)),
+		examples:           slices.Clone(spg.examples),
+		exampleMode:        spg.exampleMode,
+		contextProviders:   make([]registeredContextProvider, len(spg.contextProviders)),
+		nextSeq:            spg.nextSeq,
+		providerTimeout:    spg.providerTimeout,
+		failurePolicy:      spg.failurePolicy,
+		renderer:           spg.renderer,
+		variables:          maps.Clone(spg.variables),
+		tokenBudget:        spg.tokenBudget,
+		tokenEstimator:     spg.tokenEstimator,
+	}
+	for i, section := range spg.sections {
+		section.Content = slices.Clone(section.Content)
+		clone.sections[i] = section
+	}
+	for i, registered := range spg.contextProviders {
+		info, valid := registered.cache.get()
+		registered.cache = &cachedInfo{info: info, valid: valid}
+		clone.contextProviders[i] = registered
+	}
+	return clone
+}
+
 // Funtions to add a optional background to the system prompt
 func WithBackground(background []string) SytemPromptGeneratorOption {
 	return func(spg *SystemPromptGenerator) {
@@ -64,54 +128,66 @@ func WithOutputInstructions(outputInstructions []string) SytemPromptGeneratorOpt
 	}
 }
 
-// Funtions to add optional context providers (runtime input) to the system prompt
-func WithContextProviders(contextProviders map[string]SystemPromptContextProviderBase) SytemPromptGeneratorOption {
-	return func(spg *SystemPromptGenerator) {
-		spg.ContextProviders = contextProviders
-	}
+// GeneratePrompt function to generate the agents system prompt
+// based on the available background, steps and output instructions.
+// Context providers are fetched concurrently, an error is returned
+// if a provider fails and its failure policy is FailurePolicyFail
+func (spg *SystemPromptGenerator) GeneratePrompt(ctx context.Context) (string, error) {
+	return spg.GeneratePromptWith(ctx, nil)
 }
 
-// GeneratePrompt function to generate the agents system prompt
-// based on the available background, steps and output instructions
-func (spg *SystemPromptGenerator) GeneratePrompt() string {
-	sections := []PromptSection{
-		{
-			Title:   "IDENTITY and PURPOSE",
-			Content: spg.Background,
-		},
-		{
-			Title:   "INTERNAL ASSISTANT STEPS",
-			Content: spg.Steps,
-		},
-		{
-			Title:   "OUTPUT INSTRUCTIONS",
-			Content: spg.OutputInstructions,
-		},
-	}
+// GeneratePromptWith generates the system prompt like GeneratePrompt but
+// with the given renderer, a nil renderer uses the generator's one
+func (spg *SystemPromptGenerator) GeneratePromptWith(ctx context.Context, renderer PromptRenderer) (string, error) {
+	prompt, _, err := spg.GeneratePromptWithReport(ctx, renderer)
+	return prompt, err
+}
 
-	promptParts := []string{}
-
-	// Add different sections with background and steps and output instructions
-	for _, section := range sections {
-		if len(section.Content) > 0 {
-			promptParts = append(promptParts, fmt.Sprintf("# %s", section.Title))
-			for _, content := range section.Content {
-				promptParts = append(promptParts, fmt.Sprintf("- %s", content))
-			}
-			promptParts = append(promptParts, "")
-		}
+// GeneratePromptWithReport generates the system prompt like GeneratePromptWith
+// and reports its estimated size and the context provider info that was cut
+// to fit the token budget
+func (spg *SystemPromptGenerator) GeneratePromptWithReport(ctx context.Context, renderer PromptRenderer) (string, PromptReport, error) {
+	// Copy the configuration so providers are fetched without holding the lock
+	spg.mu.RLock()
+	data := PromptData{
+		Background:         slices.Clone(spg.Background),
+		Steps:              slices.Clone(spg.Steps),
+		OutputInstructions: slices.Clone(spg.OutputInstructions),
+		Sections:           spg.resolveSections(),
+		Examples:           slices.Clone(spg.examples),
+		Vars:               maps.Clone(spg.variables),
 	}
+	if renderer == nil {
+		renderer = spg.renderer
+	}
+	providers := slices.Clone(spg.contextProviders)
+	budget := tokenBudget{limit: spg.tokenBudget, estimate: spg.tokenEstimator}
+	spg.mu.RUnlock()
 
-	// Add context providers to prompt
-	if len(spg.ContextProviders) > 0 {
-		promptParts = append(promptParts, "# EXTRA INFORMATION AND CONTEXT")
-		for _, provider := range spg.ContextProviders {
-			promptParts = append(promptParts, fmt.Sprintf("# %s", provider.GetTitle()))
-			promptParts = append(promptParts, fmt.Sprintf("- %s", provider.GetInfo()))
-			promptParts = append(promptParts, "")
-		}
+	contexts, err := spg.resolveContextProviders(ctx, providers)
+	if err != nil {
+		return "", PromptReport{}, err
 	}
+	data.ContextProviders = contexts
 
-	return strings.TrimSpace(strings.Join(promptParts, "\n"))
+	if renderer == nil {
+		renderer = MarkdownRenderer{}
+	}
+	if budget.estimate == nil {
+		budget.estimate = EstimateTokens
+	}
+	prompt, report, err := budget.fit(renderer, data, providers)
+	if err != nil {
+		return "", PromptReport{}, fmt.Errorf("failed to render prompt: %w", err)
+	}
+	return prompt, report, nil
+}
 
+// renderPrompt renders the prompt and trims surrounding whitespace
+func renderPrompt(renderer PromptRenderer, data PromptData) (string, error) {
+	prompt, err := renderer.Render(data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(prompt), nil
 }
@@ -1,6 +1,7 @@
 package prompt
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,7 +29,7 @@ func TestNewSystemPromptGenerator_Default(t *testing.T) {
 	assert.Empty(t, spg.Background)
 	assert.Empty(t, spg.Steps)
 	assert.Empty(t, spg.OutputInstructions)
-	assert.Empty(t, spg.ContextProviders)
+	assert.Empty(t, spg.ContextProviders())
 }
 
 func TestWithBackground(t *testing.T) {
@@ -59,7 +60,32 @@ func TestWithContextProviders(t *testing.T) {
 	}
 	spg := NewSystemPromptGenerator(WithContextProviders(contextMap))
 
-	assert.Equal(t, contextMap, spg.ContextProviders)
+	assert.Equal(t, []ContextProviderEntry{{Name: "provider1", Provider: mockProvider}}, spg.ContextProviders())
+}
+
+func TestClone(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithBackground([]string{"Be helpful."}),
+		WithSection(PromptSection{Name: "rules", Title: "RULES", Content: []string{"Be brief."}}),
+		WithContextProvider("static", StaticContextProvider{Title: "Static", Info: "info"}),
+	)
+	clone := spg.Clone()
+
+	expected, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	prompt, err := clone.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, prompt)
+
+	// Changes to the clone don't affect the original
+	clone.Background[0] = "Be rude."
+	assert.NoError(t, clone.ReplaceSection(PromptSection{Name: "rules", Title: "RULES", Content: []string{"Be long."}}))
+	clone.RegisterContextProvider("other", StaticContextProvider{Title: "Other", Info: "other"})
+
+	prompt, err = spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, prompt)
+	assert.Equal(t, []ContextProviderEntry{{Name: "static", Provider: StaticContextProvider{Title: "Static", Info: "info"}}}, spg.ContextProviders())
 }
 
 func TestGeneratePrompt_AllSections(t *testing.T) {
@@ -76,7 +102,8 @@ func TestGeneratePrompt_AllSections(t *testing.T) {
 		}),
 	)
 
-	prompt := spg.GeneratePrompt()
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
 
 	assert.Contains(t, prompt, "# IDENTITY and PURPOSE")
 	assert.Contains(t, prompt, "- I am a helpful assistant.")
@@ -0,0 +1,177 @@
+package prompt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Metadata keys under which agents record the prompt an assistant message
+// was generated with
+const (
+	MetadataPromptName    = "prompt_name"
+	MetadataPromptVersion = "prompt_version"
+	MetadataPromptHash    = "prompt_hash"
+)
+
+var (
+	// ErrPromptNotFound is returned for prompt names and versions that aren't registered
+	ErrPromptNotFound = errors.New("prompt not found")
+	// ErrPromptExists is returned when registering a version that is already registered
+	ErrPromptExists = errors.New("prompt version already registered")
+)
+
+// PromptVersion is a system prompt generator registered under a name and version
+type PromptVersion struct {
+	Name      string
+	Version   string
+	Generator *SystemPromptGenerator
+}
+
+// RenderedPrompt is a prompt rendered from a registered version,
+// Hash identifies the exact text that was sent to the model
+type RenderedPrompt struct {
+	Name    string
+	Version string
+	Hash    string
+	Prompt  string
+}
+
+// PromptRegistry stores named, versioned system prompt generators.
+// Registered versions can't be replaced, a changed prompt needs a new version.
+// It is safe for concurrent use
+type PromptRegistry struct {
+	mu sync.RWMutex
+	// prompts holds the versions of each name in registration order
+	prompts map[string][]PromptVersion
+}
+
+// Constructor for an empty PromptRegistry
+func NewPromptRegistry() *PromptRegistry {
+	return &PromptRegistry{prompts: map[string][]PromptVersion{}}
+}
+
+// HashPrompt returns the hex encoded sha256 hash of a rendered prompt
+func HashPrompt(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// Register adds a copy of the generator under the name and version,
+// later changes to the generator don't affect the registered version
+func (r *PromptRegistry) Register(name string, version string, spg *SystemPromptGenerator) error {
+	if name == "" || version == "" {
+		return errors.New("prompt name and version cannot be empty")
+	}
+	if spg == nil {
+		return errors.New("system prompt generator cannot be nil")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.prompts[name] {
+		if existing.Version == version {
+			return fmt.Errorf("%w: %s@%s", ErrPromptExists, name, version)
+		}
+	}
+	r.prompts[name] = append(r.prompts[name], PromptVersion{Name: name, Version: version, Generator: spg.Clone()})
+	return nil
+}
+
+// Get returns a copy of the generator registered under the name and version,
+// so callers such as agents can change it without affecting the registry.
+// An empty version returns the latest registered version
+func (r *PromptRegistry) Get(name string, version string) (PromptVersion, error) {
+	registered, err := r.get(name, version)
+	if err != nil {
+		return PromptVersion{}, err
+	}
+	registered.Generator = registered.Generator.Clone()
+	return registered, nil
+}
+
+// get returns the version as it is stored in the registry
+func (r *PromptRegistry) get(name string, version string) (PromptVersion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := r.prompts[name]
+	if len(versions) == 0 {
+		return PromptVersion{}, fmt.Errorf("%w: %s", ErrPromptNotFound, name)
+	}
+	if version == "" {
+		return versions[len(versions)-1], nil
+	}
+	for _, existing := range versions {
+		if existing.Version == version {
+			return existing, nil
+		}
+	}
+	return PromptVersion{}, fmt.Errorf("%w: %s@%s", ErrPromptNotFound, name, version)
+}
+
+// Versions returns the registered versions of a name in registration order
+func (r *PromptRegistry) Versions(name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := []string{}
+	for _, existing := range r.prompts[name] {
+		versions = append(versions, existing.Version)
+	}
+	return versions
+}
+
+// Names returns the registered prompt names
+func (r *PromptRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.prompts))
+	for name := range r.prompts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Render generates the prompt of a registered version and hashes it.
+// An empty version renders the latest registered version
+func (r *PromptRegistry) Render(ctx context.Context, name string, version string) (RenderedPrompt, error) {
+	registered, err := r.get(name, version)
+	if err != nil {
+		return RenderedPrompt{}, err
+	}
+	return registered.Render(ctx, nil)
+}
+
+// Render generates the prompt with the renderer, nil uses the generator's
+// renderer, and hashes it
+func (pv PromptVersion) Render(ctx context.Context, renderer PromptRenderer) (RenderedPrompt, error) {
+	prompt, err := pv.Generator.GeneratePromptWith(ctx, renderer)
+	if err != nil {
+		return RenderedPrompt{}, err
+	}
+	return RenderedPrompt{
+		Name:    pv.Name,
+		Version: pv.Version,
+		Hash:    HashPrompt(prompt),
+		Prompt:  prompt,
+	}, nil
+}
+
+// Metadata returns the prompt's name, version and hash as message metadata.
+// Name and version are left out for prompts that weren't registered
+func (rp RenderedPrompt) Metadata() map[string]string {
+	metadata := map[string]string{MetadataPromptHash: rp.Hash}
+	if rp.Name != "" {
+		metadata[MetadataPromptName] = rp.Name
+		metadata[MetadataPromptVersion] = rp.Version
+	}
+	return metadata
+}
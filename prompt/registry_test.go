@@ -0,0 +1,105 @@
+package prompt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromptRegistry_RegisterAndGet(t *testing.T) {
+	registry := NewPromptRegistry()
+	v1 := NewSystemPromptGenerator(WithBackground([]string{"v1"}))
+	v2 := NewSystemPromptGenerator(WithBackground([]string{"v2"}))
+	assert.NoError(t, registry.Register("support", "v1", v1))
+	assert.NoError(t, registry.Register("support", "v2", v2))
+	assert.NoError(t, registry.Register("billing", "v1", v1))
+
+	registered, err := registry.Get("support", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v1"}, registered.Generator.Background)
+
+	// An empty version returns the latest one
+	latest, err := registry.Get("support", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", latest.Version)
+	assert.Equal(t, []string{"v2"}, latest.Generator.Background)
+
+	assert.Equal(t, []string{"v1", "v2"}, registry.Versions("support"))
+	assert.Equal(t, []string{}, registry.Versions("missing"))
+	assert.Equal(t, []string{"billing", "support"}, registry.Names())
+}
+
+func TestPromptRegistry_VersionsAreCopies(t *testing.T) {
+	registry := NewPromptRegistry()
+	spg := NewSystemPromptGenerator(WithBackground([]string{"Be helpful."}))
+	assert.NoError(t, registry.Register("support", "v1", spg))
+
+	// Changing the registered generator doesn't change the version
+	spg.Background[0] = "Be rude."
+	spg.RegisterContextProvider("extra", StaticContextProvider{Title: "Extra", Info: "info"})
+
+	// Neither does changing a generator returned by Get
+	registered, err := registry.Get("support", "v1")
+	assert.NoError(t, err)
+	assert.NotSame(t, spg, registered.Generator)
+	registered.Generator.RegisterContextProvider("other", StaticContextProvider{Title: "Other", Info: "info"})
+
+	rendered, err := registry.Render(context.Background(), "support", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, "# IDENTITY and PURPOSE\n- Be helpful.", rendered.Prompt)
+}
+
+func TestPromptRegistry_Errors(t *testing.T) {
+	registry := NewPromptRegistry()
+	assert.NoError(t, registry.Register("support", "v1", NewSystemPromptGenerator()))
+
+	err := registry.Register("support", "v1", NewSystemPromptGenerator())
+	assert.ErrorIs(t, err, ErrPromptExists)
+	assert.Error(t, registry.Register("", "v1", NewSystemPromptGenerator()))
+	assert.Error(t, registry.Register("support", "", NewSystemPromptGenerator()))
+	assert.Error(t, registry.Register("support", "v2", nil))
+
+	_, err = registry.Get("support", "v2")
+	assert.ErrorIs(t, err, ErrPromptNotFound)
+	_, err = registry.Get("missing", "")
+	assert.ErrorIs(t, err, ErrPromptNotFound)
+}
+
+func TestPromptRegistry_Render(t *testing.T) {
+	registry := NewPromptRegistry()
+	assert.NoError(t, registry.Register("support", "v1", NewSystemPromptGenerator(WithBackground([]string{"Be helpful."}))))
+
+	rendered, err := registry.Render(context.Background(), "support", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, "# IDENTITY and PURPOSE\n- Be helpful.", rendered.Prompt)
+	assert.Equal(t, HashPrompt(rendered.Prompt), rendered.Hash)
+	assert.Equal(t, map[string]string{
+		MetadataPromptName:    "support",
+		MetadataPromptVersion: "v1",
+		MetadataPromptHash:    rendered.Hash,
+	}, rendered.Metadata())
+
+	// Rendering the same configuration again gives the same hash
+	again, err := registry.Render(context.Background(), "support", "")
+	assert.NoError(t, err)
+	assert.Equal(t, rendered.Hash, again.Hash)
+
+	// A different renderer produces a different prompt and hash
+	registered, err := registry.Get("support", "v1")
+	assert.NoError(t, err)
+	xml, err := registered.Render(context.Background(), XMLRenderer{})
+	assert.NoError(t, err)
+	assert.NotEqual(t, rendered.Hash, xml.Hash)
+}
+
+func TestHashPrompt(t *testing.T) {
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", HashPrompt(""))
+	assert.Equal(t, 64, len(HashPrompt("prompt")))
+	assert.NotEqual(t, HashPrompt("a"), HashPrompt("b"))
+}
+
+func TestRenderedPrompt_MetadataWithoutName(t *testing.T) {
+	rendered := RenderedPrompt{Hash: "abc"}
+	assert.Equal(t, map[string]string{MetadataPromptHash: "abc"}, rendered.Metadata())
+}
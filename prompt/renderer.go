@@ -0,0 +1,133 @@
+package prompt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PromptRenderer turns the sections and fetched context providers
+// into the system prompt. Surrounding whitespace is trimmed afterwards
+type PromptRenderer interface {
+	Render(data PromptData) (string, error)
+}
+
+// Funtions to set the renderer of the system prompt, defaults to MarkdownRenderer
+func WithRenderer(renderer PromptRenderer) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		spg.renderer = renderer
+	}
+}
+
+// MarkdownRenderer renders the prompt with the DefaultPromptTemplate
+type MarkdownRenderer struct{}
+
+// Render executes the default template
+func (r MarkdownRenderer) Render(data PromptData) (string, error) {
+	return TemplateRenderer{Template: defaultPromptTemplate}.Render(data)
+}
+
+// XMLRenderer wraps every section in a tag named after the section,
+// e.g. <background>...</background>, and the context providers in
+// <context_provider> tags inside a <context> tag
+type XMLRenderer struct{}
+
+// Render renders the prompt as xml tags
+func (r XMLRenderer) Render(data PromptData) (string, error) {
+	var prompt strings.Builder
+	for _, section := range data.Sections {
+		if len(section.Content) == 0 {
+			continue
+		}
+		tag := xmlTagName(section.Name)
+		fmt.Fprintf(&prompt, "<%s>\n%s\n</%s>\n\n", tag, escapeXMLText(section.RenderContent()), tag)
+	}
+
+	if len(data.ContextProviders) > 0 {
+		prompt.WriteString("<context>\n")
+		for _, provider := range data.ContextProviders {
+			fmt.Fprintf(&prompt, "<context_provider name=\"%s\" title=\"%s\">\n%s\n</context_provider>\n",
+				escapeXMLAttribute(provider.Name), escapeXMLAttribute(provider.Title), escapeXMLText(provider.Info))
+		}
+		prompt.WriteString("</context>\n")
+	}
+	return prompt.String(), nil
+}
+
+// xmlTagName turns a section name into a valid xml tag name
+func xmlTagName(name string) string {
+	tag := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, name)
+	first, _ := utf8.DecodeRuneInString(tag)
+	if !unicode.IsLetter(first) && first != '_' {
+		tag = "section_" + tag
+	}
+	return tag
+}
+
+// escapeXMLText escapes the characters that would break the tag structure,
+// unlike xml.EscapeText it keeps newlines and quotes readable
+func escapeXMLText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// escapeXMLAttribute escapes a double quoted attribute value
+func escapeXMLAttribute(value string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(value)
+}
+
+// JSONRenderer renders the prompt as an indented json document
+// with the non-empty sections and the context providers
+type JSONRenderer struct{}
+
+// jsonPrompt is the document rendered by the JSONRenderer
+type jsonPrompt struct {
+	Sections []jsonSection `json:"sections,omitempty"`
+	Context  []jsonContext `json:"context,omitempty"`
+}
+
+type jsonSection struct {
+	Name    string   `json:"name"`
+	Title   string   `json:"title"`
+	Content []string `json:"content"`
+}
+
+type jsonContext struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	Info  string `json:"info"`
+}
+
+// Render renders the prompt as json
+func (r JSONRenderer) Render(data PromptData) (string, error) {
+	document := jsonPrompt{}
+	for _, section := range data.Sections {
+		if len(section.Content) == 0 {
+			continue
+		}
+		document.Sections = append(document.Sections, jsonSection{
+			Name:    section.Name,
+			Title:   section.Title,
+			Content: section.Content,
+		})
+	}
+	for _, provider := range data.ContextProviders {
+		document.Context = append(document.Context, jsonContext{
+			Name:  provider.Name,
+			Title: provider.Title,
+			Info:  provider.Info,
+		})
+	}
+
+	jsonBytes, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
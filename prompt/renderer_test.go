@@ -0,0 +1,76 @@
+package prompt
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRendererTestGenerator(opts ...SytemPromptGeneratorOption) *SystemPromptGenerator {
+	opts = append([]SytemPromptGeneratorOption{
+		WithBackground([]string{"I am a helpful assistant."}),
+		WithSteps([]string{"Think", "Answer"}),
+		WithSection(PromptSection{Name: "style guide", Title: "STYLE GUIDE", Content: []string{"Use <b> for bold & be brief"}, Style: SectionStyleParagraph}),
+		WithContextProvider("user", StaticContextProvider{Title: `User "Info"`, Info: "Name: Rob"}),
+	}, opts...)
+	return NewSystemPromptGenerator(opts...)
+}
+
+func TestMarkdownRenderer_IsDefault(t *testing.T) {
+	expected, err := newRendererTestGenerator().GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+
+	prompt, err := newRendererTestGenerator(WithRenderer(MarkdownRenderer{})).GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, prompt)
+}
+
+func TestXMLRenderer(t *testing.T) {
+	spg := newRendererTestGenerator(WithRenderer(XMLRenderer{}))
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+
+	expected := "<background>\n- I am a helpful assistant.\n</background>\n\n" +
+		"<steps>\n- Think\n- Answer\n</steps>\n\n" +
+		"<style_guide>\nUse &lt;b&gt; for bold &amp; be brief\n</style_guide>\n\n" +
+		"<context>\n" +
+		"<context_provider name=\"user\" title=\"User &quot;Info&quot;\">\nName: Rob\n</context_provider>\n" +
+		"</context>"
+	assert.Equal(t, expected, prompt)
+}
+
+func TestXMLTagName(t *testing.T) {
+	assert.Equal(t, "output_instructions", xmlTagName("output_instructions"))
+	assert.Equal(t, "style_guide", xmlTagName("Style Guide"))
+	assert.Equal(t, "section_1st", xmlTagName("1st"))
+	assert.Equal(t, "section_", xmlTagName(""))
+}
+
+func TestJSONRenderer(t *testing.T) {
+	spg := newRendererTestGenerator(WithRenderer(JSONRenderer{}))
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+
+	var document map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(prompt), &document))
+
+	sections := document["sections"].([]any)
+	assert.Equal(t, 3, len(sections))
+	assert.Equal(t, "background", sections[0].(map[string]any)["name"])
+	assert.Equal(t, []any{"Think", "Answer"}, sections[1].(map[string]any)["content"])
+
+	contexts := document["context"].([]any)
+	assert.Equal(t, "Name: Rob", contexts[0].(map[string]any)["info"])
+}
+
+func TestGeneratePromptWith_OverridesRenderer(t *testing.T) {
+	spg := newRendererTestGenerator(WithRenderer(JSONRenderer{}))
+
+	prompt, err := spg.GeneratePromptWith(context.Background(), XMLRenderer{})
+	assert.NoError(t, err)
+	assert.Contains(t, prompt, "<background>")
+}
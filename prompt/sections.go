@@ -0,0 +1,185 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SectionStyle decides how the content of a prompt section is rendered
+type SectionStyle int
+
+const (
+	// SectionStyleBullets renders each content line as a bullet point
+	SectionStyleBullets SectionStyle = iota
+	// SectionStyleNumbered renders the content as a numbered list
+	SectionStyleNumbered
+	// SectionStyleParagraph renders the content lines as they are
+	SectionStyleParagraph
+	// SectionStyleCodeBlock renders the content in a fenced code block
+	SectionStyleCodeBlock
+)
+
+// Names of the built-in sections. Their content is always taken from the
+// generator's Background, Steps and OutputInstructions, replacing them
+// only changes their title and style
+const (
+	SectionBackground         = "background"
+	SectionSteps              = "steps"
+	SectionOutputInstructions = "output_instructions"
+)
+
+// defaultSections are the built-in sections in their default order
+var defaultSections = []PromptSection{
+	{Name: SectionBackground, Title: "IDENTITY and PURPOSE"},
+	{Name: SectionSteps, Title: "INTERNAL ASSISTANT STEPS"},
+	{Name: SectionOutputInstructions, Title: "OUTPUT INSTRUCTIONS"},
+}
+
+// RenderContent renders the section's content in the section's style
+func (s PromptSection) RenderContent() string {
+	lines := make([]string, len(s.Content))
+	switch s.Style {
+	case SectionStyleNumbered:
+		for i, content := range s.Content {
+			lines[i] = fmt.Sprintf("%d. %s", i+1, content)
+		}
+	case SectionStyleParagraph:
+		copy(lines, s.Content)
+	case SectionStyleCodeBlock:
+		return fmt.Sprintf("```%s\n%s\n```", s.Language, strings.Join(s.Content, "\n"))
+	default:
+		for i, content := range s.Content {
+			lines[i] = fmt.Sprintf("- %s", content)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Funtions to add a section to the system prompt, a section
+// with the same name is replaced in place
+func WithSection(section PromptSection) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		if i := spg.indexOfSection(section.Name); i != -1 {
+			spg.sections[i] = section
+			return
+		}
+		spg.sections = append(spg.sections, section)
+	}
+}
+
+// Funtions to reorder the sections of the system prompt. The named sections
+// are moved to the front in the given order, all others follow unchanged
+func WithSectionOrder(names ...string) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		ordered := []PromptSection{}
+		for _, name := range names {
+			if i := spg.indexOfSection(name); i != -1 {
+				ordered = append(ordered, spg.sections[i])
+				spg.sections = slices.Delete(spg.sections, i, i+1)
+			}
+		}
+		spg.sections = append(ordered, spg.sections...)
+	}
+}
+
+// Sections returns the sections of the prompt in render order,
+// with the content of the built-in sections filled in
+func (spg *SystemPromptGenerator) Sections() []PromptSection {
+	spg.mu.RLock()
+	defer spg.mu.RUnlock()
+
+	return spg.resolveSections()
+}
+
+// AddSection appends a new section to the prompt
+func (spg *SystemPromptGenerator) AddSection(section PromptSection) error {
+	spg.mu.Lock()
+	defer spg.mu.Unlock()
+
+	if section.Name == "" {
+		return errors.New("section name cannot be empty")
+	}
+	if spg.indexOfSection(section.Name) != -1 {
+		return fmt.Errorf("section '%s' already exists", section.Name)
+	}
+	spg.sections = append(spg.sections, section)
+	return nil
+}
+
+// ReplaceSection replaces the section with the same name, keeping its position
+func (spg *SystemPromptGenerator) ReplaceSection(section PromptSection) error {
+	spg.mu.Lock()
+	defer spg.mu.Unlock()
+
+	i := spg.indexOfSection(section.Name)
+	if i == -1 {
+		return fmt.Errorf("section '%s' not found", section.Name)
+	}
+	spg.sections[i] = section
+	return nil
+}
+
+// RemoveSection removes the section with the given name from the prompt
+func (spg *SystemPromptGenerator) RemoveSection(name string) error {
+	spg.mu.Lock()
+	defer spg.mu.Unlock()
+
+	i := spg.indexOfSection(name)
+	if i == -1 {
+		return fmt.Errorf("section '%s' not found", name)
+	}
+	spg.sections = slices.Delete(spg.sections, i, i+1)
+	return nil
+}
+
+// MoveSection moves the section with the given name to the given position
+func (spg *SystemPromptGenerator) MoveSection(name string, index int) error {
+	spg.mu.Lock()
+	defer spg.mu.Unlock()
+
+	i := spg.indexOfSection(name)
+	if i == -1 {
+		return fmt.Errorf("section '%s' not found", name)
+	}
+	if index < 0 || index >= len(spg.sections) {
+		return fmt.Errorf("section index %d out of range", index)
+	}
+	section := spg.sections[i]
+	spg.sections = slices.Delete(spg.sections, i, i+1)
+	spg.sections = slices.Insert(spg.sections, index, section)
+	return nil
+}
+
+// indexOfSection returns the position of the named section
+// or -1, requires spg.mu to be held
+func (spg *SystemPromptGenerator) indexOfSection(name string) int {
+	return slices.IndexFunc(spg.sections, func(section PromptSection) bool {
+		return section.Name == name
+	})
+}
+
+// resolveSections copies the sections and fills in the content of
+// the built-in ones, requires spg.mu to be held
+func (spg *SystemPromptGenerator) resolveSections() []PromptSection {
+	sections := make([]PromptSection, len(spg.sections))
+	for i, section := range spg.sections {
+		switch section.Name {
+		case SectionBackground:
+			section.Content = spg.Background
+		case SectionSteps:
+			section.Content = spg.Steps
+		case SectionOutputInstructions:
+			section.Content = spg.OutputInstructions
+		case SectionExamples:
+			section.Content = nil
+			if spg.exampleMode == ExamplesInPrompt {
+				section.Content = renderExamples(spg.examples)
+			}
+		}
+		section.Content = slices.Clone(section.Content)
+		sections[i] = section
+	}
+	return sections
+}
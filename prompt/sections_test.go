@@ -0,0 +1,108 @@
+package prompt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sectionNames(spg *SystemPromptGenerator) []string {
+	names := []string{}
+	for _, section := range spg.Sections() {
+		names = append(names, section.Name)
+	}
+	return names
+}
+
+func TestPromptSection_RenderContent(t *testing.T) {
+	content := []string{"first", "second"}
+
+	assert.Equal(t, "- first\n- second", PromptSection{Content: content}.RenderContent())
+	assert.Equal(t, "1. first\n2. second", PromptSection{Content: content, Style: SectionStyleNumbered}.RenderContent())
+	assert.Equal(t, "first\nsecond", PromptSection{Content: content, Style: SectionStyleParagraph}.RenderContent())
+	assert.Equal(t, "```json\nfirst\nsecond\n```", PromptSection{Content: content, Style: SectionStyleCodeBlock, Language: "json"}.RenderContent())
+}
+
+func TestNewSystemPromptGenerator_DefaultSections(t *testing.T) {
+	spg := NewSystemPromptGenerator(WithSteps([]string{"Step 1"}))
+
+	assert.Equal(t, []string{SectionBackground, SectionSteps, SectionOutputInstructions}, sectionNames(spg))
+	assert.Equal(t, []string{"Step 1"}, spg.Sections()[1].Content)
+}
+
+func TestWithSection(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithBackground([]string{"I am a helpful assistant."}),
+		WithSection(PromptSection{Name: "constraints", Title: "CONSTRAINTS", Content: []string{"Never lie"}}),
+		WithSection(PromptSection{Name: "style", Title: "STYLE GUIDE", Content: []string{"Be concise.", "Use simple words."}, Style: SectionStyleParagraph}),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	expected := "# IDENTITY and PURPOSE\n- I am a helpful assistant.\n\n" +
+		"# CONSTRAINTS\n- Never lie\n\n" +
+		"# STYLE GUIDE\nBe concise.\nUse simple words."
+	assert.Equal(t, expected, prompt)
+}
+
+func TestWithSection_ReplacesBuiltin(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithSteps([]string{"Think", "Answer"}),
+		WithSection(PromptSection{Name: SectionSteps, Title: "STEPS", Style: SectionStyleNumbered}),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "# STEPS\n1. Think\n2. Answer", prompt)
+}
+
+func TestWithSectionOrder(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithSection(PromptSection{Name: "examples", Title: "EXAMPLES"}),
+		WithSectionOrder("examples", SectionOutputInstructions),
+	)
+
+	assert.Equal(t, []string{"examples", SectionOutputInstructions, SectionBackground, SectionSteps}, sectionNames(spg))
+}
+
+func TestAddSection(t *testing.T) {
+	spg := NewSystemPromptGenerator()
+
+	assert.NoError(t, spg.AddSection(PromptSection{Name: "constraints", Title: "CONSTRAINTS"}))
+	assert.Error(t, spg.AddSection(PromptSection{Name: "constraints"}))
+	assert.Error(t, spg.AddSection(PromptSection{}))
+	assert.Equal(t, "constraints", sectionNames(spg)[3])
+}
+
+func TestReplaceSection(t *testing.T) {
+	spg := NewSystemPromptGenerator(WithSection(PromptSection{Name: "constraints", Content: []string{"old"}}))
+
+	assert.NoError(t, spg.ReplaceSection(PromptSection{Name: "constraints", Content: []string{"new"}}))
+	assert.Equal(t, []string{"new"}, spg.Sections()[3].Content)
+	assert.Error(t, spg.ReplaceSection(PromptSection{Name: "unknown"}))
+}
+
+func TestRemoveSection(t *testing.T) {
+	spg := NewSystemPromptGenerator(WithBackground([]string{"Hidden"}))
+
+	assert.NoError(t, spg.RemoveSection(SectionBackground))
+	assert.Error(t, spg.RemoveSection(SectionBackground))
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.NotContains(t, prompt, "Hidden")
+}
+
+func TestMoveSection(t *testing.T) {
+	spg := NewSystemPromptGenerator()
+
+	assert.NoError(t, spg.MoveSection(SectionOutputInstructions, 0))
+	assert.Equal(t, []string{SectionOutputInstructions, SectionBackground, SectionSteps}, sectionNames(spg))
+
+	assert.NoError(t, spg.MoveSection(SectionOutputInstructions, 2))
+	assert.Equal(t, []string{SectionBackground, SectionSteps, SectionOutputInstructions}, sectionNames(spg))
+
+	assert.Error(t, spg.MoveSection("unknown", 0))
+	assert.Error(t, spg.MoveSection(SectionSteps, 3))
+}
@@ -0,0 +1,101 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// PromptData is the data system prompt templates are executed with.
+// Sections holds all sections in render order including the built-in ones,
+// ContextProviders the fetched providers in render order and Vars the
+// generator's template variables
+type PromptData struct {
+	Background         []string
+	Steps              []string
+	OutputInstructions []string
+	Sections           []PromptSection
+	Examples           []Example
+	ContextProviders   []ContextSection
+	Vars               map[string]any
+}
+
+// DefaultPromptTemplate renders the sections as markdown headings with their
+// content in the section's style. Surrounding whitespace of the rendered
+// prompt is always trimmed
+const DefaultPromptTemplate = `
+{{- range .Sections}}{{if .Content}}# {{.Title}}
+{{.RenderContent}}
+
+{{end}}{{end}}
+{{- if .ContextProviders}}# EXTRA INFORMATION AND CONTEXT
+{{range .ContextProviders}}# {{.Title}}
+- {{.Info}}
+
+{{end}}
+{{- end}}`
+
+// templateFuncs are available in templates parsed with ParsePromptTemplate
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// defaultPromptTemplate is the parsed DefaultPromptTemplate
+var defaultPromptTemplate = template.Must(ParsePromptTemplate("default", DefaultPromptTemplate))
+
+// ParsePromptTemplate parses a system prompt template. Besides the builtin
+// functions the template can use join, upper, lower and trim
+func ParsePromptTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+// TemplateRenderer renders the prompt by executing a template with PromptData
+type TemplateRenderer struct {
+	Template *template.Template
+}
+
+// Render executes the template
+func (r TemplateRenderer) Render(data PromptData) (string, error) {
+	var prompt strings.Builder
+	if err := r.Template.Execute(&prompt, data); err != nil {
+		return "", fmt.Errorf("failed to execute prompt template: %w", err)
+	}
+	return prompt.String(), nil
+}
+
+// Funtions to render the system prompt with a custom template, which is
+// executed with PromptData. A nil template restores the default one
+func WithPromptTemplate(tmpl *template.Template) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		spg.renderer = nil
+		if tmpl != nil {
+			spg.renderer = TemplateRenderer{Template: tmpl}
+		}
+	}
+}
+
+// Funtions to set variables available to the template as .Vars
+func WithTemplateVariables(variables map[string]any) SytemPromptGeneratorOption {
+	return func(spg *SystemPromptGenerator) {
+		if spg.variables == nil {
+			spg.variables = map[string]any{}
+		}
+		for key, value := range variables {
+			spg.variables[key] = value
+		}
+	}
+}
+
+// SetTemplateVariable sets a variable available to the template as .Vars
+func (spg *SystemPromptGenerator) SetTemplateVariable(key string, value any) {
+	spg.mu.Lock()
+	defer spg.mu.Unlock()
+
+	if spg.variables == nil {
+		spg.variables = map[string]any{}
+	}
+	spg.variables[key] = value
+}
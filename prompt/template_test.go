@@ -0,0 +1,83 @@
+package prompt
+
+import (
+	"context"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratePrompt_DefaultTemplateLayout(t *testing.T) {
+	spg := NewSystemPromptGenerator(
+		WithBackground([]string{"I am a helpful assistant.", "I like onigiri."}),
+		WithSteps([]string{"Greet the user"}),
+		WithOutputInstructions([]string{"Respond in markdown format."}),
+		WithContextProvider("info", StaticContextProvider{Title: "User Info", Info: "Name: Rob"}),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+
+	expected := "# IDENTITY and PURPOSE\n" +
+		"- I am a helpful assistant.\n" +
+		"- I like onigiri.\n" +
+		"\n" +
+		"# INTERNAL ASSISTANT STEPS\n" +
+		"- Greet the user\n" +
+		"\n" +
+		"# OUTPUT INSTRUCTIONS\n" +
+		"- Respond in markdown format.\n" +
+		"\n" +
+		"# EXTRA INFORMATION AND CONTEXT\n" +
+		"# User Info\n" +
+		"- Name: Rob"
+	assert.Equal(t, expected, prompt)
+}
+
+func TestGeneratePrompt_DefaultTemplateSkipsEmptySections(t *testing.T) {
+	spg := NewSystemPromptGenerator(WithSteps([]string{"Only step"}))
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "# INTERNAL ASSISTANT STEPS\n- Only step", prompt)
+}
+
+func TestGeneratePrompt_CustomTemplate(t *testing.T) {
+	tmpl, err := ParsePromptTemplate("custom", `You are {{.Vars.name}}.
+{{range .Background}}{{.}} {{end}}
+Steps: {{join .Steps ", "}}
+{{range .ContextProviders}}[{{upper .Title}}] {{.Info}}
+{{end}}`)
+	assert.NoError(t, err)
+
+	spg := NewSystemPromptGenerator(
+		WithPromptTemplate(tmpl),
+		WithTemplateVariables(map[string]any{"name": "Onigiri"}),
+		WithBackground([]string{"Be brief."}),
+		WithSteps([]string{"think", "answer"}),
+		WithContextProvider("date", StaticContextProvider{Title: "Date", Info: "today"}),
+	)
+
+	prompt, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "You are Onigiri.\nBe brief. \nSteps: think, answer\n[DATE] today", prompt)
+
+	spg.SetTemplateVariable("name", "Sushi")
+	prompt, err = spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, prompt, "You are Sushi.")
+}
+
+func TestGeneratePrompt_TemplateError(t *testing.T) {
+	tmpl := template.Must(template.New("broken").Parse(`{{.Missing.Field}}`))
+	spg := NewSystemPromptGenerator(WithPromptTemplate(tmpl))
+
+	_, err := spg.GeneratePrompt(context.Background())
+	assert.Error(t, err)
+}
+
+func TestParsePromptTemplate_Invalid(t *testing.T) {
+	_, err := ParsePromptTemplate("invalid", `{{if}}`)
+	assert.Error(t, err)
+}
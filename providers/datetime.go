@@ -0,0 +1,60 @@
+package providers
+
+import (
+	"context"
+	"time"
+)
+
+// Option type for DateTimeProvider
+type DateTimeOption func(*DateTimeProvider)
+
+// DateTimeProvider provides the current date and time in a timezone
+type DateTimeProvider struct {
+	title    string
+	location *time.Location
+	layout   string
+	now      func() time.Time
+}
+
+// Constructor for a new DateTimeProvider, a nil location uses the local timezone
+func NewDateTimeProvider(title string, location *time.Location, opts ...DateTimeOption) *DateTimeProvider {
+	if location == nil {
+		location = time.Local
+	}
+	p := &DateTimeProvider{
+		title:    title,
+		location: location,
+		layout:   "Monday, 2006-01-02 15:04:05 MST",
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Constructor for a new DateTimeProvider in the IANA timezone with the given name, e.g. "Europe/Berlin"
+func NewDateTimeProviderIn(title string, timezone string, opts ...DateTimeOption) (*DateTimeProvider, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	return NewDateTimeProvider(title, location, opts...), nil
+}
+
+// Functional option to set the time layout, defaults to "Monday, 2006-01-02 15:04:05 MST"
+func WithLayout(layout string) DateTimeOption {
+	return func(p *DateTimeProvider) {
+		p.layout = layout
+	}
+}
+
+// GetInfo returns the current time formatted with the layout
+func (p *DateTimeProvider) GetInfo(ctx context.Context) (string, error) {
+	return p.now().In(p.location).Format(p.layout), nil
+}
+
+// GetTitle returns the title of the provider
+func (p *DateTimeProvider) GetTitle() string {
+	return p.title
+}
@@ -0,0 +1,47 @@
+package providers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/robnmrz/onigiri/prompt"
+	"github.com/stretchr/testify/assert"
+)
+
+func fixedClock() time.Time {
+	return time.Date(2025, 3, 14, 15, 9, 26, 0, time.UTC)
+}
+
+func TestDateTimeProvider(t *testing.T) {
+	p := NewDateTimeProvider("Current Time", time.UTC)
+	p.now = fixedClock
+
+	info, err := p.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Friday, 2025-03-14 15:09:26 UTC", info)
+	assert.Equal(t, "Current Time", p.GetTitle())
+}
+
+func TestDateTimeProvider_TimezoneAndLayout(t *testing.T) {
+	p, err := NewDateTimeProviderIn("Current Time", "Asia/Tokyo", WithLayout(time.RFC3339))
+	assert.NoError(t, err)
+	p.now = fixedClock
+
+	info, err := p.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "2025-03-15T00:09:26+09:00", info)
+
+	_, err = NewDateTimeProviderIn("Current Time", "Not/AZone")
+	assert.Error(t, err)
+}
+
+func TestDateTimeProvider_InPrompt(t *testing.T) {
+	p := NewDateTimeProvider("Current Time", time.UTC, WithLayout("2006-01-02"))
+	p.now = fixedClock
+	spg := prompt.NewSystemPromptGenerator(prompt.WithFallibleContextProvider("time", p))
+
+	generated, err := spg.GeneratePrompt(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, generated, "# Current Time\n- 2025-03-14")
+}
@@ -0,0 +1,112 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Option type for DirectoryProvider
+type DirectoryOption func(*DirectoryProvider)
+
+// DirectoryProvider provides a listing of a directory, one path per line
+// relative to the directory. Directories end with a slash
+type DirectoryProvider struct {
+	title         string
+	dir           string
+	maxDepth      int
+	maxEntries    int
+	includeHidden bool
+}
+
+// Constructor for a new DirectoryProvider listing only the top level by default
+func NewDirectoryProvider(title string, dir string, opts ...DirectoryOption) *DirectoryProvider {
+	p := &DirectoryProvider{title: title, dir: dir, maxDepth: 1}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Functional option to set how many levels are listed, defaults to 1. 0 means no limit
+func WithMaxDepth(depth int) DirectoryOption {
+	return func(p *DirectoryProvider) {
+		p.maxDepth = depth
+	}
+}
+
+// Functional option to limit the number of listed entries, 0 means no limit.
+// A truncated listing ends with "... and more"
+func WithMaxEntries(entries int) DirectoryOption {
+	return func(p *DirectoryProvider) {
+		p.maxEntries = entries
+	}
+}
+
+// Functional option to list entries whose name starts with a dot
+func WithHidden(include bool) DirectoryOption {
+	return func(p *DirectoryProvider) {
+		p.includeHidden = include
+	}
+}
+
+// GetInfo lists the directory in lexical order
+func (p *DirectoryProvider) GetInfo(ctx context.Context) (string, error) {
+	lines := []string{}
+	truncated := false
+	err := filepath.WalkDir(p.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == p.dir {
+			return nil
+		}
+
+		relative, err := filepath.Rel(p.dir, path)
+		if err != nil {
+			return err
+		}
+		if !p.includeHidden && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Stop walking once the listing is full instead of visiting the rest of the tree
+		if p.maxEntries > 0 && len(lines) >= p.maxEntries {
+			truncated = true
+			return fs.SkipAll
+		}
+		if entry.IsDir() {
+			lines = append(lines, filepath.ToSlash(relative)+"/")
+		} else {
+			lines = append(lines, filepath.ToSlash(relative))
+		}
+
+		depth := strings.Count(relative, string(os.PathSeparator)) + 1
+		if entry.IsDir() && p.maxDepth > 0 && depth >= p.maxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list directory: %w", err)
+	}
+
+	if truncated {
+		lines = append(lines, "... and more")
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// GetTitle returns the title of the provider
+func (p *DirectoryProvider) GetTitle() string {
+	return p.title
+}
@@ -0,0 +1,64 @@
+package providers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, path := range []string{"b.txt", "a/one.go", "a/deep/two.go", ".git/config", ".env"} {
+		full := filepath.Join(dir, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		assert.NoError(t, os.WriteFile(full, []byte("x"), 0o644))
+	}
+	return dir
+}
+
+func TestDirectoryProvider(t *testing.T) {
+	p := NewDirectoryProvider("Files", newTestTree(t))
+
+	info, err := p.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "a/\nb.txt", info)
+	assert.Equal(t, "Files", p.GetTitle())
+}
+
+func TestDirectoryProvider_Depth(t *testing.T) {
+	dir := newTestTree(t)
+
+	info, err := NewDirectoryProvider("Files", dir, WithMaxDepth(2)).GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "a/\na/deep/\na/one.go\nb.txt", info)
+
+	info, err = NewDirectoryProvider("Files", dir, WithMaxDepth(0)).GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "a/\na/deep/\na/deep/two.go\na/one.go\nb.txt", info)
+}
+
+func TestDirectoryProvider_HiddenAndMaxEntries(t *testing.T) {
+	dir := newTestTree(t)
+
+	info, err := NewDirectoryProvider("Files", dir, WithHidden(true)).GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ".env\n.git/\na/\nb.txt", info)
+
+	info, err = NewDirectoryProvider("Files", dir, WithHidden(true), WithMaxEntries(2)).GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ".env\n.git/\n... and more", info)
+
+	// A listing that fits isn't marked as truncated
+	info, err = NewDirectoryProvider("Files", dir, WithHidden(true), WithMaxEntries(4)).GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ".env\n.git/\na/\nb.txt", info)
+}
+
+func TestDirectoryProvider_Missing(t *testing.T) {
+	_, err := NewDirectoryProvider("Files", filepath.Join(t.TempDir(), "missing")).GetInfo(context.Background())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
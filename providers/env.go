@@ -0,0 +1,38 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// EnvProvider provides environment variables from an allowlist, one
+// "NAME=value" line per variable that is set. Variables that aren't on the
+// allowlist are never exposed, so secrets don't leak into the prompt
+type EnvProvider struct {
+	title     string
+	allowlist []string
+}
+
+// Constructor for a new EnvProvider exposing the listed variables
+func NewEnvProvider(title string, allowlist ...string) *EnvProvider {
+	return &EnvProvider{title: title, allowlist: slices.Clone(allowlist)}
+}
+
+// GetInfo returns the allowed variables that are set in the listed order
+func (p *EnvProvider) GetInfo(ctx context.Context) (string, error) {
+	lines := []string{}
+	for _, name := range p.allowlist {
+		if value, ok := os.LookupEnv(name); ok {
+			lines = append(lines, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// GetTitle returns the title of the provider
+func (p *EnvProvider) GetTitle() string {
+	return p.title
+}
@@ -0,0 +1,22 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("ONIGIRI_REGION", "eu-west-1")
+	t.Setenv("ONIGIRI_STAGE", "")
+	t.Setenv("ONIGIRI_SECRET", "hunter2")
+
+	p := NewEnvProvider("Environment", "ONIGIRI_STAGE", "ONIGIRI_REGION", "ONIGIRI_UNSET")
+
+	info, err := p.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ONIGIRI_STAGE=\nONIGIRI_REGION=eu-west-1", info)
+	assert.NotContains(t, info, "hunter2")
+	assert.Equal(t, "Environment", p.GetTitle())
+}
@@ -0,0 +1,68 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// FactsProvider provides static key/value facts, one "key: value" line
+// per fact sorted by key. It is safe for concurrent use
+type FactsProvider struct {
+	title string
+	mu    sync.RWMutex
+	facts map[string]string
+}
+
+// Constructor for a new FactsProvider, the facts are copied
+func NewFactsProvider(title string, facts map[string]string) *FactsProvider {
+	p := &FactsProvider{title: title, facts: maps.Clone(facts)}
+	if p.facts == nil {
+		p.facts = map[string]string{}
+	}
+	return p
+}
+
+// Set adds or replaces a fact
+func (p *FactsProvider) Set(key string, value string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.facts[key] = value
+}
+
+// Delete removes a fact
+func (p *FactsProvider) Delete(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.facts, key)
+}
+
+// Facts returns a copy of the facts
+func (p *FactsProvider) Facts() map[string]string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return maps.Clone(p.facts)
+}
+
+// GetInfo returns the facts sorted by key
+func (p *FactsProvider) GetInfo(ctx context.Context) (string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	lines := make([]string, 0, len(p.facts))
+	for _, key := range slices.Sorted(maps.Keys(p.facts)) {
+		lines = append(lines, fmt.Sprintf("%s: %s", key, p.facts[key]))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// GetTitle returns the title of the provider
+func (p *FactsProvider) GetTitle() string {
+	return p.title
+}
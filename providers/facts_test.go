@@ -0,0 +1,33 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFactsProvider(t *testing.T) {
+	facts := map[string]string{"plan": "enterprise", "company": "ACME"}
+	p := NewFactsProvider("Customer", facts)
+
+	// The facts are copied
+	facts["plan"] = "free"
+
+	info, err := p.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "company: ACME\nplan: enterprise", info)
+	assert.Equal(t, "Customer", p.GetTitle())
+}
+
+func TestFactsProvider_SetAndDelete(t *testing.T) {
+	p := NewFactsProvider("Customer", nil)
+	p.Set("name", "Jane")
+	p.Set("country", "DE")
+	p.Delete("country")
+
+	info, err := p.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "name: Jane", info)
+	assert.Equal(t, map[string]string{"name": "Jane"}, p.Facts())
+}
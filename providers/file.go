@@ -0,0 +1,63 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// FileProvider provides the contents of a file, which is read on every
+// prompt generation. Files larger than the size limit are truncated
+type FileProvider struct {
+	title    string
+	path     string
+	maxBytes int64
+}
+
+// Constructor for a new FileProvider, maxBytes <= 0 means no size limit
+func NewFileProvider(title string, path string, maxBytes int64) *FileProvider {
+	return &FileProvider{title: title, path: path, maxBytes: maxBytes}
+}
+
+// GetInfo reads the file, truncating it after maxBytes at a character boundary
+func (p *FileProvider) GetInfo(ctx context.Context) (string, error) {
+	file, err := os.Open(p.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if p.maxBytes <= 0 {
+		content, err := io.ReadAll(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read file: %w", err)
+		}
+		return string(content), nil
+	}
+
+	// Read one byte more than allowed to detect larger files
+	content, err := io.ReadAll(io.LimitReader(file, p.maxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(content)) <= p.maxBytes {
+		return string(content), nil
+	}
+
+	content = content[:p.maxBytes]
+	// Don't cut a multi-byte character in half
+	for range utf8.UTFMax - 1 {
+		if r, size := utf8.DecodeLastRune(content); r != utf8.RuneError || size != 1 {
+			break
+		}
+		content = content[:len(content)-1]
+	}
+	return fmt.Sprintf("%s\n[truncated after %d bytes]", content, len(content)), nil
+}
+
+// GetTitle returns the title of the provider
+func (p *FileProvider) GetTitle() string {
+	return p.title
+}
@@ -0,0 +1,51 @@
+package providers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notes.md")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestFileProvider(t *testing.T) {
+	p := NewFileProvider("Notes", writeFile(t, "# Notes\nremember this"), 0)
+
+	info, err := p.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "# Notes\nremember this", info)
+	assert.Equal(t, "Notes", p.GetTitle())
+}
+
+func TestFileProvider_SizeLimit(t *testing.T) {
+	path := writeFile(t, "0123456789")
+
+	info, err := NewFileProvider("Notes", path, 4).GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "0123\n[truncated after 4 bytes]", info)
+
+	// Files within the limit are not marked
+	info, err = NewFileProvider("Notes", path, 10).GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", info)
+}
+
+func TestFileProvider_SizeLimitKeepsCharacters(t *testing.T) {
+	// "ö" takes two bytes, cutting after three bytes would split it
+	info, err := NewFileProvider("Notes", writeFile(t, "aböc"), 3).GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ab\n[truncated after 2 bytes]", info)
+}
+
+func TestFileProvider_Missing(t *testing.T) {
+	_, err := NewFileProvider("Notes", filepath.Join(t.TempDir(), "missing.md"), 0).GetInfo(context.Background())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
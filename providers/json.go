@@ -0,0 +1,47 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// JSONProvider provides a Go value as indented json. The value is encoded
+// on every prompt generation, so changes to it show up in the prompt.
+// It is safe for concurrent use
+type JSONProvider struct {
+	title string
+	mu    sync.RWMutex
+	value any
+}
+
+// Constructor for a new JSONProvider
+func NewJSONProvider(title string, value any) *JSONProvider {
+	return &JSONProvider{title: title, value: value}
+}
+
+// SetValue replaces the provided value
+func (p *JSONProvider) SetValue(value any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.value = value
+}
+
+// GetInfo encodes the value as json indented with two spaces
+func (p *JSONProvider) GetInfo(ctx context.Context) (string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	jsonBytes, err := json.MarshalIndent(p.value, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode value: %w", err)
+	}
+	return string(jsonBytes), nil
+}
+
+// GetTitle returns the title of the provider
+func (p *JSONProvider) GetTitle() string {
+	return p.title
+}
@@ -0,0 +1,32 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Order struct {
+	Id    string   `json:"id"`
+	Items []string `json:"items"`
+}
+
+func TestJSONProvider(t *testing.T) {
+	p := NewJSONProvider("Order", Order{Id: "42", Items: []string{"rice"}})
+
+	info, err := p.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"id\": \"42\",\n  \"items\": [\n    \"rice\"\n  ]\n}", info)
+	assert.Equal(t, "Order", p.GetTitle())
+
+	p.SetValue(map[string]int{"count": 1})
+	info, err = p.GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"count\": 1\n}", info)
+}
+
+func TestJSONProvider_EncodingError(t *testing.T) {
+	_, err := NewJSONProvider("Order", make(chan int)).GetInfo(context.Background())
+	assert.ErrorContains(t, err, "failed to encode value")
+}
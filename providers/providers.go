@@ -0,0 +1,22 @@
+// Package providers contains ready-made context providers for the system prompt.
+//
+// The providers implement prompt.ContextProvider, their info can fail or be
+// cancelled, so they are registered as fallible providers:
+//
+//	agent.RegisterFallibleContextProvider("time", providers.NewDateTimeProvider("Current Time", nil))
+//	prompt.NewSystemPromptGenerator(prompt.WithFallibleContextProvider("time", provider))
+//
+// Wrap them with prompt.NewFallibleCachingContextProvider to cache their info.
+package providers
+
+import "github.com/robnmrz/onigiri/prompt"
+
+// The providers are registered through the fallible registration path
+var (
+	_ prompt.ContextProvider = (*DateTimeProvider)(nil)
+	_ prompt.ContextProvider = (*DirectoryProvider)(nil)
+	_ prompt.ContextProvider = (*EnvProvider)(nil)
+	_ prompt.ContextProvider = (*FactsProvider)(nil)
+	_ prompt.ContextProvider = (*FileProvider)(nil)
+	_ prompt.ContextProvider = (*JSONProvider)(nil)
+)
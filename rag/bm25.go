@@ -0,0 +1,255 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Option type for BM25Index
+type BM25Option func(*BM25Index)
+
+// BM25Index is an in-memory inverted index ranking chunks with BM25.
+// Unlike embeddings it matches exact terms such as error codes and
+// identifiers. It is safe for concurrent use
+type BM25Index struct {
+	k1 float64
+	b  float64
+
+	mu sync.RWMutex
+	// chunks holds the indexed chunks in insertion order, removed chunks are
+	// nil until the slice is compacted
+	chunks []*bm25Chunk
+	ids    map[string]int
+	// postings maps each term to the positions of the chunks containing it
+	postings    map[string]map[int]int
+	totalLength int
+	count       int
+}
+
+// bm25Chunk is an indexed chunk with its term frequencies
+type bm25Chunk struct {
+	chunk  Chunk
+	terms  map[string]int
+	length int
+}
+
+// Constructor for a new empty BM25Index with k1 = 1.2 and b = 0.75
+func NewBM25Index(opts ...BM25Option) *BM25Index {
+	index := &BM25Index{
+		k1:       1.2,
+		b:        0.75,
+		ids:      map[string]int{},
+		postings: map[string]map[int]int{},
+	}
+	for _, opt := range opts {
+		opt(index)
+	}
+	return index
+}
+
+// Functional option to set the BM25 parameters. k1 controls how quickly
+// repeated terms saturate, b how strongly scores are normalized by length
+func WithBM25Parameters(k1 float64, b float64) BM25Option {
+	return func(index *BM25Index) {
+		index.k1 = k1
+		index.b = b
+	}
+}
+
+// Tokenize splits a text into lower case terms of letters, digits and
+// underscores, so that identifiers like ERR_TIMEOUT stay one term
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// AddDocuments chunks the documents and adds the chunks to the index.
+// Documents that are already indexed are replaced, including chunks the
+// new version of the document no longer has
+func (index *BM25Index) AddDocuments(chunker Chunker, docs ...Document) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	for _, doc := range docs {
+		index.removeDocument(doc.Id)
+		index.add(chunker.Chunk(doc))
+	}
+	index.compactIfSparse()
+}
+
+// Add adds chunks to the index.
+// Chunks with an id that is already indexed replace the indexed one
+func (index *BM25Index) Add(chunks ...Chunk) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.add(chunks)
+	index.compactIfSparse()
+}
+
+// add indexes the chunks, requires index.mu to be held for writing
+func (index *BM25Index) add(chunks []Chunk) {
+	for _, chunk := range chunks {
+		if position, ok := index.ids[chunk.Id]; ok {
+			index.remove(position)
+		}
+
+		tokens := Tokenize(chunk.Text)
+		indexed := &bm25Chunk{chunk: chunk, terms: map[string]int{}, length: len(tokens)}
+		for _, token := range tokens {
+			indexed.terms[token]++
+		}
+
+		position := len(index.chunks)
+		index.chunks = append(index.chunks, indexed)
+		index.ids[chunk.Id] = position
+		for term, frequency := range indexed.terms {
+			if index.postings[term] == nil {
+				index.postings[term] = map[int]int{}
+			}
+			index.postings[term][position] = frequency
+		}
+		index.totalLength += indexed.length
+		index.count++
+	}
+}
+
+// RemoveDocument removes all chunks of a document from the index
+func (index *BM25Index) RemoveDocument(documentId string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.removeDocument(documentId)
+	index.compactIfSparse()
+}
+
+// removeDocument drops all chunks of a document,
+// requires index.mu to be held for writing
+func (index *BM25Index) removeDocument(documentId string) {
+	for position, indexed := range index.chunks {
+		if indexed != nil && indexed.chunk.DocumentId == documentId {
+			index.remove(position)
+		}
+	}
+}
+
+// remove drops the chunk at the position from the postings
+func (index *BM25Index) remove(position int) {
+	indexed := index.chunks[position]
+	for term := range indexed.terms {
+		delete(index.postings[term], position)
+		if len(index.postings[term]) == 0 {
+			delete(index.postings, term)
+		}
+	}
+	delete(index.ids, indexed.chunk.Id)
+	index.chunks[position] = nil
+	index.totalLength -= indexed.length
+	index.count--
+}
+
+// compactIfSparse drops the removed chunks once they take up more than half
+// of the slice, so that replacing and removing chunks doesn't grow it without
+// bound. Compacting keeps the insertion order, which breaks ties in Search
+func (index *BM25Index) compactIfSparse() {
+	if 2*index.count >= len(index.chunks) {
+		return
+	}
+
+	chunks := make([]*bm25Chunk, 0, index.count)
+	for _, indexed := range index.chunks {
+		if indexed != nil {
+			chunks = append(chunks, indexed)
+		}
+	}
+	index.chunks = chunks
+	index.ids = make(map[string]int, len(chunks))
+	index.postings = map[string]map[int]int{}
+	for position, indexed := range chunks {
+		index.ids[indexed.chunk.Id] = position
+		for term, frequency := range indexed.terms {
+			if index.postings[term] == nil {
+				index.postings[term] = map[int]int{}
+			}
+			index.postings[term][position] = frequency
+		}
+	}
+}
+
+// Len returns the number of indexed chunks
+func (index *BM25Index) Len() int {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	return index.count
+}
+
+// Retrieve returns the k chunks with the highest BM25 score for the query
+func (index *BM25Index) Retrieve(ctx context.Context, query string, k int) ([]Result, error) {
+	return index.Search(query, k), nil
+}
+
+// Search returns the k chunks with the highest BM25 score for the query.
+// Chunks sharing no term with the query are left out
+func (index *BM25Index) Search(query string, k int) []Result {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	if index.count == 0 || k <= 0 {
+		return []Result{}
+	}
+
+	averageLength := float64(index.totalLength) / float64(index.count)
+	scores := map[int]float64{}
+	for _, term := range uniqueTerms(Tokenize(query)) {
+		postings := index.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		// Probabilistic idf, shifted to stay positive for common terms
+		n := float64(len(postings))
+		idf := math.Log(1 + (float64(index.count)-n+0.5)/(n+0.5))
+		for position, frequency := range postings {
+			tf := float64(frequency)
+			length := float64(index.chunks[position].length)
+			scores[position] += idf * tf * (index.k1 + 1) / (tf + index.k1*(1-index.b+index.b*length/averageLength))
+		}
+	}
+
+	positions := make([]int, 0, len(scores))
+	for position := range scores {
+		positions = append(positions, position)
+	}
+	// Ties keep insertion order
+	slices.SortFunc(positions, func(a, b int) int {
+		if c := cmp.Compare(scores[b], scores[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+
+	results := make([]Result, 0, min(k, len(positions)))
+	for _, position := range positions[:min(k, len(positions))] {
+		results = append(results, Result{Chunk: index.chunks[position].chunk, Score: scores[position]})
+	}
+	return results
+}
+
+// uniqueTerms removes repeated terms, keeping their first occurrence
+func uniqueTerms(terms []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+	return unique
+}
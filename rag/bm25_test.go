@@ -0,0 +1,100 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBM25Index() *BM25Index {
+	index := NewBM25Index()
+	index.Add(
+		Chunk{Id: "timeout", DocumentId: "errors", Source: "errors.md", Text: "ERR_TIMEOUT is returned when the upstream service is too slow."},
+		Chunk{Id: "auth", DocumentId: "errors", Source: "errors.md", Text: "E1042 means the api key is invalid. Rotate the api key."},
+		Chunk{Id: "intro", DocumentId: "guide", Source: "guide.md", Text: "The service answers questions about the api."},
+	)
+	return index
+}
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"err_timeout", "e1042", "is", "api", "key"}, Tokenize("ERR_TIMEOUT, E1042 is api-key!"))
+	assert.Empty(t, Tokenize(" ... "))
+}
+
+func TestBM25Index_ExactIdentifiers(t *testing.T) {
+	index := newTestBM25Index()
+
+	results := index.Search("what does E1042 mean?", 3)
+	assert.Equal(t, "auth", results[0].Chunk.Id)
+
+	results = index.Search("err_timeout", 3)
+	assert.Equal(t, []string{"timeout"}, resultIds(results))
+}
+
+func TestBM25Index_Ranking(t *testing.T) {
+	index := newTestBM25Index()
+
+	// The chunk mentioning the terms more often ranks first,
+	// chunks without any query term are left out
+	results := index.Search("api key", 10)
+	assert.Equal(t, []string{"auth", "intro"}, resultIds(results))
+	assert.Greater(t, results[0].Score, results[1].Score)
+
+	assert.Empty(t, index.Search("unknown words", 3))
+	assert.Empty(t, index.Search("api", 0))
+}
+
+func TestBM25Index_ReplaceAndRemove(t *testing.T) {
+	index := newTestBM25Index()
+	index.Add(Chunk{Id: "auth", DocumentId: "errors", Text: "E2000 means the quota is exhausted."})
+	assert.Equal(t, 3, index.Len())
+	assert.Empty(t, index.Search("E1042", 3))
+	assert.Equal(t, "auth", index.Search("E2000", 3)[0].Chunk.Id)
+
+	index.RemoveDocument("errors")
+	assert.Equal(t, 1, index.Len())
+	assert.Equal(t, []string{"intro"}, resultIds(index.Search("service api quota", 3)))
+}
+
+func TestBM25Index_CompactsRemovedChunks(t *testing.T) {
+	index := NewBM25Index()
+	for i := range 100 {
+		index.Add(Chunk{Id: "status", DocumentId: "status", Text: fmt.Sprintf("status %d", i)})
+	}
+	assert.Equal(t, 1, index.Len())
+	assert.LessOrEqual(t, len(index.chunks), 2)
+	assert.Equal(t, "status 99", index.Search("status", 1)[0].Chunk.Text)
+
+	// Compacting keeps the insertion order for ties
+	index.Add(Chunk{Id: "a", Text: "tie"}, Chunk{Id: "b", Text: "tie"}, Chunk{Id: "c", Text: "tie"})
+	index.RemoveDocument("status")
+	index.Add(Chunk{Id: "d", Text: "tie"}, Chunk{Id: "e", Text: "tie"})
+	index.Add(Chunk{Id: "a", Text: "tie"})
+	assert.Equal(t, []string{"b", "c", "d", "e", "a"}, resultIds(index.Search("tie", 10)))
+}
+
+func TestBM25Index_AddDocuments(t *testing.T) {
+	index := NewBM25Index(WithBM25Parameters(1.5, 0.5))
+	chunker, err := NewMarkdownChunker(200, 0)
+	assert.NoError(t, err)
+	index.AddDocuments(chunker, Document{Id: "guide", Source: "guide.md", Text: "# Errors\nE1042 is bad.\n# Setup\nInstall it."})
+
+	results, err := index.Retrieve(context.Background(), "E1042", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "guide.md > Errors", results[0].Chunk.Citation())
+}
+
+func TestBM25Index_AddDocumentsReplacesDocument(t *testing.T) {
+	index := NewBM25Index()
+	chunker, err := NewMarkdownChunker(200, 0)
+	assert.NoError(t, err)
+	index.AddDocuments(chunker, Document{Id: "guide", Source: "guide.md", Text: "# Errors\nE1042 is bad.\n# Setup\nInstall it."})
+	assert.Equal(t, 2, index.Len())
+
+	// The new version has fewer chunks, the old ones are gone
+	index.AddDocuments(chunker, Document{Id: "guide", Source: "guide.md", Text: "# Setup\nInstall it."})
+	assert.Equal(t, 1, index.Len())
+	assert.Empty(t, index.Search("E1042", 10))
+}
@@ -0,0 +1,178 @@
+package rag
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// Document is a text to be indexed, Source is cited when its chunks are used
+type Document struct {
+	Id       string
+	Source   string
+	Text     string
+	Metadata map[string]string
+}
+
+// Chunk is a part of a document that is embedded and retrieved on its own.
+// Heading holds the headings the chunk is nested under, e.g. "Setup > Install"
+type Chunk struct {
+	Id         string            `json:"id"`
+	DocumentId string            `json:"document_id"`
+	Source     string            `json:"source"`
+	Heading    string            `json:"heading,omitempty"`
+	Index      int               `json:"index"`
+	Text       string            `json:"text"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Citation returns the source of the chunk together with its heading
+func (c Chunk) Citation() string {
+	if c.Heading == "" {
+		return c.Source
+	}
+	return fmt.Sprintf("%s > %s", c.Source, c.Heading)
+}
+
+// Chunker splits documents into chunks
+type Chunker interface {
+	Chunk(doc Document) []Chunk
+}
+
+// FixedSizeChunker splits documents into chunks of a fixed number of
+// characters, consecutive chunks share Overlap characters
+type FixedSizeChunker struct {
+	size    int
+	overlap int
+}
+
+// Constructor for a new FixedSizeChunker, the overlap has to be smaller than the size
+func NewFixedSizeChunker(size int, overlap int) (*FixedSizeChunker, error) {
+	if size <= 0 {
+		return nil, errors.New("chunk size must be positive")
+	}
+	if overlap < 0 || overlap >= size {
+		return nil, errors.New("chunk overlap must be between 0 and the chunk size")
+	}
+	return &FixedSizeChunker{size: size, overlap: overlap}, nil
+}
+
+// Chunk splits the document's text into overlapping windows
+func (c *FixedSizeChunker) Chunk(doc Document) []Chunk {
+	chunks := []Chunk{}
+	for _, text := range c.split(doc.Text) {
+		chunks = append(chunks, newChunk(doc, len(chunks), "", text))
+	}
+	return chunks
+}
+
+// split cuts the text into windows of size characters
+func (c *FixedSizeChunker) split(text string) []string {
+	runes := []rune(strings.TrimSpace(text))
+	windows := []string{}
+	for start := 0; start < len(runes); start += c.size - c.overlap {
+		end := min(start+c.size, len(runes))
+		if window := strings.TrimSpace(string(runes[start:end])); window != "" {
+			windows = append(windows, window)
+		}
+		if end == len(runes) {
+			break
+		}
+	}
+	return windows
+}
+
+// MarkdownChunker splits markdown documents at their headings, so that every
+// chunk belongs to one section. Sections longer than the maximum size are
+// split further into fixed size chunks
+type MarkdownChunker struct {
+	sections *FixedSizeChunker
+}
+
+// Constructor for a new MarkdownChunker, sections longer than maxSize
+// characters are split into chunks overlapping by overlap characters
+func NewMarkdownChunker(maxSize int, overlap int) (*MarkdownChunker, error) {
+	sections, err := NewFixedSizeChunker(maxSize, overlap)
+	if err != nil {
+		return nil, err
+	}
+	return &MarkdownChunker{sections: sections}, nil
+}
+
+// Chunk splits the document at its headings. Headings inside code blocks are ignored
+func (c *MarkdownChunker) Chunk(doc Document) []Chunk {
+	chunks := []Chunk{}
+	headings := []string{}
+	body := []string{}
+	inCodeBlock := false
+
+	flush := func() {
+		// Skipped heading levels leave empty placeholders
+		path := []string{}
+		for _, heading := range headings {
+			if heading != "" {
+				path = append(path, heading)
+			}
+		}
+		heading := strings.Join(path, " > ")
+		for _, text := range c.sections.split(strings.Join(body, "\n")) {
+			chunks = append(chunks, newChunk(doc, len(chunks), heading, text))
+		}
+		body = body[:0]
+	}
+
+	for _, line := range strings.Split(doc.Text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inCodeBlock = !inCodeBlock
+		}
+		level, title := markdownHeading(line)
+		if inCodeBlock || level == 0 {
+			body = append(body, line)
+			continue
+		}
+
+		flush()
+		// Keep the parent headings of this level
+		for len(headings) < level-1 {
+			headings = append(headings, "")
+		}
+		headings = append(headings[:level-1], title)
+	}
+	flush()
+
+	return chunks
+}
+
+// markdownHeading returns the level and title of an ATX heading line,
+// level 0 if the line isn't a heading
+func markdownHeading(line string) (int, string) {
+	if len(line)-len(strings.TrimLeft(line, " ")) > 3 {
+		return 0, ""
+	}
+	line = strings.TrimLeft(line, " ")
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	if level == 0 || level > 6 {
+		return 0, ""
+	}
+	rest := line[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return 0, ""
+	}
+	title := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(rest), "#"))
+	return level, title
+}
+
+// newChunk creates the index-th chunk of a document
+func newChunk(doc Document, index int, heading string, text string) Chunk {
+	return Chunk{
+		Id:         fmt.Sprintf("%s#%d", doc.Id, index),
+		DocumentId: doc.Id,
+		Source:     doc.Source,
+		Heading:    heading,
+		Index:      index,
+		Text:       text,
+		Metadata:   maps.Clone(doc.Metadata),
+	}
+}
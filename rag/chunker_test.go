@@ -0,0 +1,102 @@
+package rag
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func chunkTexts(chunks []Chunk) []string {
+	texts := []string{}
+	for _, chunk := range chunks {
+		texts = append(texts, chunk.Text)
+	}
+	return texts
+}
+
+func TestNewFixedSizeChunker_Invalid(t *testing.T) {
+	_, err := NewFixedSizeChunker(0, 0)
+	assert.Error(t, err)
+	_, err = NewFixedSizeChunker(10, 10)
+	assert.Error(t, err)
+	_, err = NewFixedSizeChunker(10, -1)
+	assert.Error(t, err)
+}
+
+func TestFixedSizeChunker(t *testing.T) {
+	chunker, err := NewFixedSizeChunker(4, 1)
+	assert.NoError(t, err)
+
+	chunks := chunker.Chunk(Document{Id: "doc", Source: "doc.txt", Text: "abcdefghij", Metadata: map[string]string{"lang": "en"}})
+	assert.Equal(t, []string{"abcd", "defg", "ghij"}, chunkTexts(chunks))
+	assert.Equal(t, "doc#1", chunks[1].Id)
+	assert.Equal(t, "doc", chunks[1].DocumentId)
+	assert.Equal(t, 1, chunks[1].Index)
+	assert.Equal(t, "doc.txt", chunks[1].Citation())
+	assert.Equal(t, "en", chunks[2].Metadata["lang"])
+}
+
+func TestFixedSizeChunker_Characters(t *testing.T) {
+	chunker, err := NewFixedSizeChunker(2, 0)
+	assert.NoError(t, err)
+
+	// Chunks are cut at characters, not bytes
+	chunks := chunker.Chunk(Document{Id: "doc", Text: "おにぎり"})
+	assert.Equal(t, []string{"おに", "ぎり"}, chunkTexts(chunks))
+
+	assert.Empty(t, chunker.Chunk(Document{Id: "empty", Text: "  "}))
+}
+
+const guide = `Intro text.
+
+# Setup
+
+## Install
+Run go get.
+
+` + "```sh\n# not a heading\ngo get example.com\n```" + `
+
+## Configure
+Set the key.
+
+# Usage
+#### Deep
+Deep text.
+`
+
+func TestMarkdownChunker(t *testing.T) {
+	chunker, err := NewMarkdownChunker(1000, 0)
+	assert.NoError(t, err)
+
+	chunks := chunker.Chunk(Document{Id: "guide", Source: "guide.md", Text: guide})
+	headings := []string{}
+	for _, chunk := range chunks {
+		headings = append(headings, chunk.Heading)
+	}
+	assert.Equal(t, []string{"", "Setup > Install", "Setup > Configure", "Usage > Deep"}, headings)
+	assert.Equal(t, "Run go get.\n\n```sh\n# not a heading\ngo get example.com\n```", chunks[1].Text)
+	assert.Equal(t, "guide.md > Setup > Configure", chunks[2].Citation())
+	assert.Equal(t, "guide#3", chunks[3].Id)
+}
+
+func TestMarkdownChunker_SplitsLongSections(t *testing.T) {
+	chunker, err := NewMarkdownChunker(5, 0)
+	assert.NoError(t, err)
+
+	chunks := chunker.Chunk(Document{Id: "doc", Text: "# Title\n0123456789"})
+	assert.Equal(t, []string{"01234", "56789"}, chunkTexts(chunks))
+	assert.Equal(t, "Title", chunks[1].Heading)
+}
+
+func TestMarkdownHeading(t *testing.T) {
+	level, title := markdownHeading("## Install ##")
+	assert.Equal(t, 2, level)
+	assert.Equal(t, "Install", title)
+
+	level, _ = markdownHeading("#hashtag")
+	assert.Equal(t, 0, level)
+	level, _ = markdownHeading("####### too deep")
+	assert.Equal(t, 0, level)
+	level, _ = markdownHeading("    # indented code")
+	assert.Equal(t, 0, level)
+}
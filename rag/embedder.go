@@ -0,0 +1,9 @@
+package rag
+
+import "context"
+
+// Embedder turns texts into embedding vectors. Implementations return
+// one vector per text, all of the same dimension
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
@@ -0,0 +1,135 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Option type for HybridRetriever
+type HybridOption func(*HybridRetriever)
+
+// HybridRetriever fuses the results of a keyword and a vector retriever with
+// reciprocal rank fusion. Each chunk scores the sum of weight / (k + rank) over
+// the retrievers that found it, so chunks ranked high by both come first
+type HybridRetriever struct {
+	keyword       Retriever
+	vector        Retriever
+	keywordWeight float64
+	vectorWeight  float64
+	rankConstant  int
+	candidates    int
+}
+
+// Constructor for a new HybridRetriever, e.g. over a BM25Index and a VectorIndex
+func NewHybridRetriever(keyword Retriever, vector Retriever, opts ...HybridOption) *HybridRetriever {
+	h := &HybridRetriever{
+		keyword:       keyword,
+		vector:        vector,
+		keywordWeight: 1,
+		vectorWeight:  1,
+		rankConstant:  60,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Functional option to weight the keyword and vector results, both default to 1
+func WithWeights(keyword float64, vector float64) HybridOption {
+	return func(h *HybridRetriever) {
+		h.keywordWeight = keyword
+		h.vectorWeight = vector
+	}
+}
+
+// Functional option to set the rank constant k of the fusion, defaults to 60.
+// Lower values favor the top ranks of each retriever more strongly
+func WithRankConstant(k int) HybridOption {
+	return func(h *HybridRetriever) {
+		h.rankConstant = k
+	}
+}
+
+// Functional option to set how many results are fetched from each retriever
+// before fusing, defaults to four times the number of requested results
+func WithCandidates(candidates int) HybridOption {
+	return func(h *HybridRetriever) {
+		h.candidates = candidates
+	}
+}
+
+// Retrieve queries both retrievers concurrently and returns the k best fused
+// results. The score of a result is its fused score
+func (h *HybridRetriever) Retrieve(ctx context.Context, query string, k int) ([]Result, error) {
+	if k <= 0 {
+		return []Result{}, nil
+	}
+	candidates := h.candidates
+	if candidates <= 0 {
+		candidates = 4 * k
+	}
+
+	var keywordResults, vectorResults []Result
+	var keywordErr, vectorErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		keywordResults, keywordErr = h.keyword.Retrieve(ctx, query, candidates)
+	}()
+	go func() {
+		defer wg.Done()
+		vectorResults, vectorErr = h.vector.Retrieve(ctx, query, candidates)
+	}()
+	wg.Wait()
+
+	if err := errors.Join(keywordErr, vectorErr); err != nil {
+		return nil, fmt.Errorf("hybrid retrieval failed: %w", err)
+	}
+
+	return h.fuse(k, keywordResults, vectorResults), nil
+}
+
+// fuse combines the ranked result lists with reciprocal rank fusion
+func (h *HybridRetriever) fuse(k int, keywordResults []Result, vectorResults []Result) []Result {
+	type fused struct {
+		chunk Chunk
+		score float64
+		order int
+	}
+	byId := map[string]*fused{}
+	add := func(results []Result, weight float64) {
+		for rank, result := range results {
+			entry, ok := byId[result.Chunk.Id]
+			if !ok {
+				entry = &fused{chunk: result.Chunk, order: len(byId)}
+				byId[result.Chunk.Id] = entry
+			}
+			entry.score += weight / float64(h.rankConstant+rank+1)
+		}
+	}
+	add(keywordResults, h.keywordWeight)
+	add(vectorResults, h.vectorWeight)
+
+	entries := make([]*fused, 0, len(byId))
+	for _, entry := range byId {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b *fused) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.order, b.order)
+	})
+
+	results := make([]Result, 0, min(k, len(entries)))
+	for _, entry := range entries[:min(k, len(entries))] {
+		results = append(results, Result{Chunk: entry.chunk, Score: entry.score})
+	}
+	return results
+}
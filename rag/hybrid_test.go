@@ -0,0 +1,80 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/robnmrz/onigiri/prompt"
+	"github.com/stretchr/testify/assert"
+)
+
+func rankedResults(ids ...string) []Result {
+	results := []Result{}
+	for _, id := range ids {
+		results = append(results, Result{Chunk: Chunk{Id: id}})
+	}
+	return results
+}
+
+func TestHybridRetriever_Fusion(t *testing.T) {
+	keyword := &StaticRetriever{results: rankedResults("a", "b", "c")}
+	vector := &StaticRetriever{results: rankedResults("c", "b", "d")}
+	hybrid := NewHybridRetriever(keyword, vector)
+
+	results, err := hybrid.Retrieve(context.Background(), "query", 4)
+	assert.NoError(t, err)
+
+	// c is first and third, b second in both lists, a and d only show up once
+	assert.Equal(t, []string{"c", "b", "a", "d"}, resultIds(results))
+	assert.InDelta(t, 1.0/61+1.0/63, results[0].Score, 1e-9)
+	assert.InDelta(t, 2.0/62, results[1].Score, 1e-9)
+	assert.Equal(t, "query", keyword.query)
+	assert.Equal(t, "query", vector.query)
+}
+
+func TestHybridRetriever_Weights(t *testing.T) {
+	keyword := &StaticRetriever{results: rankedResults("a")}
+	vector := &StaticRetriever{results: rankedResults("b")}
+
+	results, err := NewHybridRetriever(keyword, vector, WithWeights(1, 2), WithRankConstant(1)).Retrieve(context.Background(), "query", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, resultIds(results))
+	assert.Equal(t, 1.0, results[0].Score)
+}
+
+func TestHybridRetriever_Error(t *testing.T) {
+	keyword := &StaticRetriever{results: rankedResults("a")}
+	vector := &StaticRetriever{err: errors.New("embedder down")}
+
+	_, err := NewHybridRetriever(keyword, vector).Retrieve(context.Background(), "query", 1)
+	assert.ErrorContains(t, err, "embedder down")
+}
+
+func TestHybridRetriever_KeywordAndVectorIndex(t *testing.T) {
+	docs := []Chunk{
+		{Id: "rice", Source: "food.md", Text: "rice and fish"},
+		{Id: "tea", Source: "drinks.md", Text: "tea"},
+		{Id: "code", Source: "errors.md", Text: "E1042 appears when fish is missing"},
+	}
+	keyword := NewBM25Index()
+	keyword.Add(docs...)
+	vector := NewVectorIndex(&FakeEmbedder{})
+	assert.NoError(t, vector.Add(context.Background(), docs...))
+
+	// The embedder doesn't know E1042, so vector search alone misses the chunk
+	results, err := vector.Retrieve(context.Background(), "E1042", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rice", "tea"}, resultIds(results))
+
+	hybrid := NewHybridRetriever(keyword, vector, WithCandidates(2))
+	results, err = hybrid.Retrieve(context.Background(), "E1042", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"code"}, resultIds(results))
+
+	// It works as a context provider like any other retriever
+	spg := prompt.NewSystemPromptGenerator(prompt.WithFallibleContextProvider("docs", NewRetrievalProvider("Documents", hybrid, WithTopK(1))))
+	generated, err := spg.GeneratePrompt(prompt.ContextWithUserInput(context.Background(), "E1042"))
+	assert.NoError(t, err)
+	assert.Contains(t, generated, "[1] E1042 appears when fish is missing\nSource: errors.md")
+}
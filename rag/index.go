@@ -0,0 +1,256 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/robnmrz/onigiri/utils"
+)
+
+// VectorIndex is an in-memory vector index ranking chunks by the cosine
+// similarity of their embeddings to the query's. It is safe for concurrent use
+type VectorIndex struct {
+	embedder Embedder
+
+	mu         sync.RWMutex
+	dimensions int
+	entries    []indexEntry
+	// positions maps chunk ids to their position in entries
+	positions map[string]int
+}
+
+// indexEntry is an indexed chunk with its normalized embedding
+type indexEntry struct {
+	Chunk  Chunk     `json:"chunk"`
+	Vector []float32 `json:"vector"`
+}
+
+// indexFile is the persisted form of a VectorIndex
+type indexFile struct {
+	Dimensions int          `json:"dimensions"`
+	Entries    []indexEntry `json:"entries"`
+}
+
+// Constructor for a new empty VectorIndex using the embedder for chunks and queries
+func NewVectorIndex(embedder Embedder) *VectorIndex {
+	return &VectorIndex{embedder: embedder, positions: map[string]int{}}
+}
+
+// LoadVectorIndex reads an index saved with Save. The embedder has to be the
+// one the index was built with, otherwise queries are compared to unrelated vectors
+func LoadVectorIndex(path string, embedder Embedder) (*VectorIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vector index: %w", err)
+	}
+	var file indexFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode vector index: %w", err)
+	}
+	for _, entry := range file.Entries {
+		if len(entry.Vector) != file.Dimensions {
+			return nil, fmt.Errorf("vector of chunk %s has %d dimensions, expected %d", entry.Chunk.Id, len(entry.Vector), file.Dimensions)
+		}
+	}
+	vi := &VectorIndex{embedder: embedder, dimensions: file.Dimensions, entries: file.Entries}
+	vi.reindex()
+	return vi, nil
+}
+
+// Save writes the index to a json file, replacing it atomically
+func (vi *VectorIndex) Save(path string) error {
+	vi.mu.RLock()
+	data, err := json.Marshal(indexFile{Dimensions: vi.dimensions, Entries: vi.entries})
+	vi.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode vector index: %w", err)
+	}
+
+	if err := utils.WriteFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to save vector index: %w", err)
+	}
+	return nil
+}
+
+// AddDocuments chunks the documents and adds the chunks to the index.
+// Documents that are already indexed are replaced, including chunks the
+// new version of the document no longer has
+func (vi *VectorIndex) AddDocuments(ctx context.Context, chunker Chunker, docs ...Document) error {
+	chunks := []Chunk{}
+	documentIds := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		chunks = append(chunks, chunker.Chunk(doc)...)
+		documentIds = append(documentIds, doc.Id)
+	}
+	return vi.add(ctx, documentIds, chunks)
+}
+
+// Add embeds the chunks and adds them to the index.
+// Chunks with an id that is already indexed replace the indexed one
+func (vi *VectorIndex) Add(ctx context.Context, chunks ...Chunk) error {
+	return vi.add(ctx, nil, chunks)
+}
+
+// add embeds the chunks and adds them to the index after removing all chunks
+// of the replaced documents. Nothing changes if the batch fails
+func (vi *VectorIndex) add(ctx context.Context, replaced []string, chunks []Chunk) error {
+	if len(chunks) == 0 && len(replaced) == 0 {
+		return nil
+	}
+	var vectors [][]float32
+	if len(chunks) > 0 {
+		texts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			texts[i] = chunk.Text
+		}
+		var err error
+		if vectors, err = vi.embed(ctx, texts); err != nil {
+			return err
+		}
+	}
+
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	// The first batch fixes the dimensions, but only once it is valid
+	dimensions := vi.dimensions
+	for _, vector := range vectors {
+		if dimensions == 0 {
+			dimensions = len(vector)
+		}
+		if len(vector) != dimensions {
+			return fmt.Errorf("embedding has %d dimensions, the index has %d", len(vector), dimensions)
+		}
+	}
+	vi.dimensions = dimensions
+
+	if len(replaced) > 0 {
+		vi.entries = slices.DeleteFunc(vi.entries, func(e indexEntry) bool { return slices.Contains(replaced, e.Chunk.DocumentId) })
+		vi.reindex()
+	}
+	for i, chunk := range chunks {
+		entry := indexEntry{Chunk: chunk, Vector: normalize(vectors[i])}
+		if index, ok := vi.positions[chunk.Id]; ok {
+			vi.entries[index] = entry
+		} else {
+			vi.positions[chunk.Id] = len(vi.entries)
+			vi.entries = append(vi.entries, entry)
+		}
+	}
+	return nil
+}
+
+// RemoveDocument removes all chunks of a document from the index
+func (vi *VectorIndex) RemoveDocument(documentId string) {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	vi.entries = slices.DeleteFunc(vi.entries, func(e indexEntry) bool { return e.Chunk.DocumentId == documentId })
+	vi.reindex()
+}
+
+// reindex rebuilds the positions of the chunk ids after entries moved,
+// requires vi.mu to be held for writing
+func (vi *VectorIndex) reindex() {
+	vi.positions = make(map[string]int, len(vi.entries))
+	for i, entry := range vi.entries {
+		vi.positions[entry.Chunk.Id] = i
+	}
+}
+
+// Len returns the number of indexed chunks
+func (vi *VectorIndex) Len() int {
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+
+	return len(vi.entries)
+}
+
+// Retrieve returns the k chunks most similar to the query
+func (vi *VectorIndex) Retrieve(ctx context.Context, query string, k int) ([]Result, error) {
+	vectors, err := vi.embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	return vi.Search(vectors[0], k)
+}
+
+// Search returns the k chunks most similar to the query vector
+func (vi *VectorIndex) Search(vector []float32, k int) ([]Result, error) {
+	vi.mu.RLock()
+	defer vi.mu.RUnlock()
+
+	if len(vi.entries) == 0 || k <= 0 {
+		return []Result{}, nil
+	}
+	if len(vector) != vi.dimensions {
+		return nil, fmt.Errorf("query has %d dimensions, the index has %d", len(vector), vi.dimensions)
+	}
+
+	query := normalize(vector)
+	results := make([]Result, len(vi.entries))
+	for i, entry := range vi.entries {
+		results[i] = Result{Chunk: entry.Chunk, Score: dot(query, entry.Vector)}
+	}
+	// Ties keep insertion order
+	slices.SortStableFunc(results, func(a, b Result) int { return cmp.Compare(b.Score, a.Score) })
+	return results[:min(k, len(results))], nil
+}
+
+// embed embeds the texts, checking that there is a vector for each
+func (vi *VectorIndex) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if vi.embedder == nil {
+		return nil, errors.New("vector index has no embedder")
+	}
+	vectors, err := vi.embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed texts: %w", err)
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("embedder returned %d vectors for %d texts", len(vectors), len(texts))
+	}
+	return vectors, nil
+}
+
+// CosineSimilarity returns the cosine similarity of two vectors of the same
+// length, between -1 and 1. It is 0 if either vector is zero
+func CosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	return dot(normalize(a), normalize(b))
+}
+
+// normalize scales a vector to unit length, so that the dot product of
+// normalized vectors is their cosine similarity. Zero vectors stay zero
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	normalized := make([]float32, len(vector))
+	if norm == 0 {
+		return normalized
+	}
+	norm = math.Sqrt(norm)
+	for i, v := range vector {
+		normalized[i] = float32(float64(v) / norm)
+	}
+	return normalized
+}
+
+// dot returns the dot product of two vectors of the same length
+func dot(a []float32, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,210 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake embedder counting the words of a small vocabulary
+type FakeEmbedder struct {
+	calls atomic.Int32
+	err   error
+}
+
+var vocabulary = []string{"rice", "fish", "salt", "tea", "install", "configure"}
+
+func (e *FakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls.Add(1)
+	if e.err != nil {
+		return nil, e.err
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(vocabulary))
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			for j, known := range vocabulary {
+				if strings.Trim(word, ".,?!") == known {
+					vectors[i][j]++
+				}
+			}
+		}
+	}
+	return vectors, nil
+}
+
+func newTestIndex(t *testing.T) *VectorIndex {
+	t.Helper()
+	index := NewVectorIndex(&FakeEmbedder{})
+	err := index.Add(context.Background(),
+		Chunk{Id: "a", DocumentId: "food", Source: "food.md", Text: "rice and fish"},
+		Chunk{Id: "b", DocumentId: "food", Source: "food.md", Text: "salt salt rice"},
+		Chunk{Id: "c", DocumentId: "drinks", Source: "drinks.md", Text: "green tea"},
+	)
+	assert.NoError(t, err)
+	return index
+}
+
+func resultIds(results []Result) []string {
+	ids := []string{}
+	for _, result := range results {
+		ids = append(ids, result.Chunk.Id)
+	}
+	return ids
+}
+
+func TestVectorIndex_Retrieve(t *testing.T) {
+	index := newTestIndex(t)
+
+	results, err := index.Retrieve(context.Background(), "fish with rice", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, resultIds(results))
+	assert.InDelta(t, 1.0, results[0].Score, 1e-6)
+	assert.Greater(t, results[0].Score, results[1].Score)
+
+	// Asking for more results than there are chunks returns all of them
+	results, err = index.Retrieve(context.Background(), "tea", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "a", "b"}, resultIds(results))
+}
+
+func TestVectorIndex_AddReplacesAndRemoves(t *testing.T) {
+	index := newTestIndex(t)
+	assert.NoError(t, index.Add(context.Background(), Chunk{Id: "a", DocumentId: "food", Text: "tea"}))
+	assert.Equal(t, 3, index.Len())
+
+	results, err := index.Retrieve(context.Background(), "tea", 2)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "c"}, resultIds(results))
+
+	index.RemoveDocument("food")
+	assert.Equal(t, 1, index.Len())
+
+	// Chunks that moved after a removal are still replaced in place
+	assert.NoError(t, index.Add(context.Background(), Chunk{Id: "c", DocumentId: "drinks", Text: "rice"}))
+	assert.Equal(t, 1, index.Len())
+	results, err = index.Retrieve(context.Background(), "rice", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "rice", results[0].Chunk.Text)
+}
+
+func TestVectorIndex_AddDocuments(t *testing.T) {
+	index := NewVectorIndex(&FakeEmbedder{})
+	chunker, err := NewMarkdownChunker(100, 0)
+	assert.NoError(t, err)
+
+	err = index.AddDocuments(context.Background(), chunker,
+		Document{Id: "guide", Source: "guide.md", Text: "# Install\nHow to install.\n# Configure\nHow to configure."})
+	assert.NoError(t, err)
+
+	results, err := index.Retrieve(context.Background(), "configure", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "guide.md > Configure", results[0].Chunk.Citation())
+}
+
+func TestVectorIndex_AddDocumentsReplacesDocument(t *testing.T) {
+	index := NewVectorIndex(&FakeEmbedder{})
+	chunker, err := NewMarkdownChunker(100, 0)
+	assert.NoError(t, err)
+
+	err = index.AddDocuments(context.Background(), chunker,
+		Document{Id: "guide", Source: "guide.md", Text: "# Install\nHow to install.\n# Configure\nHow to configure."})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, index.Len())
+
+	// The new version has fewer chunks, the old ones are gone
+	err = index.AddDocuments(context.Background(), chunker,
+		Document{Id: "guide", Source: "guide.md", Text: "# Install\nHow to install."})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, index.Len())
+
+	results, err := index.Retrieve(context.Background(), "configure", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "guide.md > Install", results[0].Chunk.Citation())
+}
+
+// Fake embedder returning vectors with the given number of dimensions per text
+type DimensionsEmbedder struct {
+	dimensions []int
+}
+
+func (e *DimensionsEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i := range texts {
+		vectors[i] = make([]float32, e.dimensions[i])
+	}
+	return vectors, nil
+}
+
+func TestVectorIndex_InvalidBatchKeepsDimensions(t *testing.T) {
+	embedder := &DimensionsEmbedder{dimensions: []int{2, 3}}
+	index := NewVectorIndex(embedder)
+	err := index.Add(context.Background(), Chunk{Id: "a", Text: "rice"}, Chunk{Id: "b", Text: "fish"})
+	assert.ErrorContains(t, err, "3 dimensions, the index has 2")
+	assert.Equal(t, 0, index.Len())
+
+	// The failed batch didn't fix the dimensions of the index
+	embedder.dimensions = []int{3}
+	assert.NoError(t, index.Add(context.Background(), Chunk{Id: "a", Text: "rice"}))
+	assert.Equal(t, 1, index.Len())
+}
+
+func TestVectorIndex_Errors(t *testing.T) {
+	index := NewVectorIndex(&FakeEmbedder{err: errors.New("quota exceeded")})
+	err := index.Add(context.Background(), Chunk{Id: "a", Text: "rice"})
+	assert.ErrorContains(t, err, "quota exceeded")
+
+	index = newTestIndex(t)
+	_, err = index.Search([]float32{1, 2}, 1)
+	assert.ErrorContains(t, err, "2 dimensions")
+
+	// An empty index has no results
+	results, err := NewVectorIndex(&FakeEmbedder{}).Retrieve(context.Background(), "rice", 3)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestVectorIndex_SaveAndLoad(t *testing.T) {
+	index := newTestIndex(t)
+	path := filepath.Join(t.TempDir(), "index.json")
+	assert.NoError(t, index.Save(path))
+
+	embedder := &FakeEmbedder{}
+	loaded, err := LoadVectorIndex(path, embedder)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, loaded.Len())
+
+	results, err := loaded.Retrieve(context.Background(), "salt", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", results[0].Chunk.Id)
+	assert.Equal(t, "food.md", results[0].Chunk.Source)
+
+	// Only the query is embedded, the chunks are not embedded again
+	assert.Equal(t, int32(1), embedder.calls.Load())
+
+	// Loaded chunks are replaced by id
+	assert.NoError(t, loaded.Add(context.Background(), Chunk{Id: "b", DocumentId: "food", Text: "tea"}))
+	assert.Equal(t, 3, loaded.Len())
+
+	_, err = LoadVectorIndex(filepath.Join(t.TempDir(), "missing.json"), embedder)
+	assert.Error(t, err)
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, []float32{0.6, 0.8}, normalize([]float32{3, 4}))
+	assert.Equal(t, []float32{0, 0}, normalize([]float32{0, 0}))
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	assert.InDelta(t, 1.0, CosineSimilarity([]float32{1, 2}, []float32{2, 4}), 1e-6)
+	assert.InDelta(t, 0.0, CosineSimilarity([]float32{1, 0}, []float32{0, 3}), 1e-6)
+	assert.InDelta(t, -1.0, CosineSimilarity([]float32{1, 1}, []float32{-1, -1}), 1e-6)
+	assert.Equal(t, 0.0, CosineSimilarity([]float32{0, 0}, []float32{1, 1}))
+	assert.Equal(t, 0.0, CosineSimilarity([]float32{1}, []float32{1, 1}))
+}
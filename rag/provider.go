@@ -0,0 +1,105 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/robnmrz/onigiri/prompt"
+)
+
+// Option type for RetrievalProvider
+type RetrievalOption func(*RetrievalProvider)
+
+// RetrievalProvider is a context provider retrieving the chunks relevant to
+// the user input the prompt is generated for, see prompt.ContextWithUserInput.
+// Every chunk is numbered and cited with its source so the model can refer to it
+type RetrievalProvider struct {
+	title     string
+	retriever Retriever
+	topK      int
+	minScore  float64
+	query     func(input any) string
+}
+
+// Constructor for a new RetrievalProvider retrieving the top 4 chunks by default
+func NewRetrievalProvider(title string, retriever Retriever, opts ...RetrievalOption) *RetrievalProvider {
+	p := &RetrievalProvider{
+		title:     title,
+		retriever: retriever,
+		topK:      4,
+		query:     DefaultQuery,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Functional option to set the number of chunks retrieved
+func WithTopK(k int) RetrievalOption {
+	return func(p *RetrievalProvider) {
+		p.topK = k
+	}
+}
+
+// Functional option to leave out chunks scoring lower than minScore
+func WithMinScore(minScore float64) RetrievalOption {
+	return func(p *RetrievalProvider) {
+		p.minScore = minScore
+	}
+}
+
+// Functional option to set how the query is built from the user input,
+// by default strings are used as is and other inputs are encoded as json
+func WithQuery(query func(input any) string) RetrievalOption {
+	return func(p *RetrievalProvider) {
+		p.query = query
+	}
+}
+
+// DefaultQuery builds a query from a user input, strings are used as is
+// and other inputs are encoded as json
+func DefaultQuery(input any) string {
+	if text, ok := input.(string); ok {
+		return text
+	}
+	jsonBytes, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Sprint(input)
+	}
+	return string(jsonBytes)
+}
+
+// GetInfo retrieves the chunks relevant to the user input. Without user input
+// or relevant chunks the info is empty
+func (p *RetrievalProvider) GetInfo(ctx context.Context) (string, error) {
+	input, ok := prompt.UserInputFromContext(ctx)
+	if !ok {
+		return "", nil
+	}
+	query := strings.TrimSpace(p.query(input))
+	if query == "" {
+		return "", nil
+	}
+
+	results, err := p.retriever.Retrieve(ctx, query, p.topK)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve chunks: %w", err)
+	}
+
+	blocks := []string{}
+	for _, result := range results {
+		if result.Score < p.minScore {
+			continue
+		}
+		blocks = append(blocks, fmt.Sprintf("[%d] %s\nSource: %s", len(blocks)+1, result.Chunk.Text, result.Chunk.Citation()))
+	}
+	return strings.Join(blocks, "\n\n"), nil
+}
+
+// GetTitle returns the title of the provider
+func (p *RetrievalProvider) GetTitle() string {
+	return p.title
+}
@@ -0,0 +1,91 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/robnmrz/onigiri/prompt"
+	"github.com/stretchr/testify/assert"
+)
+
+// Retriever returning fixed results
+type StaticRetriever struct {
+	results []Result
+	err     error
+	query   string
+}
+
+func (r *StaticRetriever) Retrieve(ctx context.Context, query string, k int) ([]Result, error) {
+	r.query = query
+	return r.results[:min(k, len(r.results))], r.err
+}
+
+func TestRetrievalProvider(t *testing.T) {
+	p := NewRetrievalProvider("Documents", newTestIndex(t), WithTopK(2))
+	ctx := prompt.ContextWithUserInput(context.Background(), "How much salt goes on rice?")
+
+	info, err := p.GetInfo(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "[1] salt salt rice\nSource: food.md\n\n[2] rice and fish\nSource: food.md", info)
+	assert.Equal(t, "Documents", p.GetTitle())
+}
+
+func TestRetrievalProvider_WithoutInput(t *testing.T) {
+	retriever := &StaticRetriever{}
+	info, err := NewRetrievalProvider("Documents", retriever).GetInfo(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, info)
+	assert.Empty(t, retriever.query)
+}
+
+func TestRetrievalProvider_MinScoreAndQuery(t *testing.T) {
+	retriever := &StaticRetriever{results: []Result{
+		{Chunk: Chunk{Text: "relevant", Source: "a.md", Heading: "Intro"}, Score: 0.9},
+		{Chunk: Chunk{Text: "unrelated", Source: "b.md"}, Score: 0.1},
+	}}
+	type Question struct {
+		Text string `json:"text"`
+	}
+	p := NewRetrievalProvider("Documents", retriever, WithMinScore(0.5))
+
+	info, err := p.GetInfo(prompt.ContextWithUserInput(context.Background(), Question{Text: "hi"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "[1] relevant\nSource: a.md > Intro", info)
+	assert.Equal(t, `{"text":"hi"}`, retriever.query)
+
+	p = NewRetrievalProvider("Documents", retriever, WithQuery(func(input any) string { return input.(Question).Text }))
+	_, err = p.GetInfo(prompt.ContextWithUserInput(context.Background(), Question{Text: "hi"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", retriever.query)
+}
+
+func TestRetrievalProvider_Error(t *testing.T) {
+	retriever := &StaticRetriever{err: errors.New("index unavailable")}
+	_, err := NewRetrievalProvider("Documents", retriever).GetInfo(prompt.ContextWithUserInput(context.Background(), "hi"))
+	assert.ErrorContains(t, err, "index unavailable")
+}
+
+func TestRetrievalProvider_InPrompt(t *testing.T) {
+	spg := prompt.NewSystemPromptGenerator(
+		prompt.WithFallibleContextProvider("docs", NewRetrievalProvider("Documents", newTestIndex(t), WithTopK(1))),
+	)
+
+	generated, err := spg.GeneratePrompt(prompt.ContextWithUserInput(context.Background(), "tea please"))
+	assert.NoError(t, err)
+	assert.Contains(t, generated, "# Documents\n- [1] green tea\nSource: drinks.md")
+}
+
+func TestRetrievalProvider_InPromptWithoutResults(t *testing.T) {
+	spg := prompt.NewSystemPromptGenerator(
+		prompt.WithBackground([]string{"Be helpful."}),
+		prompt.WithFallibleContextProvider("docs", NewRetrievalProvider("Documents", &StaticRetriever{})),
+	)
+
+	// Without input or results the provider's section is left out
+	for _, ctx := range []context.Context{context.Background(), prompt.ContextWithUserInput(context.Background(), "hi")} {
+		generated, err := spg.GeneratePrompt(ctx)
+		assert.NoError(t, err)
+		assert.NotContains(t, generated, "Documents")
+	}
+}
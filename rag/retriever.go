@@ -0,0 +1,14 @@
+package rag
+
+import "context"
+
+// Result is a retrieved chunk with its relevance score, higher is more relevant
+type Result struct {
+	Chunk Chunk
+	Score float64
+}
+
+// Retriever finds the k chunks most relevant to a query, most relevant first
+type Retriever interface {
+	Retrieve(ctx context.Context, query string, k int) ([]Result, error)
+}
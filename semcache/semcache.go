@@ -0,0 +1,192 @@
+package semcache
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/rag"
+)
+
+// Option type for Cache
+type Option func(*Cache)
+
+// entry is a cached answer with the embedding of its input
+type entry struct {
+	input      string
+	promptHash string
+	vector     []float32
+	response   agent.CompletionResponse
+	created    time.Time
+}
+
+// Cache is an agent.SemanticCache comparing the embeddings of user inputs.
+// Entries are kept in memory and compared one by one, which is fast enough
+// for the few thousand questions of a FAQ. It is safe for concurrent use
+type Cache struct {
+	embedder   rag.Embedder
+	threshold  float64
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries []entry
+	// lastInput and lastVector remember the last embedded input,
+	// so storing the answer to a missed lookup doesn't embed it again
+	lastInput  string
+	lastVector []float32
+}
+
+// Constructor for a new, empty Cache embedding inputs with the embedder
+func New(embedder rag.Embedder, opts ...Option) *Cache {
+	c := &Cache{
+		embedder:   embedder,
+		threshold:  0.9,
+		maxEntries: 1000,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Functional option to set the similarity from which an input counts as
+// a paraphrase of a cached one, defaults to 0.9
+func WithThreshold(threshold float64) Option {
+	return func(c *Cache) {
+		c.threshold = threshold
+	}
+}
+
+// Functional option to set the number of cached answers,
+// the oldest ones are evicted first. Defaults to 1000
+func WithMaxEntries(maxEntries int) Option {
+	return func(c *Cache) {
+		c.maxEntries = max(maxEntries, 1)
+	}
+}
+
+// Functional option to set how long answers are cached, 0 keeps them forever
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.ttl = ttl
+	}
+}
+
+// Lookup returns the answer to the most similar cached input
+// with the same prompt hash, if it reaches the threshold
+func (c *Cache) Lookup(ctx context.Context, input string, promptHash string) (agent.SemanticCacheHit, bool, error) {
+	vector, err := c.embed(ctx, input)
+	if err != nil {
+		return agent.SemanticCacheHit{}, false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpired()
+	best := -1
+	bestSimilarity := 0.0
+	for i, cached := range c.entries {
+		if cached.promptHash != promptHash {
+			continue
+		}
+		if similarity := rag.CosineSimilarity(vector, cached.vector); similarity >= c.threshold && (best < 0 || similarity > bestSimilarity) {
+			best = i
+			bestSimilarity = similarity
+		}
+	}
+	if best < 0 {
+		return agent.SemanticCacheHit{}, false, nil
+	}
+	return agent.SemanticCacheHit{
+		Response:   c.entries[best].response,
+		Input:      c.entries[best].input,
+		Similarity: bestSimilarity,
+	}, true, nil
+}
+
+// Store caches the answer to the input, replacing an earlier
+// answer to the same input and prompt hash
+func (c *Cache) Store(ctx context.Context, input string, promptHash string, response agent.CompletionResponse) error {
+	vector, err := c.embed(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = slices.DeleteFunc(c.entries, func(cached entry) bool {
+		return cached.input == input && cached.promptHash == promptHash
+	})
+	c.entries = append(c.entries, entry{
+		input:      input,
+		promptHash: promptHash,
+		vector:     vector,
+		response:   response,
+		created:    c.now(),
+	})
+	if overflow := len(c.entries) - c.maxEntries; overflow > 0 {
+		c.entries = slices.Delete(c.entries, 0, overflow)
+	}
+	return nil
+}
+
+// Len returns the number of cached answers
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpired()
+	return len(c.entries)
+}
+
+// Clear removes all cached answers, e.g. after the underlying FAQ changed
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = nil
+}
+
+// embed returns the embedding of the input, reusing the last one if possible
+func (c *Cache) embed(ctx context.Context, input string) ([]float32, error) {
+	c.mu.Lock()
+	if c.lastVector != nil && c.lastInput == input {
+		vector := c.lastVector
+		c.mu.Unlock()
+		return vector, nil
+	}
+	c.mu.Unlock()
+
+	vectors, err := c.embedder.Embed(ctx, []string{input})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed input: %w", err)
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embedder returned %d vectors for 1 input", len(vectors))
+	}
+
+	c.mu.Lock()
+	c.lastInput = input
+	c.lastVector = vectors[0]
+	c.mu.Unlock()
+	return vectors[0], nil
+}
+
+// evictExpired removes the entries older than the ttl, the caller holds the lock
+func (c *Cache) evictExpired() {
+	if c.ttl <= 0 {
+		return
+	}
+	cutoff := c.now().Add(-c.ttl)
+	c.entries = slices.DeleteFunc(c.entries, func(cached entry) bool {
+		return !cached.created.After(cutoff)
+	})
+}
@@ -0,0 +1,167 @@
+package semcache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Fake embedder counting the words of a small vocabulary
+type FakeEmbedder struct {
+	calls int
+	err   error
+}
+
+var vocabulary = []string{"open", "opening", "hours", "when", "refund", "price", "today"}
+
+func (e *FakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(vocabulary))
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			for j, known := range vocabulary {
+				if strings.Trim(word, ".,?!") == known {
+					vectors[i][j]++
+				}
+			}
+		}
+	}
+	return vectors, nil
+}
+
+// Mock LLM client
+type MockLLMClient struct {
+	mock.Mock
+}
+
+func (m *MockLLMClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	args := m.Called(messages, model)
+	return args.Get(0).(agent.CompletionResponse), args.Error(1)
+}
+
+func TestCache_LookupParaphrase(t *testing.T) {
+	ctx := context.Background()
+	embedder := &FakeEmbedder{}
+	cache := New(embedder, WithThreshold(0.8))
+
+	assert.NoError(t, cache.Store(ctx, "When are you open?", "hash", agent.CompletionResponse{Prompt: "9 to 5"}))
+	assert.NoError(t, cache.Store(ctx, "Can I get a refund?", "hash", agent.CompletionResponse{Prompt: "Yes"}))
+
+	hit, ok, err := cache.Lookup(ctx, "When are you open today?", "hash")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "9 to 5", hit.Response.Prompt)
+	assert.Equal(t, "When are you open?", hit.Input)
+	assert.InDelta(t, 0.816, hit.Similarity, 0.001)
+
+	// Unrelated questions and other prompts miss
+	_, ok, err = cache.Lookup(ctx, "What is the price?", "hash")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = cache.Lookup(ctx, "When are you open?", "other-hash")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCache_ReusesLookupEmbedding(t *testing.T) {
+	ctx := context.Background()
+	embedder := &FakeEmbedder{}
+	cache := New(embedder)
+
+	_, ok, err := cache.Lookup(ctx, "When are you open?", "hash")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, cache.Store(ctx, "When are you open?", "hash", agent.CompletionResponse{Prompt: "9 to 5"}))
+	assert.Equal(t, 1, embedder.calls)
+}
+
+func TestCache_ReplacesAndEvicts(t *testing.T) {
+	ctx := context.Background()
+	cache := New(&FakeEmbedder{}, WithMaxEntries(2))
+
+	assert.NoError(t, cache.Store(ctx, "When are you open?", "hash", agent.CompletionResponse{Prompt: "9 to 5"}))
+	assert.NoError(t, cache.Store(ctx, "When are you open?", "hash", agent.CompletionResponse{Prompt: "8 to 6"}))
+	assert.Equal(t, 1, cache.Len())
+	hit, ok, err := cache.Lookup(ctx, "When are you open?", "hash")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "8 to 6", hit.Response.Prompt)
+
+	// The oldest answer is evicted first
+	assert.NoError(t, cache.Store(ctx, "Can I get a refund?", "hash", agent.CompletionResponse{Prompt: "Yes"}))
+	assert.NoError(t, cache.Store(ctx, "What is the price?", "hash", agent.CompletionResponse{Prompt: "10 Euro"}))
+	assert.Equal(t, 2, cache.Len())
+	_, ok, _ = cache.Lookup(ctx, "When are you open?", "hash")
+	assert.False(t, ok)
+
+	cache.Clear()
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestCache_TTL(t *testing.T) {
+	ctx := context.Background()
+	cache := New(&FakeEmbedder{}, WithTTL(time.Hour))
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	assert.NoError(t, cache.Store(ctx, "When are you open?", "hash", agent.CompletionResponse{Prompt: "9 to 5"}))
+	now = now.Add(59 * time.Minute)
+	_, ok, _ := cache.Lookup(ctx, "When are you open?", "hash")
+	assert.True(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok, _ = cache.Lookup(ctx, "When are you open?", "hash")
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestCache_EmbedderError(t *testing.T) {
+	cache := New(&FakeEmbedder{err: errors.New("quota exceeded")})
+
+	_, _, err := cache.Lookup(context.Background(), "When are you open?", "hash")
+	assert.ErrorContains(t, err, "failed to embed input: quota exceeded")
+	err = cache.Store(context.Background(), "When are you open?", "hash", agent.CompletionResponse{})
+	assert.ErrorContains(t, err, "quota exceeded")
+}
+
+func TestCache_WithAgent(t *testing.T) {
+	cache := New(&FakeEmbedder{}, WithThreshold(0.8))
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, "test-model").Return(agent.CompletionResponse{Prompt: "9 to 5"}, nil).Once()
+
+	for _, question := range []string{"When are you open?", "When are you open today?"} {
+		a, err := agent.NewBaseAgent(agent.WithClient(client), agent.WithModel("test-model"), agent.WithSemanticCache(cache))
+		assert.NoError(t, err)
+
+		response, err := a.Run(context.Background(), question)
+		assert.NoError(t, err)
+		assert.Equal(t, "9 to 5", response.Prompt)
+	}
+	client.AssertNumberOfCalls(t, "CreateCompletion", 1)
+}
+
+func TestCache_WithAgentEmbedderError(t *testing.T) {
+	cache := New(&FakeEmbedder{err: errors.New("quota exceeded")})
+	client := new(MockLLMClient)
+	client.On("CreateCompletion", mock.Anything, "test-model").Return(agent.CompletionResponse{Prompt: "9 to 5"}, nil)
+	a, err := agent.NewBaseAgent(agent.WithClient(client), agent.WithModel("test-model"), agent.WithSemanticCache(cache))
+	assert.NoError(t, err)
+
+	// The model answers while the embedder is down
+	response, err := a.Run(context.Background(), "When are you open?")
+	assert.NoError(t, err)
+	assert.Equal(t, "9 to 5", response.Prompt)
+	assert.Equal(t, 0, cache.Len())
+}
@@ -0,0 +1,407 @@
+package session
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+)
+
+// ManagerOption defines the functional option type for the Manager
+type ManagerOption func(*Manager) error
+
+// session is a loaded session with its own agent
+type session struct {
+	id string
+	// ready is closed once the agent is built or loading failed
+	ready chan struct{}
+	agent *agent.BaseAgent
+	err   error
+	// refs counts callers currently using the session, sessions
+	// in use are never evicted
+	refs int
+	elem *list.Element
+}
+
+// Manager builds one BaseAgent per session from a shared template.
+// Session memories are loaded lazily from the Store and saved when
+// a session is evicted, idle sessions are evicted least recently used
+// first once more than the maximum number of sessions are loaded.
+type Manager struct {
+	mu sync.Mutex
+
+	store         Store
+	agentOptions  []agent.AgentOption
+	memoryOptions []memory.MemoryOption
+	maxSessions   int
+	saveAfterRun  bool
+	// runSlots bounds the number of in-flight runs, nil means unbounded
+	runSlots chan struct{}
+
+	sessions map[string]*session
+	lru      *list.List
+	// evicting holds sessions whose memory is being saved after eviction,
+	// the channel is closed when saving is done
+	evicting map[string]chan struct{}
+	// unsaved holds the agents of evicted sessions whose memory couldn't be
+	// saved while the session was requested again, they are used instead of
+	// loading the stale saved memory
+	unsaved map[string]*agent.BaseAgent
+	// onEvictionError is called when saving an evicted session fails
+	onEvictionError func(sessionId string, err error)
+}
+
+// WithAgentOptions sets the template every session's agent is built from,
+// e.g. client, model, system prompt generator and model parameters.
+// A memory set in the template is replaced by the session's memory.
+func WithAgentOptions(opts ...agent.AgentOption) ManagerOption {
+	return func(m *Manager) error {
+		m.agentOptions = append(m.agentOptions, opts...)
+		return nil
+	}
+}
+
+// WithMemoryOptions sets the options used to create the memory
+// of sessions that have not been saved before.
+func WithMemoryOptions(opts ...memory.MemoryOption) ManagerOption {
+	return func(m *Manager) error {
+		m.memoryOptions = append(m.memoryOptions, opts...)
+		return nil
+	}
+}
+
+// WithMaxSessions sets the number of sessions kept loaded, 0 means unlimited.
+func WithMaxSessions(maxSessions int) ManagerOption {
+	return func(m *Manager) error {
+		if maxSessions < 0 {
+			return errors.New("max sessions cannot be negative")
+		}
+		m.maxSessions = maxSessions
+		return nil
+	}
+}
+
+// WithMaxInFlight bounds the number of concurrent runs across all sessions,
+// 0 means unbounded.
+func WithMaxInFlight(maxInFlight int) ManagerOption {
+	return func(m *Manager) error {
+		if maxInFlight < 0 {
+			return errors.New("max in-flight runs cannot be negative")
+		}
+		m.runSlots = nil
+		if maxInFlight > 0 {
+			m.runSlots = make(chan struct{}, maxInFlight)
+		}
+		return nil
+	}
+}
+
+// WithSaveAfterRun saves a session's memory after every successful run,
+// in addition to saving it on eviction.
+func WithSaveAfterRun() ManagerOption {
+	return func(m *Manager) error {
+		m.saveAfterRun = true
+		return nil
+	}
+}
+
+// WithEvictionErrorHook sets a function called when the memory of an evicted
+// session can't be saved. The session stays loaded in that case and saving
+// is retried on its next eviction.
+func WithEvictionErrorHook(hook func(sessionId string, err error)) ManagerOption {
+	return func(m *Manager) error {
+		m.onEvictionError = hook
+		return nil
+	}
+}
+
+// NewManager creates a new session manager backed by the given store.
+// The agent template is validated by building an agent from it once.
+func NewManager(store Store, opts ...ManagerOption) (*Manager, error) {
+	if store == nil {
+		return nil, errors.New("session store cannot be nil")
+	}
+
+	m := &Manager{
+		store:    store,
+		sessions: map[string]*session{},
+		lru:      list.New(),
+		evicting: map[string]chan struct{}{},
+		unsaved:  map[string]*agent.BaseAgent{},
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, fmt.Errorf("failed to apply manager option: %w", err)
+		}
+	}
+
+	if _, err := agent.NewBaseAgent(m.agentOptions...); err != nil {
+		return nil, fmt.Errorf("invalid agent template: %w", err)
+	}
+	return m, nil
+}
+
+// Run runs the session's agent with the user input, loading the session
+// if needed. Waiting for a free run slot is cancelled with the context.
+func (m *Manager) Run(ctx context.Context, sessionId string, userInput any) (agent.CompletionResponse, error) {
+	if m.runSlots != nil {
+		select {
+		case m.runSlots <- struct{}{}:
+			defer func() { <-m.runSlots }()
+		case <-ctx.Done():
+			return agent.CompletionResponse{}, fmt.Errorf("waiting for run slot: %w", ctx.Err())
+		}
+	}
+
+	var response agent.CompletionResponse
+	err := m.WithSession(ctx, sessionId, func(a *agent.BaseAgent) error {
+		var err error
+		response, err = a.Run(ctx, userInput)
+		if err != nil {
+			return err
+		}
+		if m.saveAfterRun {
+			return m.store.Save(ctx, sessionId, a.GetMemory())
+		}
+		return nil
+	})
+	return response, err
+}
+
+// WithSession calls fn with the session's agent, loading the session if
+// needed. The session is not evicted while fn runs.
+func (m *Manager) WithSession(ctx context.Context, sessionId string, fn func(*agent.BaseAgent) error) error {
+	s, err := m.acquire(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	defer m.release(ctx, s)
+
+	return fn(s.agent)
+}
+
+// acquire returns the loaded session, loading it first if needed
+func (m *Manager) acquire(ctx context.Context, sessionId string) (*session, error) {
+	m.mu.Lock()
+	if s, ok := m.sessions[sessionId]; ok {
+		s.refs++
+		m.lru.MoveToFront(s.elem)
+		m.mu.Unlock()
+
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			m.release(ctx, s)
+			return nil, ctx.Err()
+		}
+		if s.err != nil {
+			m.release(ctx, s)
+			return nil, s.err
+		}
+		return s, nil
+	}
+
+	s := &session{id: sessionId, ready: make(chan struct{}), refs: 1}
+	s.elem = m.lru.PushFront(s)
+	m.sessions[sessionId] = s
+	pendingSave := m.evicting[sessionId]
+	evicted := m.evictLocked()
+	m.mu.Unlock()
+
+	m.saveEvicted(ctx, evicted)
+
+	// Don't load a memory that is still being saved, and continue
+	// with the evicted agent if saving it failed
+	if pendingSave != nil {
+		<-pendingSave
+
+		m.mu.Lock()
+		unsaved := m.unsaved[sessionId]
+		delete(m.unsaved, sessionId)
+		m.mu.Unlock()
+		if unsaved != nil {
+			s.agent = unsaved
+			close(s.ready)
+			return s, nil
+		}
+	}
+
+	s.agent, s.err = m.load(ctx, sessionId)
+	if s.err != nil {
+		// Drop the failed session so the next call retries loading
+		m.mu.Lock()
+		s.refs--
+		m.removeLocked(s)
+		m.mu.Unlock()
+		close(s.ready)
+		return nil, s.err
+	}
+	close(s.ready)
+	return s, nil
+}
+
+// release gives up a reference taken by acquire and
+// evicts sessions if the manager is over capacity
+func (m *Manager) release(ctx context.Context, s *session) {
+	m.mu.Lock()
+	s.refs--
+	evicted := m.evictLocked()
+	m.mu.Unlock()
+
+	m.saveEvicted(ctx, evicted)
+}
+
+// load builds the agent for a session from the template and the saved memory
+func (m *Manager) load(ctx context.Context, sessionId string) (*agent.BaseAgent, error) {
+	mem, err := m.store.Load(ctx, sessionId)
+	if errors.Is(err, ErrSessionNotFound) {
+		mem = memory.NewAgentMemory(m.memoryOptions...)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load session %s: %w", sessionId, err)
+	}
+
+	opts := append(append([]agent.AgentOption{}, m.agentOptions...), agent.WithMemory(mem))
+	a, err := agent.NewBaseAgent(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create agent for session %s: %w", sessionId, err)
+	}
+	return a, nil
+}
+
+// evictLocked removes idle sessions, least recently used first, until the
+// manager is within capacity. The evicted sessions are marked as being
+// saved and must be passed to saveEvicted. Requires m.mu to be held
+func (m *Manager) evictLocked() []*session {
+	if m.maxSessions == 0 {
+		return nil
+	}
+
+	var evicted []*session
+	for elem := m.lru.Back(); elem != nil && m.lru.Len() > m.maxSessions; {
+		s := elem.Value.(*session)
+		elem = elem.Prev()
+		if s.refs > 0 {
+			continue
+		}
+		m.removeLocked(s)
+		m.evicting[s.id] = make(chan struct{})
+		evicted = append(evicted, s)
+	}
+	return evicted
+}
+
+// removeLocked drops a session from the manager. Requires m.mu to be held
+func (m *Manager) removeLocked(s *session) {
+	if m.sessions[s.id] == s {
+		delete(m.sessions, s.id)
+		m.lru.Remove(s.elem)
+	}
+}
+
+// saveEvicted saves the memories of evicted sessions. Saving errors can't
+// be returned to the caller that happened to trigger the eviction, so they
+// are reported to the eviction error hook and the session is kept loaded
+// to not lose its memory
+func (m *Manager) saveEvicted(ctx context.Context, evicted []*session) {
+	for _, s := range evicted {
+		err := m.store.Save(context.WithoutCancel(ctx), s.id, s.agent.GetMemory())
+
+		m.mu.Lock()
+		if err != nil {
+			m.keepUnsavedLocked(s)
+		}
+		close(m.evicting[s.id])
+		delete(m.evicting, s.id)
+		m.mu.Unlock()
+
+		if err != nil && m.onEvictionError != nil {
+			m.onEvictionError(s.id, fmt.Errorf("failed to save session %s: %w", s.id, err))
+		}
+	}
+}
+
+// keepUnsavedLocked keeps an evicted session whose memory couldn't be saved.
+// A reload waiting for the eviction takes over its agent, otherwise it is
+// loaded again as least recently used, so saving is retried on the next
+// eviction. Requires m.mu to be held
+func (m *Manager) keepUnsavedLocked(s *session) {
+	if _, reloading := m.sessions[s.id]; reloading {
+		m.unsaved[s.id] = s.agent
+		return
+	}
+	m.sessions[s.id] = s
+	s.elem = m.lru.PushBack(s)
+}
+
+// Evict saves the session's memory and unloads it. Sessions
+// that are not loaded are ignored. If saving fails, the session
+// stays loaded and the error is returned.
+func (m *Manager) Evict(ctx context.Context, sessionId string) error {
+	m.mu.Lock()
+	s, ok := m.sessions[sessionId]
+	if !ok {
+		m.mu.Unlock()
+		return nil
+	}
+	if s.refs > 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("session %s is in use", sessionId)
+	}
+	m.removeLocked(s)
+	done := make(chan struct{})
+	m.evicting[sessionId] = done
+	m.mu.Unlock()
+
+	err := m.store.Save(ctx, sessionId, s.agent.GetMemory())
+
+	m.mu.Lock()
+	if err != nil {
+		m.keepUnsavedLocked(s)
+	}
+	close(done)
+	delete(m.evicting, sessionId)
+	m.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to save session %s: %w", sessionId, err)
+	}
+	return nil
+}
+
+// Flush saves the memories of all loaded sessions without unloading them.
+func (m *Manager) Flush(ctx context.Context) error {
+	m.mu.Lock()
+	loaded := make([]*session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		loaded = append(loaded, s)
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, s := range loaded {
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if s.err != nil {
+			continue
+		}
+		if err := m.store.Save(ctx, s.id, s.agent.GetMemory()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save session %s: %w", s.id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Len returns the number of loaded sessions.
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.sessions)
+}
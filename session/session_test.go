@@ -0,0 +1,333 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robnmrz/onigiri/agent"
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake LLM client answering with the number of messages it received
+type FakeLLMClient struct {
+	delay    time.Duration
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+}
+
+func (c *FakeLLMClient) CreateCompletion(ctx context.Context, messages []memory.Message, responseSchema reflect.Type, model string, modelApiParameters map[string]any) (agent.CompletionResponse, error) {
+	current := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	for {
+		seen := c.maxSeen.Load()
+		if current <= seen || c.maxSeen.CompareAndSwap(seen, current) {
+			break
+		}
+	}
+	time.Sleep(c.delay)
+	return agent.CompletionResponse{Prompt: fmt.Sprintf("%d messages", len(messages))}, nil
+}
+
+func newTestManager(t *testing.T, store Store, opts ...ManagerOption) *Manager {
+	t.Helper()
+	opts = append([]ManagerOption{
+		WithAgentOptions(agent.WithClient(&FakeLLMClient{}), agent.WithModel("test-model")),
+	}, opts...)
+	m, err := NewManager(store, opts...)
+	assert.NoError(t, err)
+	return m
+}
+
+func TestNewManager_InvalidTemplate(t *testing.T) {
+	_, err := NewManager(NewInMemoryStore(), WithAgentOptions(agent.WithModel("")))
+	assert.Error(t, err)
+}
+
+func TestNewManager_NilStore(t *testing.T) {
+	_, err := NewManager(nil)
+	assert.Error(t, err)
+}
+
+func TestRun_SessionsAreIsolated(t *testing.T) {
+	m := newTestManager(t, NewInMemoryStore())
+	ctx := context.Background()
+
+	_, err := m.Run(ctx, "alice", "Hello")
+	assert.NoError(t, err)
+	response, err := m.Run(ctx, "alice", "Hello again")
+	assert.NoError(t, err)
+	// System prompt plus three messages
+	assert.Equal(t, "4 messages", response.Prompt)
+
+	response, err = m.Run(ctx, "bob", "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "2 messages", response.Prompt)
+	assert.Equal(t, 2, m.Len())
+}
+
+func TestRun_EvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewInMemoryStore()
+	m := newTestManager(t, store, WithMaxSessions(2))
+	ctx := context.Background()
+
+	for _, id := range []string{"alice", "bob", "alice", "carol"} {
+		_, err := m.Run(ctx, id, "Hello")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, m.Len())
+
+	// Bob was used least recently, so he was evicted and saved
+	saved, err := store.Load(ctx, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, saved.GetMessageCount())
+	_, err = store.Load(ctx, "alice")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// Bob's conversation continues after reloading
+	response, err := m.Run(ctx, "bob", "Still there?")
+	assert.NoError(t, err)
+	assert.Equal(t, "4 messages", response.Prompt)
+}
+
+// Store failing to save while broken is set
+type FlakyStore struct {
+	*InMemoryStore
+	broken atomic.Bool
+	// release holds saves back until it is closed, if set
+	release chan struct{}
+}
+
+func (s *FlakyStore) Save(ctx context.Context, sessionId string, mem *memory.AgentMemory) error {
+	if s.release != nil {
+		<-s.release
+	}
+	if s.broken.Load() {
+		return errors.New("disk full")
+	}
+	return s.InMemoryStore.Save(ctx, sessionId, mem)
+}
+
+func TestRun_KeepsSessionsThatFailedToSave(t *testing.T) {
+	store := &FlakyStore{InMemoryStore: NewInMemoryStore()}
+	store.broken.Store(true)
+	var failed []string
+	m := newTestManager(t, store, WithMaxSessions(1), WithEvictionErrorHook(func(sessionId string, err error) {
+		assert.ErrorContains(t, err, "disk full")
+		failed = append(failed, sessionId)
+	}))
+	ctx := context.Background()
+
+	for _, id := range []string{"alice", "bob"} {
+		_, err := m.Run(ctx, id, "Hello")
+		assert.NoError(t, err)
+	}
+	// Alice couldn't be saved, so she stays loaded and her conversation
+	// continues. Saving is retried whenever sessions are evicted
+	assert.NotEmpty(t, failed)
+	for _, id := range failed {
+		assert.Equal(t, "alice", id)
+	}
+	assert.Equal(t, 2, m.Len())
+	response, err := m.Run(ctx, "alice", "Still there?")
+	assert.NoError(t, err)
+	assert.Equal(t, "4 messages", response.Prompt)
+
+	// Once the store works again, the evicted sessions are saved
+	store.broken.Store(false)
+	_, err = m.Run(ctx, "carol", "Hello")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, m.Len())
+	saved, err := store.Load(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, saved.GetMessageCount())
+}
+
+func TestEvict_KeepsSessionThatFailedToSave(t *testing.T) {
+	store := &FlakyStore{InMemoryStore: NewInMemoryStore()}
+	store.broken.Store(true)
+	m := newTestManager(t, store)
+	ctx := context.Background()
+
+	_, err := m.Run(ctx, "alice", "Hello")
+	assert.NoError(t, err)
+	err = m.Evict(ctx, "alice")
+	assert.ErrorContains(t, err, "failed to save session alice: disk full")
+
+	// Alice stays loaded and her conversation continues
+	assert.Equal(t, 1, m.Len())
+	response, err := m.Run(ctx, "alice", "Still there?")
+	assert.NoError(t, err)
+	assert.Equal(t, "4 messages", response.Prompt)
+
+	store.broken.Store(false)
+	assert.NoError(t, m.Evict(ctx, "alice"))
+	assert.Equal(t, 0, m.Len())
+}
+
+func TestEvict_ReloadWaitsForFailedSave(t *testing.T) {
+	store := &FlakyStore{InMemoryStore: NewInMemoryStore()}
+	m := newTestManager(t, store)
+	ctx := context.Background()
+	_, err := m.Run(ctx, "alice", "Hello")
+	assert.NoError(t, err)
+
+	store.broken.Store(true)
+	store.release = make(chan struct{})
+	evicted := make(chan error)
+	go func() { evicted <- m.Evict(ctx, "alice") }()
+	assert.Eventually(t, func() bool { return m.Len() == 0 }, time.Second, time.Millisecond)
+
+	// A run during the failing eviction continues with the evicted memory
+	// instead of loading the older saved one
+	ran := make(chan string)
+	go func() {
+		response, err := m.Run(ctx, "alice", "Still there?")
+		assert.NoError(t, err)
+		ran <- response.Prompt
+	}()
+	assert.Eventually(t, func() bool { return m.Len() == 1 }, time.Second, time.Millisecond)
+	close(store.release)
+
+	assert.Error(t, <-evicted)
+	assert.Equal(t, "4 messages", <-ran)
+}
+
+func TestRun_SaveAfterRun(t *testing.T) {
+	store := NewInMemoryStore()
+	m := newTestManager(t, store, WithSaveAfterRun())
+	ctx := context.Background()
+
+	_, err := m.Run(ctx, "alice", "Hello")
+	assert.NoError(t, err)
+
+	saved, err := store.Load(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, saved.GetMessageCount())
+}
+
+func TestRun_MemoryOptions(t *testing.T) {
+	m := newTestManager(t, NewInMemoryStore(), WithMemoryOptions(memory.WithMaxMessages(2)))
+	ctx := context.Background()
+
+	for range 3 {
+		_, err := m.Run(ctx, "alice", "Hello")
+		assert.NoError(t, err)
+	}
+	err := m.WithSession(ctx, "alice", func(a *agent.BaseAgent) error {
+		assert.Equal(t, 2, a.GetMemory().GetMessageCount())
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestRun_BoundsInFlightRuns(t *testing.T) {
+	client := &FakeLLMClient{delay: 10 * time.Millisecond}
+	m, err := NewManager(NewInMemoryStore(),
+		WithAgentOptions(agent.WithClient(client), agent.WithModel("test-model")),
+		WithMaxInFlight(2),
+	)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Run(context.Background(), fmt.Sprintf("user-%d", i), "Hello")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, client.maxSeen.Load(), int32(2))
+}
+
+func TestRun_ContextDoneWhileWaitingForSlot(t *testing.T) {
+	m := newTestManager(t, NewInMemoryStore(), WithMaxInFlight(1))
+	m.runSlots <- struct{}{}
+	defer func() { <-m.runSlots }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := m.Run(ctx, "alice", "Hello")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestConcurrentRuns_WithEviction(t *testing.T) {
+	store := NewInMemoryStore()
+	m := newTestManager(t, store, WithMaxSessions(3), WithMaxInFlight(4))
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Run(context.Background(), fmt.Sprintf("user-%d", i%7), "Hello")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, m.Flush(context.Background()))
+
+	// Every run ended up in exactly one saved memory
+	total := 0
+	for i := range 7 {
+		saved, err := store.Load(context.Background(), fmt.Sprintf("user-%d", i))
+		assert.NoError(t, err)
+		total += saved.GetMessageCount()
+	}
+	assert.Equal(t, 100, total)
+}
+
+func TestEvict(t *testing.T) {
+	store := NewInMemoryStore()
+	m := newTestManager(t, store)
+	ctx := context.Background()
+
+	_, err := m.Run(ctx, "alice", "Hello")
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.Evict(ctx, "alice"))
+	assert.Equal(t, 0, m.Len())
+	_, err = store.Load(ctx, "alice")
+	assert.NoError(t, err)
+
+	// Evicting a session that isn't loaded is a no-op
+	assert.NoError(t, m.Evict(ctx, "unknown"))
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = store.Load(ctx, "alice")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	mem := memory.NewAgentMemory()
+	mem.InitializeTurn()
+	mem.AddMessage("user", "Hello")
+	assert.NoError(t, store.Save(ctx, "alice", mem))
+
+	loaded, err := store.Load(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded.GetMessageCount())
+	assert.Equal(t, mem.GetTurnId(), loaded.GetTurnId())
+}
+
+func TestFileStore_InvalidSessionId(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	for _, id := range []string{"", "..", "../escape", `a\b`} {
+		err := store.Save(context.Background(), id, memory.NewAgentMemory())
+		assert.Error(t, err, id)
+	}
+}
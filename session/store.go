@@ -0,0 +1,129 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/robnmrz/onigiri/memory"
+	"github.com/robnmrz/onigiri/utils"
+)
+
+// ErrSessionNotFound is returned by a Store when no memory
+// has been saved for a session yet
+var ErrSessionNotFound = errors.New("session not found")
+
+// Store persists the memory of sessions between loads
+type Store interface {
+	Load(ctx context.Context, sessionId string) (*memory.AgentMemory, error)
+	Save(ctx context.Context, sessionId string, mem *memory.AgentMemory) error
+}
+
+// InMemoryStore keeps serialized session memories in a map.
+// Useful for tests and single process deployments
+type InMemoryStore struct {
+	mu       sync.RWMutex
+	memories map[string]string
+}
+
+// Constructor for a new, empty InMemoryStore
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{memories: map[string]string{}}
+}
+
+// Load deserializes the memory saved for the session
+func (s *InMemoryStore) Load(ctx context.Context, sessionId string) (*memory.AgentMemory, error) {
+	s.mu.RLock()
+	jsonString, ok := s.memories[sessionId]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	mem := memory.NewAgentMemory()
+	if err := mem.FromJson(jsonString); err != nil {
+		return nil, fmt.Errorf("failed to decode memory of session %s: %w", sessionId, err)
+	}
+	return mem, nil
+}
+
+// Save serializes the memory of the session, overwriting earlier saves
+func (s *InMemoryStore) Save(ctx context.Context, sessionId string, mem *memory.AgentMemory) error {
+	jsonString, err := mem.ToJson()
+	if err != nil {
+		return fmt.Errorf("failed to encode memory of session %s: %w", sessionId, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memories[sessionId] = jsonString
+	return nil
+}
+
+// FileStore saves each session's memory as a json file in a directory
+type FileStore struct {
+	dir string
+}
+
+// Constructor for a new FileStore, creating the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file path for a session, rejecting ids
+// that would escape the store's directory
+func (s *FileStore) path(sessionId string) (string, error) {
+	if sessionId == "" || sessionId == "." || sessionId == ".." || strings.ContainsAny(sessionId, `/\`) {
+		return "", fmt.Errorf("invalid session id %q", sessionId)
+	}
+	return filepath.Join(s.dir, sessionId+".json"), nil
+}
+
+// Load reads the memory file of the session
+func (s *FileStore) Load(ctx context.Context, sessionId string) (*memory.AgentMemory, error) {
+	path, err := s.path(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory of session %s: %w", sessionId, err)
+	}
+
+	mem := memory.NewAgentMemory()
+	if err := mem.FromJson(string(data)); err != nil {
+		return nil, fmt.Errorf("failed to decode memory of session %s: %w", sessionId, err)
+	}
+	return mem, nil
+}
+
+// Save writes the memory file of the session. The file is replaced
+// atomically so a crash never leaves a partially written memory behind
+func (s *FileStore) Save(ctx context.Context, sessionId string, mem *memory.AgentMemory) error {
+	path, err := s.path(sessionId)
+	if err != nil {
+		return err
+	}
+
+	jsonString, err := mem.ToJson()
+	if err != nil {
+		return fmt.Errorf("failed to encode memory of session %s: %w", sessionId, err)
+	}
+
+	if err := utils.WriteFileAtomic(path, []byte(jsonString)); err != nil {
+		return fmt.Errorf("failed to save memory of session %s: %w", sessionId, err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+
+	assert.NoError(t, WriteFileAtomic(path, []byte("first")))
+	assert.NoError(t, WriteFileAtomic(path, []byte("second")))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+func TestWriteFileAtomic_MissingDirectory(t *testing.T) {
+	err := WriteFileAtomic(filepath.Join(t.TempDir(), "missing", "data.json"), []byte("data"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -18,3 +18,92 @@ func GetTypeName(i any) string {
 
 	return t.Name()
 }
+
+// DeepCopy returns a deep copy of the value passed in. Pointers, slices,
+// maps, arrays, interfaces and exported struct fields are copied recursively,
+// unexported struct fields, channels and functions are copied shallowly.
+func DeepCopy(i any) any {
+	if i == nil {
+		return nil
+	}
+	visited := map[visitedPointer]reflect.Value{}
+	return deepCopyValue(reflect.ValueOf(i), visited).Interface()
+}
+
+// visitedPointer identifies a copied pointer. The type is part of the key
+// because a struct and its first field share the same address
+type visitedPointer struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// deepCopyValue recursively copies a reflect value. Already copied pointers
+// are tracked in visited so that cyclic values don't recurse forever
+func deepCopyValue(v reflect.Value, visited map[visitedPointer]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		key := visitedPointer{ptr: v.Pointer(), typ: v.Type()}
+		if c, ok := visited[key]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		visited[key] = c
+		c.Elem().Set(deepCopyValue(v.Elem(), visited))
+		return c
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopyValue(v.Elem(), visited))
+		return c
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := range v.Len() {
+			c.Index(i).Set(deepCopyValue(v.Index(i), visited))
+		}
+		return c
+
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := range v.Len() {
+			c.Index(i).Set(deepCopyValue(v.Index(i), visited))
+		}
+		return c
+
+	case reflect.Map:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(deepCopyValue(iter.Key(), visited), deepCopyValue(iter.Value(), visited))
+		}
+		return c
+
+	case reflect.Struct:
+		// Shallow copy first so unexported fields are kept
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := range v.NumField() {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopyValue(v.Field(i), visited))
+			}
+		}
+		return c
+
+	default:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		return c
+	}
+}
@@ -45,3 +45,80 @@ func TestGetTypeName_Nil(t *testing.T) {
 	name := GetTypeName(x)
 	assert.Equal(t, "", name)
 }
+
+// Dummy struct with nested mutable values for deep copy tests
+type NestedStruct struct {
+	Tags   []string
+	Attrs  map[string]any
+	Child  *MyStruct
+	hidden []int
+}
+
+func TestDeepCopy_Nil(t *testing.T) {
+	assert.Nil(t, DeepCopy(nil))
+}
+
+func TestDeepCopy_BasicType(t *testing.T) {
+	assert.Equal(t, "hello", DeepCopy("hello"))
+	assert.Equal(t, 123, DeepCopy(123))
+}
+
+func TestDeepCopy_Struct(t *testing.T) {
+	orig := NestedStruct{
+		Tags:   []string{"a", "b"},
+		Attrs:  map[string]any{"list": []any{1, 2}},
+		Child:  &MyStruct{Name: "child"},
+		hidden: []int{1},
+	}
+
+	copied := DeepCopy(orig).(NestedStruct)
+	assert.Equal(t, orig, copied)
+
+	// Mutating the copy must not change the original
+	copied.Tags[0] = "changed"
+	copied.Attrs["list"].([]any)[0] = 99
+	copied.Child.Name = "changed"
+	assert.Equal(t, "a", orig.Tags[0])
+	assert.Equal(t, 1, orig.Attrs["list"].([]any)[0])
+	assert.Equal(t, "child", orig.Child.Name)
+}
+
+func TestDeepCopy_Pointer(t *testing.T) {
+	orig := &MyStruct{Name: "test"}
+
+	copied := DeepCopy(orig).(*MyStruct)
+	assert.Equal(t, orig, copied)
+	assert.NotSame(t, orig, copied)
+}
+
+func TestDeepCopy_Cycle(t *testing.T) {
+	type node struct {
+		Next *node
+	}
+	orig := &node{}
+	orig.Next = orig
+
+	copied := DeepCopy(orig).(*node)
+	assert.NotSame(t, orig, copied)
+	assert.Same(t, copied, copied.Next)
+}
+
+func TestDeepCopy_PointerToFirstField(t *testing.T) {
+	type inner struct {
+		Name string
+	}
+	type outer struct {
+		Inner inner
+		Ptr   *inner
+		Self  *outer
+	}
+	orig := &outer{Inner: inner{Name: "first"}}
+	orig.Self = orig
+	// Points to the same address as orig, but with another type
+	orig.Ptr = &orig.Inner
+
+	copied := DeepCopy(orig).(*outer)
+	assert.Same(t, copied, copied.Self)
+	assert.Equal(t, "first", copied.Ptr.Name)
+	assert.NotSame(t, orig.Ptr, copied.Ptr)
+}